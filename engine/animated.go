@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"timelapse-queue/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AnimatedGIF  = "gif"
+	AnimatedWebP = "webp"
+
+	// Animated images are decoded whole by browsers and chat clients, so
+	// larger outputs are impractical.
+	maxAnimatedHeight = 720
+	maxAnimatedFPS    = 30
+	// defaultAnimatedFPS is the frame rate of animated images if unset.
+	defaultAnimatedFPS = 15
+
+	// mp4BitsPerPixel approximates the x264 output size per pixel per frame,
+	// at the CRF of full renders.
+	mp4BitsPerPixel = 0.15
+)
+
+// AnimatedFormat is a looping animated image format, an alternative to MP4
+// for quick sharing.
+type AnimatedFormat struct {
+	Name string
+	Ext  string
+	// BitsPerPixel approximates the output size per pixel per frame, for size
+	// estimates.
+	BitsPerPixel float64
+}
+
+var AnimatedFormats = []*AnimatedFormat{
+	{Name: AnimatedGIF, Ext: ".gif", BitsPerPixel: 3},
+	{Name: AnimatedWebP, Ext: ".webp", BitsPerPixel: 0.6},
+}
+
+func getAnimatedFormat(name string) *AnimatedFormat {
+	for _, f := range AnimatedFormats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// animatedArgs returns the FFmpeg filters and output arguments encoding an
+// animated image at fps.
+func animatedArgs(format string, fps int) (filters, args []string) {
+	filters = []string{fmt.Sprintf("fps=%d", fps)}
+	switch format {
+	case AnimatedGIF:
+		// The palette needs the whole sequence, so frames are encoded
+		// losslessly first and converted by encodeGIF.
+		args = []string{"-c:v", "ffv1", "-f", "matroska"}
+	case AnimatedWebP:
+		args = []string{
+			"-c:v", "libwebp",
+			"-lossless", "0",
+			"-q:v", "75",
+			"-preset", "picture",
+			"-loop", "0",
+			"-f", "webp",
+		}
+	}
+	return filters, args
+}
+
+// gifIntermediate returns the path of the lossless intermediate for a GIF.
+func gifIntermediate(out string) string {
+	return out + ".mkv"
+}
+
+// gifPalette returns the path of the palette generated for a GIF.
+func gifPalette(out string) string {
+	return out + ".palette.png"
+}
+
+// encodeGIF converts a lossless intermediate video to an optimized GIF, first
+// generating a palette over all frames, then mapping frames to it.
+func encodeGIF(ctx context.Context, logger *log.Logger, in, out string) error {
+	palette := gifPalette(out)
+	defer os.Remove(palette)
+	defer os.Remove(in)
+
+	passes := [][]string{
+		{"-i", in, "-vf", "palettegen=stats_mode=diff", "-y", palette},
+		{"-i", in, "-i", palette, "-lavfi", "paletteuse=dither=sierra2_4a:diff_mode=rectangle", "-loop", "0", "-f", "gif", out},
+	}
+	for i, args := range passes {
+		args = append([]string{"-loglevel", "level+info"}, args...)
+		logger.Infof("Running FFmpeg GIF pass %d with args: %v", i+1, args)
+		cmd := exec.CommandContext(ctx, util.LocateFFmpegOrDie(), args...)
+		output, err := cmd.CombinedOutput()
+		for _, l := range strings.Split(string(output), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				logger.Info(l)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("GIF pass %d failed: %v", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAnimatedArgs(t *testing.T) {
+	tests := []struct {
+		format      string
+		fps         int
+		wantFilters []string
+		wantArgs    []string
+	}{
+		{
+			format:      AnimatedGIF,
+			fps:         15,
+			wantFilters: []string{"fps=15"},
+			wantArgs:    []string{"-c:v", "ffv1", "-f", "matroska"},
+		},
+		{
+			format:      AnimatedWebP,
+			fps:         24,
+			wantFilters: []string{"fps=24"},
+			wantArgs: []string{
+				"-c:v", "libwebp", "-lossless", "0", "-q:v", "75",
+				"-preset", "picture", "-loop", "0", "-f", "webp",
+			},
+		},
+	}
+	for _, tc := range tests {
+		filters, args := animatedArgs(tc.format, tc.fps)
+		if diff := cmp.Diff(tc.wantFilters, filters); diff != "" {
+			t.Errorf("animatedArgs(%q) filters diff: %v", tc.format, diff)
+		}
+		if diff := cmp.Diff(tc.wantArgs, args); diff != "" {
+			t.Errorf("animatedArgs(%q) args diff: %v", tc.format, diff)
+		}
+	}
+}
+
+func TestGetAnimatedFPS(t *testing.T) {
+	tests := []struct {
+		fps, animatedFPS, want int
+	}{
+		{want: defaultAnimatedFPS},
+		{fps: 10, want: 10},
+		{fps: 30, animatedFPS: 24, want: 24},
+	}
+	for _, tc := range tests {
+		f := &baseConfig{FrameRate: tc.fps, AnimatedFPS: tc.animatedFPS}
+		if got := f.GetAnimatedFPS(); got != tc.want {
+			t.Errorf("GetAnimatedFPS() with %d, %d = %d, want %d", tc.fps, tc.animatedFPS, got, tc.want)
+		}
+	}
+}
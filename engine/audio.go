@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"timelapse-queue/util"
+)
+
+const (
+	// audioBitrate is the AAC bitrate of muxed audio tracks.
+	audioBitrate        = "192k"
+	audioBytesPerSecond = 192000 / 8
+)
+
+// AudioOptions describes an audio track muxed into the output video.
+type AudioOptions struct {
+	// Path is the absolute path of the audio file.
+	Path string
+	// Start trims this many seconds from the start of the audio.
+	Start float64
+	// FadeIn and FadeOut are fade durations in seconds, zero for none.
+	FadeIn, FadeOut float64
+	// Loop repeats the audio if shorter than the video, otherwise it ends
+	// early.
+	Loop bool
+}
+
+// audioArgs returns the FFmpeg input and output arguments muxing the audio
+// track into a video of duration seconds, the video being input 0.
+func audioArgs(a *AudioOptions, duration float64) (input, output []string) {
+	if a.Loop {
+		input = append(input, "-stream_loop", "-1")
+	}
+	if a.Start > 0 {
+		input = append(input, "-ss", fmt.Sprintf("%.3f", a.Start))
+	}
+	input = append(input, "-i", a.Path)
+
+	var fades []string
+	if a.FadeIn > 0 {
+		fades = append(fades, fmt.Sprintf("afade=t=in:st=0:d=%.3f", a.FadeIn))
+	}
+	if a.FadeOut > 0 {
+		fades = append(fades, fmt.Sprintf("afade=t=out:st=%.3f:d=%.3f", duration-a.FadeOut, a.FadeOut))
+	}
+	output = []string{"-map", "0:v", "-map", "1:a:0"}
+	if len(fades) > 0 {
+		output = append(output, "-af", strings.Join(fades, ","))
+	}
+	output = append(output,
+		"-c:a", "aac",
+		"-b:a", audioBitrate,
+		// Cut the audio to the video length.
+		"-t", fmt.Sprintf("%.3f", duration),
+	)
+	return input, output
+}
+
+// checkAudio decodes the start of the audio track at path from start seconds,
+// failing if there is no decodable audio.
+func checkAudio(ctx context.Context, path string, start float64) error {
+	args := []string{
+		"-v", "error",
+		"-ss", fmt.Sprintf("%.3f", start),
+		"-i", path,
+		"-map", "0:a:0",
+		"-t", "1",
+		"-f", "null", "-",
+	}
+	out, err := exec.CommandContext(ctx, util.LocateFFmpegOrDie(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("audio file %v is not decodable: %v: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// validateAudio checks the audio track options, and that the file decodes.
+// It resolves the path of the audio file, so that Convert uses the file
+// checked.
+func (f *baseConfig) validateAudio(ctx context.Context) error {
+	if f.AudioPath == "" {
+		return nil
+	}
+	if f.Still != "" || f.Export || f.RenameOnly || (f.Animated != "" && !f.Preview) {
+		return fmt.Errorf("Audio is only supported for MP4 output")
+	}
+	if f.AudioStart < 0 || f.AudioFadeIn < 0 || f.AudioFadeOut < 0 {
+		return fmt.Errorf("audio trim and fades must not be negative")
+	}
+	duration := float64(f.GetExpectedFrames()) / float64(f.GetFPS())
+	if f.AudioFadeIn+f.AudioFadeOut > duration {
+		return fmt.Errorf("audio fades longer than the %.1fs video", duration)
+	}
+	p, err := f.browser.GetFullPath(f.AudioPath)
+	if err != nil {
+		return fmt.Errorf("audio file: %v", err)
+	}
+	f.audioPath = p
+	return checkAudio(ctx, p, f.AudioStart)
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAudioArgs(t *testing.T) {
+	encode := []string{"-c:a", "aac", "-b:a", audioBitrate}
+	tests := []struct {
+		name       string
+		audio      AudioOptions
+		duration   float64
+		wantInput  []string
+		wantOutput []string
+	}{
+		{
+			name:       "plain",
+			audio:      AudioOptions{Path: "/music/a.mp3"},
+			duration:   10,
+			wantInput:  []string{"-i", "/music/a.mp3"},
+			wantOutput: append(append([]string{"-map", "0:v", "-map", "1:a:0"}, encode...), "-t", "10.000"),
+		},
+		{
+			name:       "trim and loop",
+			audio:      AudioOptions{Path: "/music/a.mp3", Start: 12.5, Loop: true},
+			duration:   90.25,
+			wantInput:  []string{"-stream_loop", "-1", "-ss", "12.500", "-i", "/music/a.mp3"},
+			wantOutput: append(append([]string{"-map", "0:v", "-map", "1:a:0"}, encode...), "-t", "90.250"),
+		},
+		{
+			name:      "fade in",
+			audio:     AudioOptions{Path: "/music/a.mp3", FadeIn: 2},
+			duration:  10,
+			wantInput: []string{"-i", "/music/a.mp3"},
+			wantOutput: append(append([]string{"-map", "0:v", "-map", "1:a:0",
+				"-af", "afade=t=in:st=0:d=2.000"}, encode...), "-t", "10.000"),
+		},
+		{
+			name:      "fade out ends with the video",
+			audio:     AudioOptions{Path: "/music/a.mp3", FadeOut: 3},
+			duration:  10,
+			wantInput: []string{"-i", "/music/a.mp3"},
+			wantOutput: append(append([]string{"-map", "0:v", "-map", "1:a:0",
+				"-af", "afade=t=out:st=7.000:d=3.000"}, encode...), "-t", "10.000"),
+		},
+		{
+			name:      "both fades",
+			audio:     AudioOptions{Path: "/music/a.mp3", Start: 1, FadeIn: 1.5, FadeOut: 2.5},
+			duration:  20.5,
+			wantInput: []string{"-ss", "1.000", "-i", "/music/a.mp3"},
+			wantOutput: append(append([]string{"-map", "0:v", "-map", "1:a:0",
+				"-af", "afade=t=in:st=0:d=1.500,afade=t=out:st=18.000:d=2.500"}, encode...), "-t", "20.500"),
+		},
+	}
+	for _, tc := range tests {
+		input, output := audioArgs(&tc.audio, tc.duration)
+		if diff := cmp.Diff(tc.wantInput, input); diff != "" {
+			t.Errorf("%s: audioArgs() input (-want +got):\n%s", tc.name, diff)
+		}
+		if diff := cmp.Diff(tc.wantOutput, output); diff != "" {
+			t.Errorf("%s: audioArgs() output (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestConvertUnresolvedAudio(t *testing.T) {
+	config := &baseConfig{
+		OutputName:        "out",
+		OutputProfileName: "480p (854x480)",
+		EndFrame:          10,
+		AudioPath:         "music/a.mp3",
+	}
+	if a := config.GetConvertOptions().Audio; a == nil || a.Path != "" {
+		t.Fatalf("unvalidated audio options = %+v, want an unresolved track", a)
+	}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	if err := ConvertFFMpeg(context.Background(), logger, config, nil, make(chan int, 100)); err == nil {
+		t.Errorf("ConvertFFMpeg() with unresolved audio succeeded")
+	}
+}
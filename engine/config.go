@@ -4,8 +4,7 @@ import (
 	"context"
 	"fmt"
 	"image"
-	"os"
-	"time"
+	"path/filepath"
 
 	"timelapse-queue/filebrowse"
 	"timelapse-queue/process"
@@ -17,6 +16,13 @@ type Config interface {
 	GetFilename() string
 	// The basename for the output debug file.
 	GetDebugFilename() string
+	// The path for output with the given basename, in the job's output
+	// directory.
+	GetOutputFullPath(base string) string
+	// The output directory, relative to the root of the output location.
+	GetOutputDir() string
+	// Re-applies the overwrite policy, for outputs written since Validate.
+	CheckOverwrite() error
 	// The desired cropping region.
 	GetRegion() image.Rectangle
 	// The rotation to be applied
@@ -25,6 +31,12 @@ type Config interface {
 	GetStartEnd() (int, int)
 	// Gets the skip of the sequence.
 	GetSkip() int
+	// Gets the source frame read for each output frame, if frames are not
+	// read at a constant skip, or nil.
+	GetFrameSchedule() []int
+	// Gets the source frames blended into each output frame, if frames are
+	// blended by capture time, or nil.
+	GetBlendSchedule() []process.BlendFrame
 	// The output video FPS.
 	GetFPS() int
 
@@ -36,6 +48,16 @@ type Config interface {
 
 	// Gets the output profile for the conversion, i.e. the output resolution.
 	GetOutputProfile() (*Profile, error)
+	// Predicts the output of the job, e.g. to check for disk space.
+	EstimateSize() (*SizeEstimate, error)
+
+	// Gets the name of the resampling filter used to resize to the output profile.
+	GetResample() string
+
+	// Gets the ordered filter chain applied to each frame.
+	GetStages() []process.StageSpec
+	// Gets the job parameters the filter chain stages are built with.
+	GetStageEnv() *process.StageEnv
 }
 
 type baseConfig struct {
@@ -53,20 +75,198 @@ type baseConfig struct {
 	StackMode         string
 	FrameRate         int
 	OutputProfileName string
+	Resample          string
+
+	// Stages is the filter chain applied to frames, in order. If empty, the
+	// chain is built from the individual processing options below.
+	Stages []process.StageSpec
+
+	// Lens selects one of the process.LensPresets to correct distortion and
+	// vignetting. If empty, LensK1, LensK2 and Vignette are used directly.
+	Lens     string
+	LensK1   float64
+	LensK2   float64
+	Vignette float64
+
+	// Denoise averages each frame with its neighbours, by one of the
+	// process.DenoiseModes, to reduce noise in night sequences.
+	Denoise          string
+	DenoiseRadius    int
+	DenoiseThreshold int
+
+	// MotionBlur blends the frames between each output frame rather than
+	// discarding them when skipping.
+	MotionBlur          bool
+	MotionBlurWindow    int
+	MotionBlurWeighting string
+
+	// Interpolate synthesizes intermediate frames to lengthen the output, by
+	// one of the InterpolateModes.
+	Interpolate       string
+	InterpolateFactor int
+
+	// Adjust holds tonal adjustments, keyframed by source frame.
+	Adjust []process.AdjustKeyframe
+
+	// Speed ramps playback speed, keyframed by source frame. Frames are read at
+	// Skip times the speed, rather than at a constant skip.
+	Speed []process.SpeedKeyframe
+
+	// Normalize reads frames by EXIF capture time rather than sequence
+	// position, so each output frame covers NormalizeInterval seconds of real
+	// time (times Skip and Speed), smoothing over interval changes during the
+	// shoot. Zero uses the median capture interval. NormalizeBlend blends the
+	// frames captured either side of each output frame's time, rather than
+	// repeating or dropping whole frames.
+	Normalize         bool
+	NormalizeInterval float64
+	NormalizeBlend    bool
+
+	// LUTPath is a .cube 3D LUT, relative to the file browser root, blended
+	// by LUTStrength from 0 to 1; nil applies it fully.
+	LUTPath          string
+	LUTStrength      *float64
+	LUTInterpolation string
+
+	// OverlayText is a text template burned into each frame, with the fields of
+	// process.OverlayInfo, e.g. {{.Time.Format "Jan 2 2006 15:04"}}.
+	OverlayText string
+	// OverlayFont is a built-in font name or a font file relative to the file
+	// browser root. OverlaySize is in output pixels, zero selects a size
+	// relative to the output height.
+	OverlayFont       string
+	OverlaySize       float64
+	OverlayPosition   string
+	OverlayColor      string
+	OverlayBackground string
+
+	// WatermarkPath is a PNG image, relative to the file browser root, drawn
+	// over each frame. WatermarkWidth is in output pixels, zero keeps the
+	// image's own size. WatermarkOpacity is from 0 to 1; nil is opaque.
+	WatermarkPath     string
+	WatermarkOpacity  *float64
+	WatermarkWidth    int
+	WatermarkPosition string
+
+	// Animated encodes a looping animated image, one of the AnimatedFormats,
+	// instead of an MP4. AnimatedFPS is its frame rate, reduced from FrameRate
+	// by dropping frames.
+	Animated    string
+	AnimatedFPS int
+
+	// Still composites the sequence into a single full resolution image by one
+	// of the process.StillModes instead of encoding a video. StillFormat is one
+	// of the ImageFormats, defaulting to jpeg.
+	Still       string
+	StillFormat string
+
+	// Export writes each processed frame as a numbered image in ExportFormat,
+	// one of the ImageFormats, into a folder named by OutputName. ExportExif
+	// copies the EXIF block of each frame's source image, for JPEG output.
+	Export       bool
+	ExportFormat string
+	ExportExif   bool
+
+	// Preview renders the job as a quick low resolution MP4, reading only
+	// every PreviewEvery'th frame if set, to check settings before the full
+	// render. A finished preview can be promoted to the full job.
+	Preview      bool
+	PreviewEvery int
 
 	RenameOnly bool
+
+	// OutputLocation is one of the filebrowse output locations, selecting
+	// where outputs are written, with OutputDir relative to its root. Empty
+	// uses the server default. OutputName and OutputDir may contain {date},
+	// {name}, {profile} and {codec} placeholders, expanded by Validate.
+	OutputLocation string
+	OutputDir      string
+
+	// AudioPath is an audio file relative to the file browser root, muxed into
+	// MP4 output. AudioStart trims seconds from its start, AudioFadeIn and
+	// AudioFadeOut fade it in and out, and AudioLoop repeats it if shorter
+	// than the video.
+	AudioPath    string
+	AudioStart   float64
+	AudioFadeIn  float64
+	AudioFadeOut float64
+	AudioLoop    bool
+
+	// Overwrite is the policy for existing outputs, one of OverwriteFail
+	// (default), OverwriteReplace or OverwriteVersion.
+	Overwrite string
+
+	// Locates input files, set by Resolve.
+	browser *filebrowse.FileBrowser
+	// The output name and directory with templates expanded, and the
+	// absolute output directory, set by Validate. The exported fields keep
+	// the templates, so a copied config expands them afresh.
+	outputName, outputRelDir string
+	outputDir                string
+	// The absolute path of the audio file, set by Validate.
+	audioPath string
+	// Frames read with speed ramps or Normalize, and blended with
+	// NormalizeBlend, set by Validate.
+	schedule      []int
+	blendSchedule []process.BlendFrame
+}
+
+const (
+	// Blend neighbouring frames in the processing pipeline.
+	InterpolateCrossfade = "crossfade"
+	// Motion compensated interpolation using FFmpeg's minterpolate filter.
+	InterpolateMotion = "minterpolate"
+
+	maxInterpolateFactor = 8
+
+	// previewHeight is the maximum output height of preview renders.
+	previewHeight = 480
+)
+
+var previewFFmpegArgs = []string{
+	"-level:v", "3.1",
+	"-profile:v", "high",
+	"-pix_fmt", "yuv420p",
+	// Playback can start before the whole file is loaded.
+	"-movflags", "+faststart",
 }
 
 func (f *baseConfig) GetConvertOptions() *ConvertOptions {
-	return &ConvertOptions{
-		Stack:          f.Stack,
-		StackWindow:    f.StackWindow,
-		StackSkipCount: f.StackSkipCount,
-		StackMode:      f.StackMode,
-		ProfileCPU:     f.ProfileCPU,
-		ProfileMem:     f.ProfileMem,
-		RenameOnly:     f.RenameOnly,
+	opts := &ConvertOptions{
+		ProfileCPU: f.ProfileCPU,
+		ProfileMem: f.ProfileMem,
+		RenameOnly: f.RenameOnly,
+		Overwrite:  f.GetOverwrite() == OverwriteReplace,
+
+		Still:       f.Still,
+		StillFormat: f.GetStillFormat(),
+
+		Preview: f.Preview,
+
+		Animated:    f.Animated,
+		AnimatedFPS: f.GetAnimatedFPS(),
+
+		Export:       f.Export,
+		ExportFormat: f.GetExportFormat(),
+		ExportExif:   f.ExportExif,
+
+		Interpolate:       f.Interpolate,
+		InterpolateFactor: f.InterpolateFactor,
 	}
+	if f.Preview {
+		// Previews are always MP4, for playback in the browser.
+		opts.Animated = ""
+	}
+	if f.AudioPath != "" {
+		opts.Audio = &AudioOptions{
+			Path:    f.audioPath,
+			Start:   f.AudioStart,
+			FadeIn:  f.AudioFadeIn,
+			FadeOut: f.AudioFadeOut,
+			Loop:    f.AudioLoop,
+		}
+	}
+	return opts
 }
 
 func (f *baseConfig) GetRegion() image.Rectangle {
@@ -86,22 +286,138 @@ func (f *baseConfig) GetRotate() int {
 	return f.Rotate
 }
 
-func getSampleImageBounds(pctx context.Context, t filebrowse.ITimelapse, start int) (image.Rectangle, error) {
-	ctx, cancelf := context.WithTimeout(pctx, 10*time.Second)
-	defer cancelf()
-	imagec, errc := filebrowse.Images(ctx, t, start, 0, 1)
-	select {
-	case img := <-imagec:
-		return img.Rect, nil
-	case err := <-errc:
-		return image.Rectangle{}, err
+// Resolve sets the file browser used to locate input files referenced by the
+// config, such as LUTs. Must be called before Validate.
+func (f *baseConfig) Resolve(fb *filebrowse.FileBrowser) error {
+	f.browser = fb
+	if f.OutputLocation == "" {
+		f.OutputLocation = DefaultOutputLocation
+		if f.OutputDir == "" {
+			f.OutputDir = DefaultOutputDir
+		}
+	}
+	return nil
+}
+
+func (f *baseConfig) GetStages() []process.StageSpec {
+	if len(f.Stages) > 0 {
+		return f.Stages
+	}
+
+	// Build the chain from the individual options.
+	var specs []process.StageSpec
+	add := func(typ string, params interface{}) {
+		specs = append(specs, process.NewStageSpec(typ, params))
+	}
+	if f.Lens != "" {
+		add("lens", map[string]interface{}{"Preset": f.Lens})
+	} else if f.LensK1 != 0 || f.LensK2 != 0 || f.Vignette != 0 {
+		add("lens", map[string]interface{}{"K1": f.LensK1, "K2": f.LensK2, "Vignette": f.Vignette})
+	}
+	if f.Rotate != 0 {
+		add("rotate", nil)
+	}
+	add("crop", nil)
+	add("resize", nil)
+	if f.Denoise != "" {
+		add("denoise", map[string]interface{}{
+			"Mode":      f.Denoise,
+			"Radius":    f.DenoiseRadius,
+			"Threshold": f.DenoiseThreshold,
+		})
+	}
+	if f.MotionBlur {
+		add("motionblur", map[string]interface{}{
+			"Window":    f.MotionBlurWindow,
+			"Weighting": f.MotionBlurWeighting,
+		})
+	}
+	if len(f.Adjust) > 0 {
+		add("adjust", map[string]interface{}{"Keyframes": f.Adjust})
+	}
+	if f.LUTPath != "" {
+		p := map[string]interface{}{
+			"Path":          f.LUTPath,
+			"Interpolation": f.LUTInterpolation,
+		}
+		if f.LUTStrength != nil {
+			p["Strength"] = *f.LUTStrength
+		}
+		add("lut", p)
+	}
+	if f.Stack {
+		add("stack", map[string]interface{}{
+			"Window": f.StackWindow,
+			"Skip":   f.StackSkipCount,
+			"Mode":   f.StackMode,
+		})
+	}
+	if f.Interpolate == InterpolateCrossfade {
+		add("crossfade", map[string]interface{}{"Factor": f.InterpolateFactor})
+	}
+	// Overlays are drawn last, at output resolution and unaffected by grading.
+	if f.OverlayText != "" || f.WatermarkPath != "" {
+		p := map[string]interface{}{
+			"Text":              f.OverlayText,
+			"Font":              f.OverlayFont,
+			"Size":              f.OverlaySize,
+			"Position":          f.OverlayPosition,
+			"Color":             f.OverlayColor,
+			"Background":        f.OverlayBackground,
+			"Watermark":         f.WatermarkPath,
+			"WatermarkWidth":    f.WatermarkWidth,
+			"WatermarkPosition": f.WatermarkPosition,
+		}
+		if f.WatermarkOpacity != nil {
+			p["WatermarkOpacity"] = *f.WatermarkOpacity
+		}
+		add("overlay", p)
+	}
+	return specs
+}
+
+func (f *baseConfig) GetStageEnv() *process.StageEnv {
+	env := &process.StageEnv{
+		Region: f.GetRegion(),
+		Rotate: f.GetRotate(),
+		Filter: f.GetResample(),
+		Skip:   f.GetSkip(),
+	}
+	if outp, err := f.GetOutputProfile(); err == nil {
+		env.Output = image.Point{X: outp.Width, Y: outp.Height}
+	}
+	if f.EndFrame > f.StartFrame {
+		env.Frames = f.EndFrame - f.StartFrame + 1
+	}
+	if f.browser != nil {
+		env.Resolve = f.browser.GetFullPath
+	}
+	return env
+}
+
+// validateProcessing checks the filter chain, independent of the sequence
+// being processed, returning the stages it would run.
+func (f *baseConfig) validateProcessing() ([]process.Process, error) {
+	if _, err := f.GetOutputProfile(); err != nil {
+		return nil, err
+	}
+	if len(f.Stages) > 0 && f.Interpolate == InterpolateCrossfade {
+		return nil, fmt.Errorf("crossfade interpolation must be given as a stage when stages are set")
+	}
+	specs := f.GetStages()
+	if err := process.CheckChainGeometry(specs); err != nil {
+		return nil, err
 	}
+	return process.NewStages(specs, *f.GetStageEnv())
 }
 
 func (f *baseConfig) Validate(ctx context.Context, t filebrowse.ITimelapse) error {
 	if f.OutputName == "" {
 		return fmt.Errorf("missing output filename")
 	}
+	if err := f.resolveOutput(t); err != nil {
+		return err
+	}
 
 	if f.StartFrame < 0 || f.StartFrame >= t.ImageCount() {
 		return fmt.Errorf("start frame out of bounds")
@@ -116,6 +432,10 @@ func (f *baseConfig) Validate(ctx context.Context, t filebrowse.ITimelapse) erro
 		return fmt.Errorf("invalid skip value %d", f.Skip)
 	}
 
+	stages, err := f.validateProcessing()
+	if err != nil {
+		return err
+	}
 	outp, err := f.GetOutputProfile()
 	if err != nil {
 		return err
@@ -130,16 +450,46 @@ func (f *baseConfig) Validate(ctx context.Context, t filebrowse.ITimelapse) erro
 	if r.Dx() < outp.Width || r.Dy() < outp.Height {
 		return fmt.Errorf("selected region must be at least %d x %d", outp.Width, outp.Height)
 	}
-	ir, err := getSampleImageBounds(ctx, t, f.StartFrame)
+	ir, err := filebrowse.ImageBounds(t, f.StartFrame)
 	if err != nil {
 		return fmt.Errorf("failed to load sample frame: %v", err)
 	}
 
+	// Stages such as lens correction keep the frame size, but may leave its
+	// edges without any source pixels.
+	var margin image.Point
+	for i, spec := range f.GetStages() {
+		if process.GetStageByName(spec.Type).Crop {
+			break
+		}
+		lim, ok := stages[i].(process.RegionLimiter)
+		if !ok {
+			continue
+		}
+		valid := lim.ValidRegion(ir.Size())
+		if valid.Dx() < outp.Width || valid.Dy() < outp.Height {
+			return fmt.Errorf("%v stage leaves less than %d x %d of the frame", spec.Type, outp.Width, outp.Height)
+		}
+		if valid.Min.X > margin.X {
+			margin.X = valid.Min.X
+		}
+		if valid.Min.Y > margin.Y {
+			margin.Y = valid.Min.Y
+		}
+	}
+	if a := rot % 180; a > 45 && a < 135 || a < -45 && a > -135 {
+		margin.X, margin.Y = margin.Y, margin.X
+	}
 	ir = process.SizeAfterRotate(ir, rot)
+	ir.Min = ir.Min.Add(margin)
+	ir.Max = ir.Max.Sub(margin)
 	if !(r.Min.X >= ir.Min.X && r.Min.Y >= ir.Min.Y &&
 		r.Min.X <= ir.Max.X && r.Min.Y <= ir.Max.Y &&
 		r.Max.X >= ir.Min.X && r.Max.Y >= ir.Min.Y &&
 		r.Max.X <= ir.Max.X && r.Max.Y <= ir.Max.Y) {
+		if margin != (image.Point{}) {
+			return fmt.Errorf("crop rectangle out of bounds of lens corrected image %v", ir)
+		}
 		return fmt.Errorf("crop rectangle out of bounds of source image")
 	}
 
@@ -147,53 +497,196 @@ func (f *baseConfig) Validate(ctx context.Context, t filebrowse.ITimelapse) erro
 		return fmt.Errorf("only one profile mode at a time is supported")
 	}
 
-	if f.Stack {
-		smax := (f.EndFrame - f.StartFrame + 1) / f.GetSkip()
-		if f.StackWindow < 0 || f.StackWindow > smax {
-			return fmt.Errorf("stacking window out of range 0..%d", smax)
+	switch f.Interpolate {
+	case "":
+	case InterpolateCrossfade, InterpolateMotion:
+		if f.InterpolateFactor < 2 || f.InterpolateFactor > maxInterpolateFactor {
+			return fmt.Errorf("interpolation factor must be between 2 and %d", maxInterpolateFactor)
+		}
+	default:
+		return fmt.Errorf("invalid interpolation mode %v", f.Interpolate)
+	}
+
+	if !validOverwrite(f.GetOverwrite()) {
+		return fmt.Errorf("invalid overwrite policy %v", f.Overwrite)
+	}
+	if err := f.applyOverwrite(); err != nil {
+		return err
+	}
+	if err := checkWritable(f.outputDir); err != nil {
+		return err
+	}
+	if e, err := f.EstimateSize(); err == nil {
+		if err := checkFreeSpace(f.outputDir, e.Bytes); err != nil {
+			return err
+		}
+	}
+
+	if f.Still != "" {
+		if !process.ValidStillMode(f.Still) {
+			return fmt.Errorf("invalid still image mode %v", f.Still)
+		}
+		if getImageFormat(f.GetStillFormat()) == nil {
+			return fmt.Errorf("invalid still image format %v", f.StillFormat)
+		}
+		if f.Interpolate != "" {
+			return fmt.Errorf("Interpolation unsupported for still images")
+		}
+		if f.RenameOnly {
+			return fmt.Errorf("Still images unsupported with rename")
 		}
-		if f.StackSkipCount < 0 || f.StackSkipCount > smax || f.StackSkipCount > f.StackWindow {
-			return fmt.Errorf("stacking skip count out of range")
+	}
+
+	if f.Animated != "" {
+		if getAnimatedFormat(f.Animated) == nil {
+			return fmt.Errorf("invalid animated format %v", f.Animated)
+		}
+		if f.Still != "" || f.RenameOnly {
+			return fmt.Errorf("Animated output unsupported with still images or rename")
 		}
-		if process.GetMergerByName(f.StackMode) == nil {
-			return fmt.Errorf("invalid stack mode %v", f.StackMode)
+		if outp.Height > maxAnimatedHeight {
+			return fmt.Errorf("animated output limited to profiles up to %d lines", maxAnimatedHeight)
+		}
+		if fps := f.GetAnimatedFPS(); fps < 1 || fps > maxAnimatedFPS || fps > f.GetFPS() {
+			return fmt.Errorf("animated frame rate must be between 1 and %d fps", f.GetFPS())
 		}
 	}
 
-	if _, err := os.Stat(t.GetOutputFullPath(f.GetFilename())); err == nil {
-		return fmt.Errorf("the output file %v already exists", f.GetFilename())
+	if f.Preview {
+		if f.Still != "" || f.Export || f.RenameOnly {
+			return fmt.Errorf("Preview renders are only supported for video output")
+		}
+		if f.PreviewEvery < 0 || f.PreviewEvery > (f.EndFrame-f.StartFrame)/2 {
+			return fmt.Errorf("invalid preview frame interval %d", f.PreviewEvery)
+		}
+	}
+
+	if f.Export {
+		if f.Still != "" || f.Animated != "" || f.RenameOnly {
+			return fmt.Errorf("Image sequence export unsupported with still images, animations or rename")
+		}
+		if getImageFormat(f.GetExportFormat()) == nil {
+			return fmt.Errorf("invalid export format %v", f.ExportFormat)
+		}
+		if f.ExportExif && f.GetExportFormat() != "jpeg" {
+			return fmt.Errorf("EXIF can only be copied to JPEG exports")
+		}
+		if f.Interpolate == InterpolateMotion {
+			return fmt.Errorf("Motion interpolation unsupported with image sequence export")
+		}
 	}
 
 	if f.RenameOnly {
-		if f.Stack {
-			return fmt.Errorf("Stacking unsupported with rename")
+		for _, spec := range f.GetStages() {
+			if !process.GetStageByName(spec.Type).JobGeometry {
+				return fmt.Errorf("%v stage unsupported with rename", spec.Type)
+			}
+		}
+		if f.Interpolate != "" {
+			return fmt.Errorf("Interpolation unsupported with rename")
 		}
 	}
 
-	return nil
+	if len(f.Speed) > 0 || f.Normalize {
+		if err := process.ValidateSpeed(f.Speed); err != nil {
+			return err
+		}
+		if f.Still != "" || f.RenameOnly {
+			return fmt.Errorf("Speed ramps and interval normalization unsupported with still images or rename")
+		}
+		if process.ChainReadsSkipped(f.GetStages()) {
+			return fmt.Errorf("Speed ramps and interval normalization unsupported with motion blur")
+		}
+	}
+	if f.Normalize {
+		if err := f.normalizeSchedule(ctx, t); err != nil {
+			return err
+		}
+	} else if f.NormalizeBlend {
+		return fmt.Errorf("Frame blending requires interval normalization")
+	} else {
+		f.schedule = nil
+		if len(f.Speed) > 0 {
+			f.schedule = process.SpeedSchedule(f.Speed, f.StartFrame, f.EndFrame, f.GetSkip())
+		}
+	}
+
+	return f.validateAudio(ctx)
 }
 
 func (f *baseConfig) GetFilename() string {
 	if f.RenameOnly {
 		// File extension will be added by rename converter.
-		return f.OutputName
+		return f.outputName
+	}
+	if f.Export {
+		// A folder, with frames named by the exporter.
+		return f.outputName
+	}
+	if f.Preview {
+		// Previews are always MP4, for playback in the browser.
+		return f.outputName + ".preview.mp4"
 	}
-	return f.OutputName + ".mp4"
+	if f.Still != "" {
+		if sf := getImageFormat(f.GetStillFormat()); sf != nil {
+			return f.outputName + sf.Ext
+		}
+	}
+	if af := getAnimatedFormat(f.Animated); af != nil {
+		return f.outputName + af.Ext
+	}
+	return f.outputName + ".mp4"
+}
+
+func (f *baseConfig) GetExportFormat() string {
+	if f.ExportFormat == "" {
+		return "jpeg"
+	}
+	return f.ExportFormat
+}
+
+func (f *baseConfig) GetAnimatedFPS() int {
+	if f.AnimatedFPS > 0 {
+		return f.AnimatedFPS
+	}
+	if fps := f.GetFPS(); fps < defaultAnimatedFPS {
+		return fps
+	}
+	return defaultAnimatedFPS
+}
+
+func (f *baseConfig) GetStillFormat() string {
+	if f.StillFormat == "" {
+		return "jpeg"
+	}
+	return f.StillFormat
 }
 
 func (f *baseConfig) GetDebugFilename() string {
 	return f.GetFilename() + ".log"
 }
 
+func (f *baseConfig) GetOutputFullPath(base string) string {
+	return filepath.Join(f.outputDir, base)
+}
+
+func (f *baseConfig) GetOutputDir() string {
+	return f.outputRelDir
+}
+
 func (f *baseConfig) GetStartEnd() (int, int) {
 	return f.StartFrame, f.EndFrame
 }
 
 func (f *baseConfig) GetSkip() int {
-	if f.Skip == 0 {
-		return 1
+	skip := f.Skip
+	if skip == 0 {
+		skip = 1
 	}
-	return f.Skip
+	if f.Preview && f.PreviewEvery > 1 {
+		skip *= f.PreviewEvery
+	}
+	return skip
 }
 
 func (f *baseConfig) GetFPS() int {
@@ -203,18 +696,64 @@ func (f *baseConfig) GetFPS() int {
 	return 60
 }
 
+func (f *baseConfig) GetFrameSchedule() []int {
+	return f.schedule
+}
+
+func (f *baseConfig) GetBlendSchedule() []process.BlendFrame {
+	if f.Normalize && f.NormalizeBlend {
+		return f.blendSchedule
+	}
+	return nil
+}
+
+// readFrames returns the number of frames read from the sequence, including a
+// partial skip step at the end as filebrowse.Images does.
+func (f *baseConfig) readFrames() int {
+	skip := f.GetSkip()
+	return (f.EndFrame - f.StartFrame + skip) / skip
+}
+
 func (f *baseConfig) GetExpectedFrames() int {
-	frames := f.EndFrame + 1 - f.StartFrame
-	if f.Skip > 1 {
-		frames = frames / f.Skip
+	frames := f.readFrames()
+	if sched := f.GetFrameSchedule(); sched != nil {
+		frames = len(sched)
 	}
-	if f.Stack {
-		// Stacking will add additonal frames to the output.
-		frames += f.StackWindow
+	// Stages such as stacking and crossfade change the frame count.
+	frames = process.ChainFrames(f.GetStages(), frames)
+	if f.Interpolate == InterpolateMotion {
+		frames *= f.InterpolateFactor
 	}
 	return frames
 }
 
 func (f *baseConfig) GetOutputProfile() (*Profile, error) {
-	return GetProfileByName(f.OutputProfileName)
+	if f.Still != "" {
+		// Still images keep the full resolution of the selected region.
+		r := f.GetRegion()
+		return &Profile{Name: "full resolution", Width: r.Dx(), Height: r.Dy()}, nil
+	}
+	outp, err := GetProfileByName(f.OutputProfileName)
+	if err != nil || !f.Preview || outp.Height <= previewHeight {
+		return outp, err
+	}
+	// Previews scale the profile down, keeping its aspect ratio.
+	w := (outp.Width*previewHeight/outp.Height + 1) &^ 1
+	return &Profile{
+		Name:       fmt.Sprintf("preview (%dx%d)", w, previewHeight),
+		Width:      w,
+		Height:     previewHeight,
+		FFmpegArgs: previewFFmpegArgs,
+		Resample:   outp.Resample,
+	}, nil
+}
+
+func (f *baseConfig) GetResample() string {
+	if f.Resample != "" {
+		return f.Resample
+	}
+	if outp, err := f.GetOutputProfile(); err == nil && outp.Resample != "" {
+		return outp.Resample
+	}
+	return process.DefaultFilter
 }
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetSkipPreview(t *testing.T) {
+	tests := []struct {
+		skip, every int
+		preview     bool
+		want        int
+	}{
+		{want: 1},
+		{skip: 3, want: 3},
+		{every: 4, want: 1},
+		{every: 4, preview: true, want: 4},
+		{skip: 3, every: 4, preview: true, want: 12},
+		{skip: 3, every: 1, preview: true, want: 3},
+	}
+	for _, tc := range tests {
+		f := &baseConfig{Skip: tc.skip, PreviewEvery: tc.every, Preview: tc.preview}
+		if got := f.GetSkip(); got != tc.want {
+			t.Errorf("GetSkip() with skip %d, every %d, preview %v = %d, want %d",
+				tc.skip, tc.every, tc.preview, got, tc.want)
+		}
+	}
+}
+
+func TestGetOutputProfilePreview(t *testing.T) {
+	tests := []struct {
+		profile       string
+		width, height int
+	}{
+		{profile: "1080p (1920x1080)", width: 854, height: 480},
+		{profile: "4k (3840x2160)", width: 854, height: 480},
+		{profile: "12MP (4000x3000)", width: 640, height: 480},
+		{profile: "360p (640x360)", width: 640, height: 360},
+	}
+	for _, tc := range tests {
+		f := &baseConfig{OutputProfileName: tc.profile, Preview: true}
+		p, err := f.GetOutputProfile()
+		if err != nil {
+			t.Errorf("%s: GetOutputProfile() error = %v", tc.profile, err)
+			continue
+		}
+		if p.Width != tc.width || p.Height != tc.height {
+			t.Errorf("%s: preview profile %d x %d, want %d x %d", tc.profile, p.Width, p.Height, tc.width, tc.height)
+		}
+		// Profiles small enough already are used as is.
+		want, _ := GetProfileByName(tc.profile)
+		wantArgs := want.FFmpegArgs
+		if want.Height > previewHeight {
+			wantArgs = previewFFmpegArgs
+		}
+		if diff := cmp.Diff(wantArgs, p.FFmpegArgs); diff != "" {
+			t.Errorf("%s: preview FFmpeg args diff: %v", tc.profile, diff)
+		}
+	}
+}
+
+func TestValidateKeepsTemplates(t *testing.T) {
+	setMinFreeSpace(t, 0)
+
+	fb, tl := writeTestSequence(t, 2, image.Pt(1920, 1080))
+	config := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "out_{profile}",
+		OutputLocation:    filebrowse.OutputBrowse,
+		OutputDir:         "renders/{codec}",
+		OutputProfileName: "1080p (1920x1080)",
+		Width:             1920,
+		Height:            1080,
+		EndFrame:          1,
+		Overwrite:         OverwriteVersion,
+	}
+	if err := config.Resolve(fb); err != nil {
+		t.Fatal(err)
+	}
+	validate := func(wantName string) {
+		t.Helper()
+		if err := config.Validate(context.Background(), tl); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		if got := config.GetFilename(); got != wantName {
+			t.Errorf("GetFilename() = %v, want %v", got, wantName)
+		}
+		if got := config.GetOutputDir(); got != "renders/h264" {
+			t.Errorf("GetOutputDir() = %v, want renders/h264", got)
+		}
+		if config.OutputName != "out_{profile}" || config.OutputDir != "renders/{codec}" {
+			t.Errorf("Validate() changed the templates to %q, %q", config.OutputName, config.OutputDir)
+		}
+	}
+
+	validate("out_1080p.mp4")
+	existing := config.GetOutputFullPath("out_1080p.mp4")
+	if err := ioutil.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	validate("out_1080p_v2.mp4")
+	// Versioning is recomputed, not compounded, on each Validate.
+	validate("out_1080p_v2.mp4")
+	if err := os.Remove(existing); err != nil {
+		t.Fatal(err)
+	}
+	validate("out_1080p.mp4")
+}
+
+func TestValidateSpeedSchedule(t *testing.T) {
+	setMinFreeSpace(t, 0)
+	fb, tl := writeTestSequence(t, 5, image.Pt(640, 360))
+	config := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "out",
+		OutputProfileName: "360p (640x360)",
+		Width:             640,
+		Height:            360,
+		EndFrame:          4,
+		Speed:             []process.SpeedKeyframe{{Frame: 0, Speed: 1}, {Frame: 4, Speed: 0.5}},
+	}
+	if err := config.Resolve(fb); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(context.Background(), tl); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	want := process.SpeedSchedule(config.Speed, 0, 4, 1)
+	got := config.GetFrameSchedule()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetFrameSchedule() (-want +got):\n%s", diff)
+	}
+	// The schedule is computed once by Validate, not on each call.
+	if again := config.GetFrameSchedule(); len(got) == 0 || &again[0] != &got[0] {
+		t.Errorf("GetFrameSchedule() recomputed the schedule")
+	}
+
+	config.Speed = nil
+	if err := config.Validate(context.Background(), tl); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if got := config.GetFrameSchedule(); got != nil {
+		t.Errorf("GetFrameSchedule() without speed ramps = %v, want nil", got)
+	}
+}
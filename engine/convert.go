@@ -12,18 +12,43 @@ import (
 
 type ConvertOptions struct {
 	ProfileCPU, ProfileMem bool
-	Stack                  bool
-	StackWindow            int
-	StackSkipCount         int
-	StackMode              string
 	RenameOnly             bool
+	// Overwrite replaces existing outputs.
+	Overwrite bool
+
+	// Preview renders a quick low quality MP4.
+	Preview bool
+
+	// Still selects a still image composite rather than a video, written in
+	// StillFormat.
+	Still       string
+	StillFormat string
+
+	// Animated selects an animated image format rather than MP4, at
+	// AnimatedFPS.
+	Animated    string
+	AnimatedFPS int
+
+	// Audio is muxed into MP4 output if set.
+	Audio *AudioOptions
+
+	// Export writes processed frames as images in ExportFormat, optionally
+	// copying EXIF from the source images.
+	Export       bool
+	ExportFormat string
+	ExportExif   bool
+
+	// Interpolate and InterpolateFactor select frame interpolation. Crossfade
+	// runs as a stage of the filter chain, motion interpolation in ffmpeg.
+	Interpolate       string
+	InterpolateFactor int
 }
 
 func Convert(ctx context.Context, config Config, timelapse filebrowse.ITimelapse, progress chan<- int) error {
 	defer close(progress)
 	opts := config.GetConvertOptions()
 
-	profilepath := profile.ProfilePath(timelapse.GetOutputFullPath("profiles"))
+	profilepath := profile.ProfilePath(config.GetOutputFullPath("profiles"))
 	if opts.ProfileCPU {
 		defer profile.Start(profilepath).Stop()
 	}
@@ -31,7 +56,7 @@ func Convert(ctx context.Context, config Config, timelapse filebrowse.ITimelapse
 		defer profile.Start(profile.MemProfile, profilepath).Stop()
 	}
 
-	logf, err := os.Create(timelapse.GetOutputFullPath(config.GetDebugFilename()))
+	logf, err := os.Create(config.GetOutputFullPath(config.GetDebugFilename()))
 	if err != nil {
 		return err
 	}
@@ -50,5 +75,34 @@ func Convert(ctx context.Context, config Config, timelapse filebrowse.ITimelapse
 	if opts.RenameOnly {
 		return ConvertRename(ctx, logger, config, timelapse, progress)
 	}
-	return ConvertFFMpeg(ctx, logger, config, timelapse, progress)
+
+	// Recheck free space, which jobs queued ahead may have used up, and watch
+	// it while the job runs.
+	e, err := config.EstimateSize()
+	if err != nil {
+		return err
+	}
+	dir := config.GetOutputFullPath("")
+	if err := checkFreeSpace(dir, e.Bytes); err != nil {
+		logger.Error(err)
+		return err
+	}
+	wctx, stopWatch := watchFreeSpace(ctx, dir)
+
+	convert := ConvertFFMpeg
+	if opts.Still != "" {
+		convert = ConvertStill
+	} else if opts.Export {
+		convert = ConvertExport
+	}
+	err = convert(wctx, logger, config, timelapse, progress)
+	if reason := stopWatch(); reason != nil {
+		logger.Error(reason)
+		return reason
+	}
+	if err != nil {
+		return err
+	}
+	recordOutputSize(config)
+	return nil
 }
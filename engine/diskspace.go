@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"timelapse-queue/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// MinFreeSpace is the free space in bytes that must remain on the output
+	// filesystem. Jobs that would go below it are rejected, and running jobs
+	// cancelled.
+	MinFreeSpace int64 = 1 << 30
+	// SizeHistory is the file recording the output sizes of finished jobs,
+	// refining size estimates. Empty keeps history in memory only.
+	SizeHistory = ""
+
+	// diskCheckInterval is how often free space is checked while a job runs.
+	diskCheckInterval = 10 * time.Second
+
+	historyMu sync.Mutex
+	history   map[string]float64
+)
+
+// historyWeight is the weight of each new result in the running average.
+const historyWeight = 0.3
+
+// formatBytes formats n in binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// loadHistory reads the size history, if not already loaded. Must hold
+// historyMu.
+func loadHistory() {
+	if history != nil {
+		return
+	}
+	history = make(map[string]float64)
+	if SizeHistory == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(SizeHistory)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to read size history: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(b, &history); err != nil {
+		log.Warnf("Failed to parse size history: %v", err)
+	}
+}
+
+// historyBitsPerPixel returns the average bits per pixel per frame of finished
+// jobs of the same kind, or def if there are none.
+func historyBitsPerPixel(key string, def float64) float64 {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	loadHistory()
+	if bpp, ok := history[key]; ok {
+		return bpp
+	}
+	return def
+}
+
+// recordHistory adds the bits per pixel per frame of a finished job.
+func recordHistory(key string, bpp float64) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	loadHistory()
+	if old, ok := history[key]; ok {
+		bpp = old + historyWeight*(bpp-old)
+	}
+	history[key] = bpp
+	if SizeHistory == "" {
+		return
+	}
+	b, err := json.Marshal(history)
+	if err == nil {
+		err = ioutil.WriteFile(SizeHistory, b, 0644)
+	}
+	if err != nil {
+		log.Warnf("Failed to write size history: %v", err)
+	}
+}
+
+// outputSize returns the size of the output at path, summing files if it's
+// a folder.
+func outputSize(path string) (int64, error) {
+	var n int64
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			n += fi.Size()
+		}
+		return nil
+	})
+	return n, err
+}
+
+// recordOutputSize refines size estimates with the output of a finished job.
+func recordOutputSize(config Config) {
+	e, err := config.EstimateSize()
+	if err != nil || e.Bytes == 0 || e.Frames == 0 {
+		return
+	}
+	n, err := outputSize(config.GetOutputFullPath(config.GetFilename()))
+	if err != nil {
+		log.Warnf("Failed to size output: %v", err)
+		return
+	}
+	if config.GetConvertOptions().Audio != nil {
+		// Only the video track is estimated from history.
+		n -= int64(e.Duration * audioBytesPerSecond)
+	}
+	recordHistory(e.Kind, float64(n)*8/(float64(e.Frames)*float64(e.Width*e.Height)))
+}
+
+// checkFreeSpace fails if writing need bytes to dir would leave less than
+// MinFreeSpace free. Filesystems that can't report free space are not
+// checked.
+func checkFreeSpace(dir string, need int64) error {
+	free, err := util.FreeSpace(dir)
+	if err != nil {
+		log.Warnf("Unable to check free space of %v: %v", dir, err)
+		return nil
+	}
+	if int64(free)-need < MinFreeSpace {
+		return fmt.Errorf("insufficient disk space: output needs about %v, %v free of which %v must remain",
+			formatBytes(need), formatBytes(int64(free)), formatBytes(MinFreeSpace))
+	}
+	return nil
+}
+
+// watchFreeSpace returns a context cancelled if free space in dir drops below
+// MinFreeSpace, and a function stopping the watch, returning the reason if it
+// has.
+func watchFreeSpace(pctx context.Context, dir string) (context.Context, func() error) {
+	ctx, cancelf := context.WithCancel(pctx)
+	var reason error
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		t := time.NewTicker(diskCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			free, err := util.FreeSpace(dir)
+			if err != nil || int64(free) >= MinFreeSpace {
+				continue
+			}
+			reason = fmt.Errorf("cancelled, free disk space dropped to %v, below the %v minimum",
+				formatBytes(int64(free)), formatBytes(MinFreeSpace))
+			log.Error(reason)
+			cancelf()
+			return
+		}
+	}()
+	return ctx, func() error {
+		cancelf()
+		<-donec
+		return reason
+	}
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1<<20 - 1, "1024.0 KiB"},
+		{1 << 30, "1.0 GiB"},
+		{5<<40 + 1<<39, "5.5 TiB"},
+	} {
+		if got := formatBytes(tc.n); got != tc.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	resetHistory(t)
+	SizeHistory = filepath.Join(t.TempDir(), "history.json")
+	defer func() { SizeHistory = "" }()
+
+	if got := historyBitsPerPixel("h264-slow-crf16", 0.5); got != 0.5 {
+		t.Errorf("bits per pixel without history = %v, want the default 0.5", got)
+	}
+	recordHistory("h264-slow-crf16", 1)
+	recordHistory("h264-slow-crf16", 2)
+	recordHistory("gif", 3)
+	want := 1 + historyWeight*(2-1)
+	if got := historyBitsPerPixel("h264-slow-crf16", 0.5); got != want {
+		t.Errorf("bits per pixel = %v, want the running average %v", got, want)
+	}
+
+	// History persists across restarts.
+	historyMu.Lock()
+	history = nil
+	historyMu.Unlock()
+	if got := historyBitsPerPixel("h264-slow-crf16", 0.5); got != want {
+		t.Errorf("reloaded bits per pixel = %v, want %v", got, want)
+	}
+	if got := historyBitsPerPixel("gif", 0.5); got != 3 {
+		t.Errorf("reloaded gif bits per pixel = %v, want 3", got)
+	}
+
+	// A corrupt history falls back to the defaults.
+	if err := ioutil.WriteFile(SizeHistory, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	historyMu.Lock()
+	history = nil
+	historyMu.Unlock()
+	if got := historyBitsPerPixel("gif", 0.5); got != 0.5 {
+		t.Errorf("bits per pixel with corrupt history = %v, want the default 0.5", got)
+	}
+}
+
+// setMinFreeSpace sets MinFreeSpace for the duration of the test.
+func setMinFreeSpace(t *testing.T, n int64) {
+	old := MinFreeSpace
+	MinFreeSpace = n
+	t.Cleanup(func() { MinFreeSpace = old })
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name     string
+		min      int64
+		need     int64
+		wantFail bool
+	}{
+		{name: "nothing needed", min: 0, need: 0},
+		{name: "fits", min: 0, need: 1 << 10},
+		{name: "output too large", min: 0, need: 1 << 62, wantFail: true},
+		{name: "minimum too large", min: 1 << 62, need: 0, wantFail: true},
+	} {
+		setMinFreeSpace(t, tc.min)
+		err := checkFreeSpace(dir, tc.need)
+		if tc.wantFail {
+			if err == nil || !strings.Contains(err.Error(), "insufficient disk space") {
+				t.Errorf("%s: checkFreeSpace() = %v, want insufficient disk space", tc.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: checkFreeSpace() error = %v", tc.name, err)
+		}
+	}
+}
+
+func TestWatchFreeSpace(t *testing.T) {
+	old := diskCheckInterval
+	diskCheckInterval = time.Millisecond
+	defer func() { diskCheckInterval = old }()
+	dir := t.TempDir()
+
+	// Enough space: runs until stopped, with no reason.
+	setMinFreeSpace(t, 0)
+	ctx, stop := watchFreeSpace(context.Background(), dir)
+	time.Sleep(20 * diskCheckInterval)
+	if ctx.Err() != nil {
+		t.Errorf("watchdog cancelled with enough free space")
+	}
+	if err := stop(); err != nil {
+		t.Errorf("stop() = %v, want no reason", err)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("stop() didn't cancel the context")
+	}
+
+	// Parent cancellation is not a disk space failure.
+	pctx, cancelf := context.WithCancel(context.Background())
+	_, stop = watchFreeSpace(pctx, dir)
+	cancelf()
+	if err := stop(); err != nil {
+		t.Errorf("stop() after parent cancel = %v, want no reason", err)
+	}
+
+	// Space drops below the minimum.
+	setMinFreeSpace(t, 1<<62)
+	ctx, stop = watchFreeSpace(context.Background(), dir)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watchdog didn't cancel below the minimum free space")
+	}
+	if err := stop(); err == nil || !strings.Contains(err.Error(), "free disk space dropped") {
+		t.Errorf("stop() = %v, want the free space reason", err)
+	}
+}
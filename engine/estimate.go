@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"timelapse-queue/filebrowse"
+)
+
+// SizeEstimate describes the expected output of a job.
+type SizeEstimate struct {
+	Frames        int
+	Width, Height int
+	// Duration is the playback length in seconds, zero for still images.
+	Duration float64
+	// Bytes is a rough estimate of the output size, zero if unknown.
+	Bytes int64
+	// Kind groups jobs of similar output, whose sizes refine the estimate.
+	Kind string
+}
+
+// x264Settings are the encoder settings of MP4 output, which the output size
+// depends on.
+type x264Settings struct {
+	Preset string
+	CRF    int
+}
+
+var (
+	fullX264    = x264Settings{Preset: "slow", CRF: 16}
+	previewX264 = x264Settings{Preset: "veryfast", CRF: 26}
+)
+
+// getX264Settings returns the encoder settings of full renders or previews.
+func getX264Settings(preview bool) x264Settings {
+	if preview {
+		return previewX264
+	}
+	return fullX264
+}
+
+// kind groups outputs of the same settings in the size history.
+func (x x264Settings) kind() string {
+	return fmt.Sprintf("h264-%s-crf%d", x.Preset, x.CRF)
+}
+
+// bitsPerPixel approximates the output size per pixel per frame. The size
+// roughly halves for every 6 CRF steps.
+func (x x264Settings) bitsPerPixel() float64 {
+	return mp4BitsPerPixel * math.Exp2(float64(fullX264.CRF-x.CRF)/6)
+}
+
+// EstimateSize predicts the output of the job from its settings alone.
+func (f *baseConfig) EstimateSize() (*SizeEstimate, error) {
+	if f.EndFrame <= f.StartFrame {
+		return nil, fmt.Errorf("start frame must come before end frame")
+	}
+	if f.RenameOnly {
+		// Files are moved, not written.
+		return &SizeEstimate{Frames: f.readFrames()}, nil
+	}
+	outp, err := f.GetOutputProfile()
+	if err != nil {
+		return nil, err
+	}
+	e := &SizeEstimate{
+		Frames: f.GetExpectedFrames(),
+		Width:  outp.Width,
+		Height: outp.Height,
+	}
+	pixels := float64(e.Width * e.Height)
+
+	if f.Still != "" {
+		sf := getImageFormat(f.GetStillFormat())
+		if sf == nil {
+			return nil, fmt.Errorf("invalid still image format %v", f.StillFormat)
+		}
+		e.Frames = 1
+		if f.Still == "keogram" {
+			e.Width = f.readFrames()
+			pixels = float64(e.Width * e.Height)
+		}
+		e.Kind = "still-" + sf.Name
+		e.Bytes = int64(pixels * historyBitsPerPixel(e.Kind, sf.BitsPerPixel) / 8)
+		return e, nil
+	}
+
+	if f.Export {
+		ef := getImageFormat(f.GetExportFormat())
+		if ef == nil {
+			return nil, fmt.Errorf("invalid export format %v", f.ExportFormat)
+		}
+		e.Kind = "export-" + ef.Name
+		e.Bytes = int64(float64(e.Frames) * pixels * historyBitsPerPixel(e.Kind, ef.BitsPerPixel) / 8)
+		return e, nil
+	}
+
+	e.Duration = float64(e.Frames) / float64(f.GetFPS())
+	x := getX264Settings(f.Preview)
+	e.Kind = x.kind()
+	bpp := x.bitsPerPixel()
+	if f.Animated != "" && !f.Preview {
+		af := getAnimatedFormat(f.Animated)
+		if af == nil {
+			return nil, fmt.Errorf("invalid animated format %v", f.Animated)
+		}
+		e.Frames = e.Frames * f.GetAnimatedFPS() / f.GetFPS()
+		e.Kind, bpp = af.Name, af.BitsPerPixel
+	}
+	e.Bytes = int64(float64(e.Frames) * pixels * historyBitsPerPixel(e.Kind, bpp) / 8)
+	if f.AudioPath != "" && (f.Animated == "" || f.Preview) {
+		e.Bytes += int64(e.Duration * audioBytesPerSecond)
+	}
+	return e, nil
+}
+
+// EstimateServer reports the expected output size of a job, so it can be
+// checked before the job is queued.
+type EstimateServer struct {
+	Browser *filebrowse.FileBrowser
+}
+
+func (s *EstimateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	config := &baseConfig{}
+	if err := json.Unmarshal([]byte(r.Form.Get("request")), config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := config.Resolve(s.Browser); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := config.validateProcessing(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, err := config.EstimateSize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// resetHistory clears size history for the test.
+func resetHistory(t *testing.T) {
+	historyMu.Lock()
+	history, SizeHistory = nil, ""
+	historyMu.Unlock()
+	t.Cleanup(func() {
+		historyMu.Lock()
+		history = nil
+		historyMu.Unlock()
+	})
+}
+
+func TestEstimateSize(t *testing.T) {
+	resetHistory(t)
+	const profile = "480p (854x480)"
+	pixels := float64(854 * 480)
+	tests := []struct {
+		name   string
+		config baseConfig
+		want   SizeEstimate
+	}{
+		{
+			name:   "mp4",
+			config: baseConfig{OutputProfileName: profile, EndFrame: 599},
+			want: SizeEstimate{
+				Frames: 600, Width: 854, Height: 480, Duration: 10, Kind: "h264-slow-crf16",
+				Bytes: int64(600 * pixels * mp4BitsPerPixel / 8),
+			},
+		},
+		{
+			name:   "skip with partial step",
+			config: baseConfig{OutputProfileName: profile, EndFrame: 600, Skip: 4, FrameRate: 30},
+			want: SizeEstimate{
+				Frames: 151, Width: 854, Height: 480, Duration: 151.0 / 30, Kind: "h264-slow-crf16",
+				Bytes: int64(151 * pixels * mp4BitsPerPixel / 8),
+			},
+		},
+		{
+			name:   "preview",
+			config: baseConfig{OutputProfileName: profile, EndFrame: 599, Preview: true, PreviewEvery: 2},
+			want: SizeEstimate{
+				Frames: 300, Width: 854, Height: 480, Duration: 5, Kind: "h264-veryfast-crf26",
+				Bytes: int64(300 * pixels * mp4BitsPerPixel * math.Exp2(-10.0/6) / 8),
+			},
+		},
+		{
+			name:   "gif",
+			config: baseConfig{OutputProfileName: profile, EndFrame: 599, Animated: AnimatedGIF},
+			want: SizeEstimate{
+				Frames: 150, Width: 854, Height: 480, Duration: 10, Kind: "gif",
+				Bytes: int64(150 * pixels * 3 / 8),
+			},
+		},
+		{
+			name:   "keogram",
+			config: baseConfig{OutputProfileName: profile, X: 0, Y: 0, Width: 1000, Height: 500, EndFrame: 99, Still: "keogram", StillFormat: "png"},
+			want: SizeEstimate{
+				Frames: 1, Width: 100, Height: 500, Kind: "still-png",
+				Bytes: int64(100 * 500 * getImageFormat("png").BitsPerPixel / 8),
+			},
+		},
+		{
+			name:   "rename",
+			config: baseConfig{EndFrame: 10, Skip: 3, RenameOnly: true},
+			want:   SizeEstimate{Frames: 4},
+		},
+	}
+	for _, tc := range tests {
+		got, err := tc.config.EstimateSize()
+		if err != nil {
+			t.Errorf("%s: EstimateSize() error = %v", tc.name, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, *got); diff != "" {
+			t.Errorf("%s: EstimateSize() diff: %v", tc.name, diff)
+		}
+	}
+
+	bad := []baseConfig{
+		{OutputProfileName: profile},
+		{OutputProfileName: "8k", EndFrame: 10},
+		{OutputProfileName: profile, EndFrame: 10, Animated: "apng"},
+	}
+	for _, f := range bad {
+		if _, err := f.EstimateSize(); err == nil {
+			t.Errorf("EstimateSize() of %+v succeeded", f)
+		}
+	}
+}
+
+func TestEstimateSizeHistory(t *testing.T) {
+	resetHistory(t)
+	f := &baseConfig{OutputProfileName: "480p (854x480)", EndFrame: 99}
+	before, err := f.EstimateSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordHistory(before.Kind, 2*mp4BitsPerPixel)
+	after, err := f.EstimateSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Bytes != 2*before.Bytes {
+		t.Errorf("estimate after history = %d, want %d", after.Bytes, 2*before.Bytes)
+	}
+}
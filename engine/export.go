@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/util"
+
+	"github.com/davecgh/go-spew/spew"
+	log "github.com/sirupsen/logrus"
+)
+
+// sourceExif returns the EXIF segment of a timelapse image, rewritten for the
+// processed frame of the given size, or nil if it has none.
+func sourceExif(t filebrowse.ITimelapse, idx int, size image.Point) ([]byte, error) {
+	f, err := os.Open(t.GetPathForIndex(idx))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	seg, err := filebrowse.ReadExifSegment(f)
+	if err == filebrowse.ErrNoExif {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filebrowse.RewriteExifSegment(seg, size)
+}
+
+// exportFrame encodes img in format to path, with the EXIF segment seg if set.
+func exportFrame(path string, img *image.RGBA, format *ImageFormat, seg []byte) error {
+	buf := new(bytes.Buffer)
+	if err := format.Encode(buf, img); err != nil {
+		return fmt.Errorf("failed to encode %v: %v", format.Name, err)
+	}
+	if seg == nil {
+		return ioutil.WriteFile(path, buf.Bytes(), 0644)
+	}
+	out := new(bytes.Buffer)
+	out.Grow(buf.Len() + len(seg) + 4)
+	if err := filebrowse.WriteExifSegment(out, buf.Bytes(), seg); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out.Bytes(), 0644)
+}
+
+// clearExport prepares dir to be overwritten by an export, removing frames of a
+// previous export so none are left over from a longer sequence.
+func clearExport(dir, name string, format *ImageFormat) error {
+	old, err := filepath.Glob(filepath.Join(dir, name+"[0-9][0-9][0-9][0-9][0-9][0-9]"+format.Ext))
+	if err != nil {
+		return err
+	}
+	for _, p := range old {
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitExport moves the exported frames into place. Replacing an existing
+// folder only swaps the frames of the previous export, leaving any other files
+// in it alone. Otherwise it fails if the folder was created while the job ran.
+func commitExport(part *partialOutput, name string, format *ImageFormat) error {
+	if part.replace {
+		if err := clearExport(part.Final, name, format); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(part.Final, 0755); err != nil {
+			return err
+		}
+	} else if err := os.Mkdir(part.Final, 0755); os.IsExist(err) {
+		return fmt.Errorf("the output folder %v already exists", part.Final)
+	} else if err != nil {
+		return err
+	}
+	frames, err := filepath.Glob(filepath.Join(part.Path, "*"))
+	if err != nil {
+		return err
+	}
+	for _, f := range frames {
+		if err := os.Rename(f, filepath.Join(part.Final, filepath.Base(f))); err != nil {
+			return err
+		}
+	}
+	part.Cleanup()
+	return nil
+}
+
+// ConvertExport runs the job's processing pipeline and writes each frame as a
+// numbered image into a folder next to the sequence.
+func ConvertExport(pctx context.Context, logger *log.Logger, config Config, timelapse filebrowse.ITimelapse, progress chan<- int) error {
+	opts := config.GetConvertOptions()
+	format := getImageFormat(opts.ExportFormat)
+	if format == nil {
+		return fmt.Errorf("invalid export format %v", opts.ExportFormat)
+	}
+
+	ctx, cancelf := context.WithCancel(pctx)
+	defer cancelf()
+
+	pool := util.NewFramePool()
+	defer pool.Close()
+
+	name := filepath.Base(config.GetFilename())
+	part := newPartialOutput(config.GetOutputFullPath(config.GetFilename()), opts.Overwrite)
+	defer part.Cleanup()
+	// Frames are written to a hidden folder, moved into place on success.
+	dir := part.Path
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	logger.Infof("Starting job: %+v", spew.Sdump(config))
+
+	imagec, imerrc, err := buildPipeline(ctx, logger, config, timelapse, pool, -1)
+	if err != nil {
+		return err
+	}
+
+	// Writes errors both to the system logger and the file logger.
+	dualErrorf := func(format string, v ...interface{}) {
+		log.Errorf(format, v...)
+		logger.Errorf(format, v...)
+	}
+
+	expected := config.GetExpectedFrames()
+	source := outputSourceFrame(config)
+	var werr error
+	i := 0
+	for img := range imagec {
+		if ctx.Err() != nil {
+			// Drain the stream so upstream stages can exit.
+			pool.Put(img)
+			continue
+		}
+		var seg []byte
+		if opts.ExportExif {
+			idx := source(i)
+			if seg, err = sourceExif(timelapse, idx, img.Rect.Size()); err != nil {
+				logger.Warnf("Failed to read EXIF of frame %d: %v", idx, err)
+			}
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%s%06d%s", name, i, format.Ext))
+		werr = exportFrame(path, img, format, seg)
+		pool.Put(img)
+		if werr != nil {
+			dualErrorf("Failed to write %v: %v", path, werr)
+			cancelf()
+			continue
+		}
+		i++
+		progress <- 100 * i / expected
+	}
+	if werr != nil {
+		return werr
+	}
+	if err := <-imerrc; err != nil {
+		dualErrorf("Error reading image: %v", err)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	commit := func() error { return commitExport(part, name, format) }
+	if err := commitWithSidecar(part, config, timelapse, commit); err != nil {
+		dualErrorf("Failed to move output into place: %v", err)
+		return err
+	}
+	logger.Infof("Exported %d frames to %v", i, part.Final)
+	log.Info("Conversion succeeded.")
+	return nil
+}
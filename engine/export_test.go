@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"timelapse-queue/filebrowse"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pixiv/go-libjpeg/jpeg"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/tiff"
+)
+
+// testExif is an EXIF segment with IFD0 holding just an orientation of 6
+// (rotated 90 degrees clockwise).
+var testExif = []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08" +
+	"\x00\x01" + "\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" + "\x00\x00\x00\x00")
+
+// writeTestSequence writes count JPEGs of the given size into a new browse
+// root, each with testExif, returning the browser and timelapse.
+func writeTestSequence(t *testing.T, count int, size image.Point) (*filebrowse.FileBrowser, filebrowse.ITimelapse) {
+	return writeTestSequenceExif(t, count, size, func(int) []byte { return testExif })
+}
+
+// writeTestSequenceExif is writeTestSequence, with the EXIF segment of each
+// frame given by exif.
+func writeTestSequenceExif(t *testing.T, count int, size image.Point, exif func(i int) []byte) (*filebrowse.FileBrowser, filebrowse.ITimelapse) {
+	dir := t.TempDir()
+	for i := 0; i < count; i++ {
+		img := image.NewRGBA(image.Rectangle{Max: size})
+		for j := range img.Pix {
+			img.Pix[j] = uint8(40 * i)
+		}
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, img, &jpeg.EncoderOptions{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		if err := filebrowse.WriteExifSegment(out, buf.Bytes(), exif(i)); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, fmt.Sprintf("G%07d.JPG", i+1))
+		if err := ioutil.WriteFile(name, out.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb := filebrowse.NewFileBrowser(dir)
+	tl, err := fb.GetTimelapse("G0000001.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fb, tl
+}
+
+// runJob validates and converts config, returning the progress reported.
+func runJob(t *testing.T, convert func(context.Context, *log.Logger, Config, filebrowse.ITimelapse, chan<- int) error,
+	fb *filebrowse.FileBrowser, tl filebrowse.ITimelapse, config *baseConfig) ([]int, error) {
+	setMinFreeSpace(t, 0)
+
+	if err := config.Resolve(fb); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if err := config.Validate(context.Background(), tl); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	progress := make(chan int, 1000)
+	err := convert(context.Background(), logger, config, tl, progress)
+	close(progress)
+	var got []int
+	for p := range progress {
+		got = append(got, p)
+	}
+	return got, err
+}
+
+func TestConvertExport(t *testing.T) {
+	fb, tl := writeTestSequence(t, 5, image.Pt(800, 450))
+	config := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "frames",
+		OutputProfileName: "360p (640x360)",
+		Width:             800,
+		Height:            450,
+		EndFrame:          4,
+		Skip:              2,
+		Export:            true,
+		ExportFormat:      "jpeg",
+		ExportExif:        true,
+	}
+	progress, err := runJob(t, ConvertExport, fb, tl, config)
+	if err != nil {
+		t.Fatalf("ConvertExport() error = %v", err)
+	}
+	if n := len(progress); n != 3 || progress[n-1] != 100 {
+		t.Errorf("progress = %v, want 3 steps to 100", progress)
+	}
+
+	dir := config.GetOutputFullPath(config.GetFilename())
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil || len(files) != 3 {
+		t.Fatalf("exported %v, %v; want 3 frames", files, err)
+	}
+	wantExif, err := filebrowse.RewriteExifSegment(testExif, image.Pt(640, 360))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range files {
+		if want := filepath.Join(dir, fmt.Sprintf("frames%06d.jpg", i)); name != want {
+			t.Errorf("frame %d is %v, want %v", i, name, want)
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.DecodeIntoRGBA(f, &jpeg.DecoderOptions{})
+		f.Close()
+		if err != nil {
+			t.Fatalf("decode %v: %v", name, err)
+		}
+		if img.Rect.Size() != image.Pt(640, 360) {
+			t.Errorf("frame %d size = %v, want 640x360", i, img.Rect.Size())
+		}
+		// Frames 0, 2 and 4 of the sequence.
+		if c := img.RGBAAt(320, 180); int(c.R) < 80*i-3 || int(c.R) > 80*i+3 {
+			t.Errorf("frame %d level = %v, want about %d", i, c, 80*i)
+		}
+		f, _ = os.Open(name)
+		seg, err := filebrowse.ReadExifSegment(f)
+		f.Close()
+		if err != nil || !bytes.Equal(seg, wantExif) {
+			t.Errorf("frame %d EXIF = %q, %v; want %q", i, seg, err, wantExif)
+		}
+	}
+
+	// Exports are not overwritten by default.
+	config = &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "frames",
+		OutputProfileName: "360p (640x360)",
+		Width:             800,
+		Height:            450,
+		EndFrame:          4,
+		Export:            true,
+	}
+	config.Resolve(fb)
+	if err := config.Validate(context.Background(), tl); err == nil {
+		t.Errorf("Validate() over an existing export succeeded")
+	}
+
+	// Nor replaced if created while the job ran.
+	part := newPartialOutput(dir, false)
+	defer part.Cleanup()
+	if err := os.Mkdir(part.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitExport(part, "frames", getImageFormat("jpeg")); err == nil {
+		t.Errorf("commitExport() over an existing export succeeded")
+	}
+
+	// Replacing an export swaps its frames, leaving other files alone.
+	notes := filepath.Join(dir, "notes.txt")
+	if err := ioutil.WriteFile(notes, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Overwrite = OverwriteReplace
+	config.EndFrame = 1
+	if _, err := runJob(t, ConvertExport, fb, tl, config); err != nil {
+		t.Fatalf("ConvertExport() error = %v", err)
+	}
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "frames000000.jpg"), filepath.Join(dir, "frames000001.jpg"), notes}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("replaced export (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(partialPath(dir)); !os.IsNotExist(err) {
+		t.Errorf("partial export folder left behind: %v", err)
+	}
+}
+
+func TestImageFormatTIFF16(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.SetRGBA(1, 1, color.RGBA{R: 255, G: 128, B: 1, A: 255})
+	buf := new(bytes.Buffer)
+	if err := getImageFormat("tiff16").Encode(buf, img); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	got, err := tiff.Decode(buf)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	wide, ok := got.(*image.RGBA64)
+	if !ok {
+		t.Fatalf("decoded %T, want 16 bit RGBA", got)
+	}
+	if got.Bounds() != img.Rect {
+		t.Errorf("bounds = %v, want %v", got.Bounds(), img.Rect)
+	}
+	if c, want := wide.RGBA64At(1, 1), (color.RGBA64{R: 0xFFFF, G: 0x8080, B: 0x0101, A: 0xFFFF}); c != want {
+		t.Errorf("pixel = %v, want %v", c, want)
+	}
+}
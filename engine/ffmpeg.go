@@ -10,13 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
 	"timelapse-queue/filebrowse"
-	"timelapse-queue/process"
 	"timelapse-queue/util"
 
 	"github.com/davecgh/go-spew/spew"
@@ -53,18 +51,23 @@ func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
 }
 
 // imageWriter writes RGBA images directly to FFmpeg to be used as rawvideo input.
+// Written images are released to the frame pool.
 type imageWriter struct {
 	out    io.Writer
 	bufOut *bytes.Buffer
+	pool   *util.FramePool
 }
 
-func newImageWriter(w io.Writer) *imageWriter {
+func newImageWriter(w io.Writer, pool *util.FramePool) *imageWriter {
 	return &imageWriter{
-		out: w,
+		out:  w,
+		pool: pool,
 	}
 }
 
 func (w *imageWriter) Write(img *image.RGBA) error {
+	defer w.pool.Put(img)
+
 	sz := img.Rect.Dx() * img.Rect.Dy() * 4
 	if len(img.Pix) == sz {
 		// Region covers the entire pixel buffer, simply write everything directly to output.
@@ -103,44 +106,26 @@ func (w *imageWriter) Write(img *image.RGBA) error {
 
 func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, timelapse filebrowse.ITimelapse, progress chan<- int) error {
 	opts := config.GetConvertOptions()
+	if opts.Audio != nil && opts.Audio.Path == "" {
+		// Never drop the requested audio track silently.
+		return fmt.Errorf("audio file not resolved, config not validated")
+	}
 
 	ctx, cancelf := context.WithCancel(pctx)
 	defer cancelf()
 
+	// Frame buffers are recycled between stages for the duration of the job.
+	pool := util.NewFramePool()
+	defer pool.Close()
+
 	outp, err := config.GetOutputProfile()
 	if err != nil {
 		return err
 	}
 
-	// TODO: maybe use a filter chain in config to apply this sort of logic.
-	start, end := config.GetStartEnd()
-	skip := config.GetSkip()
-	imagec, imerrc := filebrowse.Images(ctx, timelapse, start, end, skip)
-
-	if deg := config.GetRotate(); deg != 0 {
-		rotate := process.Rotate{
-			Degrees: deg,
-		}
-		imagec, imerrc = rotate.Process(ctx, imagec, imerrc)
-	}
-
-	cropper := process.Crop{
-		Region: config.GetRegion(),
-	}
-	imagec, imerrc = cropper.Process(ctx, imagec, imerrc)
-
-	resizer := process.Resizer{
-		Size: image.Point{X: outp.Width, Y: outp.Height},
-	}
-	imagec, imerrc = resizer.Process(ctx, imagec, imerrc)
-
-	if opts.Stack {
-		stacker := process.Stacker{
-			Overlap: opts.StackWindow,
-			Skip:    opts.StackSkipCount,
-			Merger:  process.GetMergerByName(opts.StackMode),
-		}
-		imagec, imerrc = stacker.Process(ctx, imagec, imerrc)
+	imagec, imerrc, err := buildPipeline(ctx, logger, config, timelapse, pool, -1)
+	if err != nil {
+		return err
 	}
 
 	// Writes errors both to the system logger and the file logger.
@@ -185,28 +170,80 @@ func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, time
 		}
 	}()
 
+	// With motion interpolation, frames are fed in at a reduced rate and FFmpeg
+	// synthesizes the rest to reach the output framerate.
+	inputRate := fmt.Sprintf("%d", config.GetFPS())
+	if opts.Interpolate == InterpolateMotion {
+		inputRate = fmt.Sprintf("%d/%d", config.GetFPS(), opts.InterpolateFactor)
+	}
+
 	args := []string{
-		"-framerate", fmt.Sprintf("%d", config.GetFPS()),
+		"-framerate", inputRate,
 		"-f", "rawvideo",
 		"-pixel_format", "bgr32",
 		"-video_size", fmt.Sprintf("%dx%d", sample.Rect.Dx(), sample.Rect.Dy()),
 		"-i", "-", // Read from stdin.
-
-		"-c:v", "libx264",
-		"-preset", "slow",
-		"-crf", "16",
 	}
-	args = append(args, outp.FFmpegArgs...)
+	var filters, audioOut []string
+	if opts.Audio != nil && opts.Animated == "" {
+		var audioIn []string
+		duration := float64(config.GetExpectedFrames()) / float64(config.GetFPS())
+		audioIn, audioOut = audioArgs(opts.Audio, duration)
+		args = append(args, audioIn...)
+	}
+	if opts.Interpolate == InterpolateMotion {
+		filters = append(filters, fmt.Sprintf("minterpolate=fps=%d:mi_mode=mci", config.GetFPS()))
+	}
+	// Encode to a hidden temporary file, renamed into place once verified.
+	final := config.GetOutputFullPath(config.GetFilename())
+	out := partialPath(final)
+	var intermediates []string
+	if opts.Animated == AnimatedGIF {
+		intermediates = append(intermediates, gifIntermediate(out), gifPalette(out))
+	}
+	part := newPartialOutput(final, opts.Overwrite, intermediates...)
+	defer part.Cleanup()
+	// FFmpeg reports progress in output frames, which animated images reduce.
+	expected := config.GetExpectedFrames()
+	if opts.Animated != "" {
+		af, aargs := animatedArgs(opts.Animated, opts.AnimatedFPS)
+		filters = append(filters, af...)
+		args = append(args, aargs...)
+		expected = expected * opts.AnimatedFPS / config.GetFPS()
+		if opts.Animated == AnimatedGIF {
+			out = gifIntermediate(out)
+		}
+	} else {
+		x := getX264Settings(opts.Preview)
+		args = append(args, []string{
+			"-c:v", "libx264",
+			"-preset", x.Preset,
+			"-crf", strconv.Itoa(x.CRF),
+		}...)
+		args = append(args, outp.FFmpegArgs...)
+		args = append(args, []string{
+			"-x264opts", "colorprim=bt709:transfer=bt709:colormatrix=bt709:fullrange=off",
+			"-s", fmt.Sprintf("%dx%d", sample.Rect.Dx(), sample.Rect.Dy()),
+		}...)
+		meta := newOutputMetadata(config, timelapse)
+		logger.Infof("Output metadata: %+v", meta)
+		args = append(args, meta.ffmpegArgs()...)
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args, audioOut...)
+	if expected < 1 {
+		expected = 1
+	}
 	args = append(args, []string{
-		"-x264opts", "colorprim=bt709:transfer=bt709:colormatrix=bt709:fullrange=off",
-		"-s", fmt.Sprintf("%dx%d", sample.Rect.Dx(), sample.Rect.Dy()),
-
 		// Prefix output with logging level.
 		"-loglevel", "level+info",
 		// Write progress in a more parseable format to stdout.
 		"-progress", "/dev/stdout",
 
-		timelapse.GetOutputFullPath(config.GetFilename()),
+		// The temporary output is ours to replace, e.g. if left by a crash.
+		"-y", out,
 	}...)
 
 	cmd := exec.Command(util.LocateFFmpegOrDie(), args...)
@@ -257,7 +294,7 @@ func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, time
 				log.Errorf("Failed to convert frame number %s to int", m[1])
 				continue
 			}
-			progress <- 100 * i / config.GetExpectedFrames()
+			progress <- 100 * i / expected
 			watchdog.Reset(watchdogDuration) // pet
 		}
 	}()
@@ -269,7 +306,7 @@ func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, time
 	}
 	go func() {
 		defer pin.Close()
-		imgWriter := newImageWriter(pin)
+		imgWriter := newImageWriter(pin, pool)
 		// Make sure to include the sample image we took earlier.
 		if err := imgWriter.Write(sample); err != nil {
 			dualErrorf("Failed to write initial image to ffmpeg: %v", err)
@@ -284,11 +321,11 @@ func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, time
 				dualErrorf("Deadline exceeded waiting for next frame")
 
 				wp := func(s string, o func(*profile.Profile)) {
-					ppath := profile.ProfilePath(timelapse.GetOutputFullPath(fmt.Sprintf("timeout_%s_profile", s)))
-					p := profile.Start(o, ppath)
+					dir := config.GetOutputFullPath(fmt.Sprintf("timeout_%s_profile", s))
+					p := profile.Start(o, profile.ProfilePath(dir))
 					time.Sleep(10 * time.Second)
 					p.Stop()
-					dualErrorf("%s profile written to %s", s, ppath)
+					dualErrorf("%s profile written to %s", s, dir)
 				}
 				wp("cpu", profile.CPUProfile)
 				wp("memory", profile.MemProfile)
@@ -306,9 +343,6 @@ func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, time
 					cancelf()
 					return
 				}
-
-				// Buffered images can be enormous: force GC after each frame to avoid bloat.
-				runtime.GC()
 			}
 		}
 	}()
@@ -336,6 +370,46 @@ func ConvertFFMpeg(pctx context.Context, logger *log.Logger, config Config, time
 				log.Warnf("Conversion failed: %v.", err)
 				return err
 			}
+			if err := ctx.Err(); err != nil {
+				// Frames stopped early but FFmpeg finished cleanly on the
+				// closed input; the partial output is truncated.
+				log.Warnf("Conversion canceled: %v.", err)
+				return err
+			}
+			if opts.Animated == AnimatedGIF {
+				if err := encodeGIF(ctx, logger, out, part.Path); err != nil {
+					dualErrorf("Failed to encode GIF: %v", err)
+					return err
+				}
+			}
+			want := outputExpectation{
+				Codec:  "h264",
+				Frames: expected,
+				Width:  outp.Width,
+				Height: outp.Height,
+				FPS:    config.GetFPS(),
+			}
+			if opts.Interpolate == InterpolateMotion {
+				// The last input frame is not interpolated past.
+				want.Tolerance = opts.InterpolateFactor
+			}
+			switch opts.Animated {
+			case AnimatedGIF:
+				want.Codec, want.FPS = "gif", opts.AnimatedFPS
+			case AnimatedWebP:
+				// FFmpeg cannot demux animated WebP, so it can't be probed.
+				want.Codec = ""
+			}
+			if want.Codec != "" {
+				if err := verifyOutput(ctx, logger, part.Path, want); err != nil {
+					dualErrorf("%v", err)
+					return err
+				}
+			}
+			if err := commitWithSidecar(part, config, timelapse, part.Commit); err != nil {
+				dualErrorf("Failed to move output into place: %v", err)
+				return err
+			}
 			log.Info("Conversion succeeded.")
 			return nil
 		case <-donec:
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/pixiv/go-libjpeg/jpeg"
+	"golang.org/x/image/tiff"
+)
+
+// ImageFormat is an image file format for still images and exported image
+// sequences.
+type ImageFormat struct {
+	Name   string
+	Ext    string
+	Encode func(w io.Writer, img image.Image) error
+	// BitsPerPixel approximates the output size per pixel, for size estimates.
+	BitsPerPixel float64
+}
+
+// ImageFormats defines the possible image output formats.
+var ImageFormats = []*ImageFormat{
+	{
+		Name: "jpeg",
+		Ext:  ".jpg",
+		Encode: func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.EncoderOptions{Quality: 95})
+		},
+		BitsPerPixel: 3,
+	},
+	{
+		Name:         "png",
+		Ext:          ".png",
+		Encode:       png.Encode,
+		BitsPerPixel: 14,
+	},
+	{
+		Name: "tiff",
+		Ext:  ".tif",
+		Encode: func(w io.Writer, img image.Image) error {
+			return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+		},
+		BitsPerPixel: 16,
+	},
+	{
+		// 16 bits per channel, for grading tools which keep the extra precision
+		// through their own processing.
+		Name: "tiff16",
+		Ext:  ".tif",
+		Encode: func(w io.Writer, img image.Image) error {
+			wide := image.NewRGBA64(img.Bounds())
+			draw.Draw(wide, wide.Rect, img, img.Bounds().Min, draw.Src)
+			return tiff.Encode(w, wide, &tiff.Options{Compression: tiff.Deflate})
+		},
+		BitsPerPixel: 32,
+	},
+}
+
+func getImageFormat(name string) *ImageFormat {
+	for _, f := range ImageFormats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
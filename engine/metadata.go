@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version identifies the build in output metadata, the build timestamp in unix
+// seconds if set.
+var Version = ""
+
+// softwareVersion describes the software in output metadata.
+func softwareVersion() string {
+	if ts, err := strconv.ParseInt(Version, 10, 64); err == nil {
+		return fmt.Sprintf("timelapse-queue build %s", time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05"))
+	}
+	return "timelapse-queue"
+}
+
+// outputMetadata describes how an output was made.
+type outputMetadata struct {
+	Software string
+	// Created is the capture time of the first frame, zero if unknown.
+	Created time.Time
+	// Location of the first frame, if HasGPS is set.
+	HasGPS                        bool
+	Latitude, Longitude, Altitude float64 `json:",omitempty"`
+
+	Source               string
+	StartFrame, EndFrame int
+	Skip                 int
+	// Stages holds the stages recorded in metadata, such as stacking.
+	Stages []process.StageSpec `json:",omitempty"`
+}
+
+func newOutputMetadata(config Config, timelapse filebrowse.ITimelapse) *outputMetadata {
+	start, end := config.GetStartEnd()
+	m := &outputMetadata{
+		Software:   softwareVersion(),
+		Source:     timelapse.ImagePath(),
+		StartFrame: start,
+		EndFrame:   end,
+		Skip:       config.GetSkip(),
+		Created:    frameTime(timelapse, start),
+	}
+	if x, err := filebrowse.FrameExif(timelapse, start); err == nil && x.HasGPS {
+		m.HasGPS = true
+		m.Latitude, m.Longitude, m.Altitude = x.Latitude, x.Longitude, x.Altitude
+	}
+	for _, spec := range config.GetStages() {
+		if t := process.GetStageByName(spec.Type); t != nil && t.InMetadata {
+			m.Stages = append(m.Stages, spec)
+		}
+	}
+	return m
+}
+
+// ffmpegArgs returns the FFmpeg output arguments writing the metadata into the
+// container.
+func (m *outputMetadata) ffmpegArgs() []string {
+	comment := []string{
+		fmt.Sprintf("source=%s", m.Source),
+		fmt.Sprintf("frames=%d-%d", m.StartFrame, m.EndFrame),
+		fmt.Sprintf("skip=%d", m.Skip),
+	}
+	for _, s := range m.Stages {
+		comment = append(comment, fmt.Sprintf("%s=%s", s.Type, s.Params))
+	}
+	args := []string{
+		"-metadata", "comment=" + strings.Join(comment, "; "),
+		"-metadata", "description=Rendered by " + m.Software,
+	}
+	if !m.Created.IsZero() {
+		args = append(args, "-metadata", "creation_time="+m.Created.UTC().Format("2006-01-02T15:04:05.000000Z"))
+	}
+	if m.HasGPS {
+		// ISO 6709, as used by the QuickTime location atom.
+		args = append(args, "-metadata", fmt.Sprintf("location=%+08.4f%+09.4f%+.1f/", m.Latitude, m.Longitude, m.Altitude))
+	}
+	return args
+}
+
+// sidecarFilename returns the name of the sidecar JSON of the output name.
+func sidecarFilename(name string) string {
+	return name + ".json"
+}
+
+// sidecar is the JSON written next to outputs, with the full job config so
+// they can be re-rendered.
+type sidecar struct {
+	Metadata *outputMetadata
+	Rendered time.Time
+	Config   Config
+}
+
+// writeSidecar writes the sidecar JSON of a finished job to path.
+func writeSidecar(path string, config Config, m *outputMetadata) error {
+	b, err := json.MarshalIndent(&sidecar{
+		Metadata: m,
+		Rendered: time.Now(),
+		Config:   config,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// commitWithSidecar writes the sidecar of the job's output part, then moves
+// both into place with commit, so that neither is left without the other.
+func commitWithSidecar(part *partialOutput, config Config, timelapse filebrowse.ITimelapse, commit func() error) error {
+	sc := newPartialOutput(config.GetOutputFullPath(sidecarFilename(config.GetFilename())), part.replace)
+	defer sc.Cleanup()
+	if err := writeSidecar(sc.Path, config, newOutputMetadata(config, timelapse)); err != nil {
+		return fmt.Errorf("failed to write sidecar: %v", err)
+	}
+	if err := sc.Commit(); err != nil {
+		return err
+	}
+	if err := commit(); err != nil {
+		if err := os.Remove(sc.Final); err != nil {
+			log.Warnf("Failed to remove sidecar %v: %v", sc.Final, err)
+		}
+		return err
+	}
+	return nil
+}
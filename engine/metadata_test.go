@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"timelapse-queue/process"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutputMetadataFFmpegArgs(t *testing.T) {
+	base := outputMetadata{
+		Software:   "timelapse-queue",
+		Source:     "2021/day1/G0021015.JPG",
+		StartFrame: 10,
+		EndFrame:   500,
+		Skip:       2,
+	}
+	tests := []struct {
+		name   string
+		modify func(m *outputMetadata)
+		want   []string
+	}{
+		{
+			name: "minimal",
+			want: []string{
+				"-metadata", "comment=source=2021/day1/G0021015.JPG; frames=10-500; skip=2",
+				"-metadata", "description=Rendered by timelapse-queue",
+			},
+		},
+		{
+			name: "stages and time",
+			modify: func(m *outputMetadata) {
+				m.Stages = []process.StageSpec{{Type: "stack", Params: json.RawMessage(`{"Mode":"max"}`)}}
+				m.Created = time.Date(2021, 6, 1, 22, 30, 15, 250000000, time.FixedZone("CEST", 2*3600))
+			},
+			want: []string{
+				"-metadata", `comment=source=2021/day1/G0021015.JPG; frames=10-500; skip=2; stack={"Mode":"max"}`,
+				"-metadata", "description=Rendered by timelapse-queue",
+				"-metadata", "creation_time=2021-06-01T20:30:15.250000Z",
+			},
+		},
+		{
+			name: "location",
+			modify: func(m *outputMetadata) {
+				m.HasGPS = true
+				m.Latitude, m.Longitude, m.Altitude = 51.5007, -0.1246, 35
+			},
+			want: []string{
+				"-metadata", "comment=source=2021/day1/G0021015.JPG; frames=10-500; skip=2",
+				"-metadata", "description=Rendered by timelapse-queue",
+				"-metadata", "location=+51.5007-000.1246+35.0/",
+			},
+		},
+	}
+	for _, tc := range tests {
+		m := base
+		if tc.modify != nil {
+			tc.modify(&m)
+		}
+		if diff := cmp.Diff(tc.want, m.ffmpegArgs()); diff != "" {
+			t.Errorf("%s: ffmpegArgs() (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestISO6709Location(t *testing.T) {
+	tests := []struct {
+		lat, lon, alt float64
+		want          string
+	}{
+		{0, 0, 0, "+00.0000+000.0000+0.0/"},
+		{5.25, 7.5, 0, "+05.2500+007.5000+0.0/"},
+		{-33.85678, 151.21529, 4.26, "-33.8568+151.2153+4.3/"},
+		{64.1466, -21.9426, -12, "+64.1466-021.9426-12.0/"},
+		{-90, -180, 8848.86, "-90.0000-180.0000+8848.9/"},
+	}
+	for _, tc := range tests {
+		m := &outputMetadata{HasGPS: true, Latitude: tc.lat, Longitude: tc.lon, Altitude: tc.alt}
+		args := m.ffmpegArgs()
+		if got := args[len(args)-1]; got != "location="+tc.want {
+			t.Errorf("location of %v, %v, %v = %q, want %q", tc.lat, tc.lon, tc.alt, got, "location="+tc.want)
+		}
+	}
+}
+
+func TestCommitWithSidecar(t *testing.T) {
+	useJournal(t)
+	setMinFreeSpace(t, 0)
+	fb, tl := writeTestSequence(t, 2, image.Pt(640, 360))
+	config := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "out",
+		OutputProfileName: "360p (640x360)",
+		Width:             640,
+		Height:            360,
+		EndFrame:          1,
+	}
+	if err := config.Resolve(fb); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(context.Background(), tl); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	final := config.GetOutputFullPath("out.mp4")
+	sidecarPath := config.GetOutputFullPath("out.mp4.json")
+
+	// A failed commit leaves neither the output nor the sidecar behind.
+	part := newPartialOutput(final, false)
+	writeFile(t, part.Path)
+	failed := errors.New("commit failed")
+	if err := commitWithSidecar(part, config, tl, func() error { return failed }); err != failed {
+		t.Errorf("commitWithSidecar() = %v, want %v", err, failed)
+	}
+	part.Cleanup()
+	for _, f := range []string{final, sidecarPath, partialPath(sidecarPath)} {
+		if exists(f) {
+			t.Errorf("%v left behind by a failed commit", f)
+		}
+	}
+
+	part = newPartialOutput(final, false)
+	writeFile(t, part.Path)
+	if err := commitWithSidecar(part, config, tl, part.Commit); err != nil {
+		t.Fatalf("commitWithSidecar() error = %v", err)
+	}
+	if !exists(final) {
+		t.Errorf("output not committed")
+	}
+	b, err := ioutil.ReadFile(sidecarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Metadata outputMetadata
+		Config   baseConfig
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("sidecar %s: %v", b, err)
+	}
+	if got.Metadata.Source != tl.ImagePath() || got.Config.OutputName != "out" {
+		t.Errorf("sidecar = %s, want the job's metadata and config", b)
+	}
+	if got := journal(t); got != nil {
+		t.Errorf("journal = %v after commit, want none", got)
+	}
+
+	// Nor is an existing sidecar replaced, unless overwriting.
+	if err := os.Remove(final); err != nil {
+		t.Fatal(err)
+	}
+	part = newPartialOutput(final, false)
+	writeFile(t, part.Path)
+	if err := commitWithSidecar(part, config, tl, part.Commit); err == nil {
+		t.Errorf("commitWithSidecar() over an existing sidecar succeeded")
+	}
+	part.Cleanup()
+	if exists(final) {
+		t.Errorf("output committed without its sidecar")
+	}
+}
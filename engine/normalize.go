@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+)
+
+const (
+	// captureWorkers is the number of images whose capture time is read in
+	// parallel, hiding the latency of network storage.
+	captureWorkers = 8
+	// maxNormalizeRepeat bounds the output frames of interval normalization,
+	// as a multiple of the frames captured, against intervals far shorter
+	// than the capture interval.
+	maxNormalizeRepeat = 20
+)
+
+// captureTimes returns the EXIF capture times of images start to end, in
+// seconds from the first.
+func captureTimes(pctx context.Context, t filebrowse.ITimelapse, start, end int) ([]float64, error) {
+	stamps := make([]time.Time, end+1-start)
+	ctx, cancelf := context.WithCancel(pctx)
+	defer cancelf()
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < captureWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Images are claimed in order and always read once claimed, so
+			// all before a failed image have been read.
+			for ctx.Err() == nil {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(stamps) {
+					return
+				}
+				x, err := filebrowse.FrameExif(t, start+i)
+				if err != nil || x.Time.IsZero() {
+					cancelf()
+					return
+				}
+				stamps[i] = x.Time
+			}
+		}()
+	}
+	wg.Wait()
+	if err := pctx.Err(); err != nil {
+		return nil, err
+	}
+
+	times := make([]float64, 0, len(stamps))
+	for i, ts := range stamps {
+		if ts.IsZero() {
+			return nil, fmt.Errorf("frame %d has no capture time, required for interval normalization", start+i)
+		}
+		s := ts.Sub(stamps[0]).Seconds()
+		// Without sub-second tags, EXIF times have one second resolution,
+		// so fast intervals repeat.
+		if n := len(times); n > 0 && s < times[n-1] {
+			return nil, fmt.Errorf("capture times out of order at frame %d", start+i)
+		}
+		times = append(times, s)
+	}
+	return times, nil
+}
+
+// normalizeSchedule selects the frames read so each output frame advances a
+// constant capture time.
+func (f *baseConfig) normalizeSchedule(ctx context.Context, t filebrowse.ITimelapse) error {
+	if f.NormalizeInterval < 0 {
+		return fmt.Errorf("invalid normalization interval %v", f.NormalizeInterval)
+	}
+	times, err := captureTimes(ctx, t, f.StartFrame, f.EndFrame)
+	if err != nil {
+		return err
+	}
+	median := process.MedianInterval(times)
+	interval := f.NormalizeInterval
+	if interval == 0 {
+		interval = median
+		if interval == 0 {
+			return fmt.Errorf("capture times too coarse to find the interval, set one explicitly")
+		}
+	}
+	step := interval * float64(f.GetSkip())
+
+	// Check the length of the schedule before building it. Speed is
+	// interpolated between keyframes, so is never below the slowest.
+	slowest := 1.0
+	for i, k := range f.Speed {
+		if i == 0 || k.Speed < slowest {
+			slowest = k.Speed
+		}
+	}
+	span := times[len(times)-1] - times[0]
+	if span/(step*slowest) > float64(maxNormalizeRepeat*len(times)) {
+		return fmt.Errorf("normalization interval %vs too short for the %vs median capture interval, it would repeat frames over %d times",
+			interval, median, maxNormalizeRepeat)
+	}
+
+	f.schedule = process.RealTimeSchedule(times, f.StartFrame, step, f.Speed)
+	if len(f.schedule) == 0 {
+		return fmt.Errorf("interval normalization selected no frames")
+	}
+	f.blendSchedule = nil
+	if f.NormalizeBlend {
+		f.blendSchedule = process.RealTimeBlendSchedule(times, f.StartFrame, step, f.Speed)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// exifAt returns an EXIF segment with a capture time secs after a fixed time,
+// to the hundredth of a second.
+func exifAt(secs float64) []byte {
+	whole := int(secs)
+	dt := []byte("2021:06:05 14:00:00\x00")
+	copy(dt[14:], []byte{byte('0' + whole/600), byte('0' + whole/60%10)})
+	copy(dt[17:], []byte{byte('0' + whole%60/10), byte('0' + whole%10)})
+	cs := int(math.Round((secs - float64(whole)) * 100))
+	sub := []byte{byte('0' + cs/10), byte('0' + cs%10), 0, 0}
+
+	be := binary.BigEndian
+	b := new(bytes.Buffer)
+	b.WriteString("Exif\x00\x00MM\x00\x2a")
+	// IFD0 at 8 links to the Exif IFD at 26, which holds the capture time
+	// after its end at 56.
+	for _, v := range []interface{}{
+		uint32(8),
+		uint16(1), uint16(0x8769), uint16(4), uint32(1), uint32(26), uint32(0),
+		uint16(2),
+		uint16(0x9003), uint16(2), uint32(len(dt)), uint32(56),
+		uint16(0x9291), uint16(2), uint32(3), sub,
+		uint32(0),
+	} {
+		binary.Write(b, be, v)
+	}
+	b.Write(dt)
+	return b.Bytes()
+}
+
+func TestExifAt(t *testing.T) {
+	base, err := filebrowse.ParseExif(exifAt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secs := range []float64{0.5, 59.25, 61, 754.07} {
+		x, err := filebrowse.ParseExif(exifAt(secs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := x.Time.Sub(base.Time).Seconds(); math.Abs(got-secs) > 1e-6 {
+			t.Errorf("exifAt(%v) is %vs after exifAt(0)", secs, got)
+		}
+	}
+}
+
+func TestCaptureTimes(t *testing.T) {
+	want := []float64{0, 0.5, 1, 1.25, 6, 6}
+	_, tl := writeTestSequenceExif(t, len(want)+1, image.Pt(16, 16), func(i int) []byte {
+		if i == len(want) {
+			return testExif
+		}
+		return exifAt(100 + want[i])
+	})
+
+	got, err := captureTimes(context.Background(), tl, 0, len(want)-1)
+	if err != nil {
+		t.Fatalf("captureTimes() error = %v", err)
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b float64) bool { return math.Abs(a-b) < 1e-6 })); diff != "" {
+		t.Errorf("captureTimes() (-want +got):\n%s", diff)
+	}
+	got, err = captureTimes(context.Background(), tl, 2, 4)
+	if diff := cmp.Diff([]float64{0, 0.25, 5}, got); err != nil || diff != "" {
+		t.Errorf("captureTimes() from frame 2 = %v, %v; want from its time", got, err)
+	}
+
+	// The last frame has no capture time.
+	if _, err := captureTimes(context.Background(), tl, 0, len(want)); err == nil || !strings.Contains(err.Error(), "frame 6") {
+		t.Errorf("captureTimes() without a time = %v, want an error naming frame 6", err)
+	}
+	ctx, cancelf := context.WithCancel(context.Background())
+	cancelf()
+	if _, err := captureTimes(ctx, tl, 0, len(want)-1); err != context.Canceled {
+		t.Errorf("captureTimes() cancelled = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNormalizeSchedule(t *testing.T) {
+	setMinFreeSpace(t, 0)
+	// One second intervals, then four seconds.
+	times := []float64{0, 1, 2, 6, 10}
+	fb, tl := writeTestSequenceExif(t, len(times), image.Pt(640, 360), func(i int) []byte {
+		return exifAt(times[i])
+	})
+	newConfig := func() *baseConfig {
+		return &baseConfig{
+			Path:              tl.ImagePath(),
+			OutputName:        "out",
+			OutputProfileName: "360p (640x360)",
+			Width:             640,
+			Height:            360,
+			EndFrame:          len(times) - 1,
+			Normalize:         true,
+			Export:            true,
+		}
+	}
+	validate := func(f *baseConfig) error {
+		if err := f.Resolve(fb); err != nil {
+			t.Fatal(err)
+		}
+		return f.Validate(context.Background(), tl)
+	}
+
+	f := newConfig()
+	f.NormalizeInterval = 2
+	if err := validate(f); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	// Ties select the later frame.
+	if diff := cmp.Diff([]int{0, 2, 3, 3, 4, 4}, f.GetFrameSchedule()); diff != "" {
+		t.Errorf("GetFrameSchedule() (-want +got):\n%s", diff)
+	}
+	if f.GetBlendSchedule() != nil {
+		t.Errorf("GetBlendSchedule() = %v without blending", f.GetBlendSchedule())
+	}
+
+	f = newConfig()
+	f.NormalizeInterval = 1
+	f.NormalizeBlend = true
+	if err := validate(f); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	wantBlend := []process.BlendFrame{
+		{A: 0, B: 0}, {A: 1, B: 1}, {A: 2, B: 2},
+		{A: 2, B: 3, W: 0.25}, {A: 2, B: 3, W: 0.5}, {A: 2, B: 3, W: 0.75},
+		{A: 3, B: 3},
+		{A: 3, B: 4, W: 0.25}, {A: 3, B: 4, W: 0.5}, {A: 3, B: 4, W: 0.75},
+		{A: 4, B: 4},
+	}
+	if diff := cmp.Diff(wantBlend, f.GetBlendSchedule()); diff != "" {
+		t.Errorf("GetBlendSchedule() (-want +got):\n%s", diff)
+	}
+	if got := f.GetExpectedFrames(); got != len(wantBlend) {
+		t.Errorf("GetExpectedFrames() = %d, want %d", got, len(wantBlend))
+	}
+
+	// An interval far below the capture interval would repeat each frame
+	// thousands of times.
+	f = newConfig()
+	f.NormalizeInterval = 0.001
+	if err := validate(f); err == nil || !strings.Contains(err.Error(), "too short") {
+		t.Errorf("Validate() with a tiny interval = %v, want too short", err)
+	}
+	f = newConfig()
+	f.NormalizeInterval = 0.1
+	f.Speed = []process.SpeedKeyframe{{Frame: 0, Speed: 1}, {Frame: 4, Speed: 0.05}}
+	if err := validate(f); err == nil || !strings.Contains(err.Error(), "too short") {
+		t.Errorf("Validate() with a short interval and slow speed = %v, want too short", err)
+	}
+
+	f = newConfig()
+	f.Normalize = false
+	f.NormalizeBlend = true
+	if err := validate(f); err == nil {
+		t.Errorf("Validate() blending without normalization succeeded")
+	}
+}
+
+func TestConvertNormalizeBlend(t *testing.T) {
+	times := []float64{0, 1, 2, 6}
+	fb, tl := writeTestSequenceExif(t, len(times), image.Pt(640, 360), func(i int) []byte {
+		return exifAt(times[i])
+	})
+	config := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "frames",
+		OutputProfileName: "360p (640x360)",
+		Width:             640,
+		Height:            360,
+		EndFrame:          len(times) - 1,
+		Normalize:         true,
+		NormalizeBlend:    true,
+		Export:            true,
+		ExportFormat:      "png",
+	}
+	if _, err := runJob(t, ConvertExport, fb, tl, config); err != nil {
+		t.Fatalf("ConvertExport() error = %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(config.GetOutputFullPath("frames"), "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames are at levels 0, 40, 80 and 120; the gap after the third fades
+	// over four output frames.
+	want := []int{0, 40, 80, 90, 100, 110, 120}
+	var got []int
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("decode %v: %v", name, err)
+		}
+		r, _, _, _ := img.At(320, 180).RGBA()
+		got = append(got, int(r>>8))
+	}
+	// JPEG sources shift levels slightly.
+	near := cmp.Comparer(func(a, b int) bool { return a-b <= 3 && b-a <= 3 })
+	if diff := cmp.Diff(want, got, near); diff != "" {
+		t.Errorf("blended levels (-want +got):\n%s", diff)
+	}
+}
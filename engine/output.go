@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"timelapse-queue/filebrowse"
+)
+
+var (
+	// DefaultOutputLocation is the filebrowse output location of jobs that
+	// don't select one, with DefaultOutputDir relative to its root.
+	DefaultOutputLocation = filebrowse.OutputSource
+	DefaultOutputDir      = ""
+)
+
+// templateRE matches placeholders in output names, such as "{date}".
+var templateRE = regexp.MustCompile(`\{(\w*)\}`)
+
+// expandTemplate replaces placeholders in s with vars, failing on unknown
+// placeholders.
+func expandTemplate(s string, vars map[string]string) (string, error) {
+	var err error
+	out := templateRE.ReplaceAllStringFunc(s, func(m string) string {
+		k := m[1 : len(m)-1]
+		v, ok := vars[k]
+		if !ok && err == nil {
+			err = fmt.Errorf("unknown placeholder %v in %q", m, s)
+		}
+		return v
+	})
+	return out, err
+}
+
+// getCodec names the encoding of the job's output, for output names.
+func (f *baseConfig) getCodec() string {
+	switch {
+	case f.RenameOnly:
+		return "rename"
+	case f.Still != "":
+		return f.GetStillFormat()
+	case f.Export:
+		return f.GetExportFormat()
+	case f.Animated != "" && !f.Preview:
+		return f.Animated
+	}
+	return "h264"
+}
+
+// templateVars returns the values of output name placeholders for the job.
+func (f *baseConfig) templateVars(t filebrowse.ITimelapse) map[string]string {
+	name := t.TimelapseName()
+	vars := map[string]string{
+		"name":  strings.TrimSuffix(name, filepath.Ext(name)),
+		"codec": f.getCodec(),
+	}
+	if ft := frameTime(t, f.StartFrame); !ft.IsZero() {
+		vars["date"] = ft.Format("2006-01-02")
+	}
+	if outp, err := f.GetOutputProfile(); err == nil {
+		vars["profile"] = fmt.Sprintf("%dp", outp.Height)
+	}
+	return vars
+}
+
+// resolveOutput expands templates in the output name and directory, and sets
+// the directory outputs are written to. The templates are left untouched, so
+// that each Validate expands them again.
+func (f *baseConfig) resolveOutput(t filebrowse.ITimelapse) error {
+	if f.browser == nil {
+		return fmt.Errorf("config not resolved")
+	}
+	vars := f.templateVars(t)
+	name, err := expandTemplate(f.OutputName, vars)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid output filename %q", name)
+	}
+	dir, err := expandTemplate(f.OutputDir, vars)
+	if err != nil {
+		return err
+	}
+	full, err := f.browser.GetOutputDir(t, f.OutputLocation, dir)
+	if err != nil {
+		return err
+	}
+	f.outputName, f.outputRelDir, f.outputDir = name, dir, full
+	return nil
+}
+
+// checkWritable creates dir if needed, and checks files can be written to it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("unable to create output directory: %v", err)
+	}
+	tf, err := ioutil.TempFile(dir, partialPrefix+"check-")
+	if err != nil {
+		return fmt.Errorf("output directory not writable: %v", err)
+	}
+	tf.Close()
+	return os.Remove(tf.Name())
+}
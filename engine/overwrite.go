@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Overwrite policies, for when outputs of a job already exist.
+const (
+	// OverwriteFail rejects the job.
+	OverwriteFail = "fail"
+	// OverwriteReplace replaces the existing outputs.
+	OverwriteReplace = "overwrite"
+	// OverwriteVersion appends the first free version suffix to the output
+	// name, e.g. "name_v2".
+	OverwriteVersion = "version"
+
+	// maxOutputVersion bounds the search for a free version suffix.
+	maxOutputVersion = 1000
+)
+
+// versionRE matches a version suffix of an output name.
+var versionRE = regexp.MustCompile(`_v(\d+)$`)
+
+func validOverwrite(policy string) bool {
+	switch policy {
+	case OverwriteFail, OverwriteReplace, OverwriteVersion:
+		return true
+	}
+	return false
+}
+
+func (f *baseConfig) GetOverwrite() string {
+	if f.Overwrite == "" {
+		return OverwriteFail
+	}
+	return f.Overwrite
+}
+
+// renameGlob matches the outputs of rename jobs, the frame number and source
+// extension appended to the output name.
+func renameGlob(name string) string {
+	return name + "[0-9][0-9][0-9][0-9][0-9][0-9].*"
+}
+
+// existingOutput returns the name of an output of the job that already exists,
+// or "" if there is none.
+func (f *baseConfig) existingOutput() (string, error) {
+	for _, name := range []string{f.GetFilename(), f.GetDebugFilename(), sidecarFilename(f.GetFilename())} {
+		if _, err := os.Stat(f.GetOutputFullPath(name)); err == nil {
+			return name, nil
+		}
+	}
+	if f.RenameOnly {
+		m, err := filepath.Glob(f.GetOutputFullPath(renameGlob(f.outputName)))
+		if err != nil {
+			return "", err
+		}
+		if len(m) > 0 {
+			return filepath.Base(m[0]), nil
+		}
+	}
+	return "", nil
+}
+
+func (f *baseConfig) CheckOverwrite() error {
+	return f.applyOverwrite()
+}
+
+// applyOverwrite checks for existing outputs, applying the overwrite policy.
+// Auto-versioning updates the output name.
+func (f *baseConfig) applyOverwrite() error {
+	name, err := f.existingOutput()
+	if err != nil || name == "" {
+		return err
+	}
+	switch f.GetOverwrite() {
+	case OverwriteReplace:
+		return nil
+	case OverwriteVersion:
+		// Versioning a versioned name continues from its version.
+		base, v := f.outputName, 2
+		if m := versionRE.FindStringSubmatch(base); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			base, v = base[:len(base)-len(m[0])], n+1
+		}
+		for ; v <= maxOutputVersion; v++ {
+			f.outputName = fmt.Sprintf("%s_v%d", base, v)
+			if name, err = f.existingOutput(); err != nil || name == "" {
+				return err
+			}
+		}
+		return fmt.Errorf("no free output version for %v", base)
+	}
+	return fmt.Errorf("the output file %v already exists", name)
+}
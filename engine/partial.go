@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// partialPrefix marks hidden temporary outputs, renamed into place once the
+// job succeeds.
+const partialPrefix = ".partial-"
+
+var (
+	// PartialJournal is the file recording temporary outputs in progress, so
+	// that those left behind by a crash can be removed at startup. Empty
+	// disables the journal.
+	PartialJournal = ""
+
+	journalMu sync.Mutex
+)
+
+// partialPath returns the temporary path a job writes in place of out. It is
+// in the same directory, so the final rename is atomic, and keeps the
+// extension, which FFmpeg uses to pick the container.
+func partialPath(out string) string {
+	dir, name := filepath.Split(out)
+	return filepath.Join(dir, partialPrefix+name)
+}
+
+// readJournal returns the paths recorded in the journal.
+func readJournal() ([]string, error) {
+	f, err := os.Open(PartialJournal)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var paths []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if p := strings.TrimSpace(s.Text()); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, s.Err()
+}
+
+// writeJournal replaces the journal contents with paths.
+func writeJournal(paths []string) error {
+	if len(paths) == 0 {
+		if err := os.Remove(PartialJournal); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(PartialJournal, []byte(strings.Join(paths, "\n")+"\n"), 0644)
+}
+
+// journalPartial records (or with add unset, forgets) temporary paths.
+func journalPartial(add bool, paths ...string) {
+	if PartialJournal == "" {
+		return
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	cur, err := readJournal()
+	if err != nil {
+		log.Warnf("Failed to read partial output journal: %v", err)
+	}
+	drop := make(map[string]bool)
+	for _, p := range paths {
+		drop[p] = true
+	}
+	var next []string
+	for _, p := range cur {
+		if !drop[p] {
+			next = append(next, p)
+		}
+	}
+	if add {
+		next = append(next, paths...)
+	}
+	if err := writeJournal(next); err != nil {
+		log.Warnf("Failed to write partial output journal: %v", err)
+	}
+}
+
+// partialOutput is a temporary output file (or directory) of a job, plus any
+// intermediates.
+type partialOutput struct {
+	Path  string
+	Final string
+	// replace allows Commit to replace an existing output.
+	replace bool
+	extra   []string
+	done    bool
+}
+
+// newPartialOutput journals a temporary output for final, and intermediates
+// written alongside it. Committing replaces an existing final output only if
+// replace is set.
+func newPartialOutput(final string, replace bool, extra ...string) *partialOutput {
+	p := &partialOutput{
+		Path:    partialPath(final),
+		Final:   final,
+		replace: replace,
+		extra:   extra,
+	}
+	journalPartial(true, p.paths()...)
+	return p
+}
+
+func (p *partialOutput) paths() []string {
+	return append([]string{p.Path}, p.extra...)
+}
+
+// Commit moves the temporary output file into place. Unless replacing, it fails
+// if the output was created while the job ran.
+func (p *partialOutput) Commit() error {
+	if p.replace {
+		if err := os.Rename(p.Path, p.Final); err != nil {
+			return err
+		}
+	} else if err := os.Link(p.Path, p.Final); err == nil {
+		// The link fails if the output exists, unlike a rename, which would
+		// replace it.
+		if err := os.Remove(p.Path); err != nil {
+			log.Warnf("Failed to remove partial output %v: %v", p.Path, err)
+		}
+	} else if os.IsExist(err) {
+		return fmt.Errorf("the output file %v already exists", p.Final)
+	} else {
+		// Not all filesystems support hard links. Checking first narrows the
+		// window in which an output created meanwhile is replaced.
+		if _, err := os.Lstat(p.Final); !os.IsNotExist(err) {
+			return fmt.Errorf("the output file %v already exists", p.Final)
+		}
+		if err := os.Rename(p.Path, p.Final); err != nil {
+			return err
+		}
+	}
+	p.done = true
+	p.Cleanup()
+	return nil
+}
+
+// Cleanup removes the temporary output unless committed, and intermediates.
+// Safe to call more than once.
+func (p *partialOutput) Cleanup() {
+	for _, f := range p.paths() {
+		if f == p.Path && p.done {
+			continue
+		}
+		remove := os.Remove
+		if f == p.Path {
+			// The temporary output may be a directory of exported frames.
+			remove = os.RemoveAll
+		}
+		if err := remove(f); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove partial output %v: %v", f, err)
+		}
+	}
+	journalPartial(false, p.paths()...)
+}
+
+// CleanPartialOutputs removes temporary outputs left behind by jobs that were
+// interrupted, e.g. by a crash or restart.
+func CleanPartialOutputs() {
+	if PartialJournal == "" {
+		return
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	paths, err := readJournal()
+	if err != nil {
+		log.Warnf("Failed to read partial output journal: %v", err)
+		return
+	}
+	for _, p := range paths {
+		// Never remove anything the journal didn't name a partial output.
+		if !strings.HasPrefix(filepath.Base(p), partialPrefix) {
+			continue
+		}
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(p); err == nil {
+			log.Infof("Removed partial output %v", p)
+		} else {
+			log.Warnf("Failed to remove partial output %v: %v", p, err)
+		}
+	}
+	if err := writeJournal(nil); err != nil {
+		log.Warnf("Failed to clear partial output journal: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// useJournal points PartialJournal at a file in a fresh directory for the
+// duration of the test, returning the directory.
+func useJournal(t *testing.T) string {
+	dir := t.TempDir()
+	old := PartialJournal
+	PartialJournal = filepath.Join(dir, "journal")
+	t.Cleanup(func() { PartialJournal = old })
+	return dir
+}
+
+func journal(t *testing.T) []string {
+	paths, err := readJournal()
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	return paths
+}
+
+func writeFile(t *testing.T, path string) {
+	if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestPartialPath(t *testing.T) {
+	got := partialPath(filepath.Join("out", "video.mp4"))
+	if want := filepath.Join("out", ".partial-video.mp4"); got != want {
+		t.Errorf("partialPath() = %v, want %v", got, want)
+	}
+}
+
+func TestJournalPartial(t *testing.T) {
+	useJournal(t)
+	journalPartial(true, "/a", "/b")
+	journalPartial(true, "/c")
+	if diff := cmp.Diff([]string{"/a", "/b", "/c"}, journal(t)); diff != "" {
+		t.Errorf("journal after add (-want +got):\n%s", diff)
+	}
+	// Re-adding a path doesn't duplicate it.
+	journalPartial(true, "/a")
+	journalPartial(false, "/b")
+	if diff := cmp.Diff([]string{"/c", "/a"}, journal(t)); diff != "" {
+		t.Errorf("journal after remove (-want +got):\n%s", diff)
+	}
+	journalPartial(false, "/a", "/c")
+	if exists(PartialJournal) {
+		t.Errorf("empty journal not removed")
+	}
+	if got := journal(t); got != nil {
+		t.Errorf("journal = %v, want none", got)
+	}
+}
+
+func TestJournalDisabled(t *testing.T) {
+	dir := useJournal(t)
+	PartialJournal = ""
+	p := newPartialOutput(filepath.Join(dir, "out.mp4"), false)
+	p.Cleanup()
+	CleanPartialOutputs()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("disabled journal wrote %v", files)
+	}
+}
+
+func TestPartialOutputCommit(t *testing.T) {
+	dir := useJournal(t)
+	final := filepath.Join(dir, "out.gif")
+	extra := filepath.Join(dir, ".partial-palette.png")
+	p := newPartialOutput(final, false, extra)
+	if diff := cmp.Diff([]string{p.Path, extra}, journal(t)); diff != "" {
+		t.Errorf("journal (-want +got):\n%s", diff)
+	}
+	writeFile(t, p.Path)
+	writeFile(t, extra)
+
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if b, err := ioutil.ReadFile(final); err != nil || string(b) != p.Path {
+		t.Errorf("committed output = %q, %v; want the partial output", b, err)
+	}
+	for _, f := range []string{p.Path, extra} {
+		if exists(f) {
+			t.Errorf("%v left behind after commit", f)
+		}
+	}
+	if got := journal(t); got != nil {
+		t.Errorf("journal = %v after commit, want none", got)
+	}
+	// Cleaning up again leaves the committed output alone.
+	p.Cleanup()
+	if !exists(final) {
+		t.Errorf("Cleanup() after Commit() removed the output")
+	}
+}
+
+func TestPartialOutputCommitExisting(t *testing.T) {
+	for _, replace := range []bool{false, true} {
+		dir := useJournal(t)
+		final := filepath.Join(dir, "out.mp4")
+		p := newPartialOutput(final, replace)
+		writeFile(t, p.Path)
+		// Written by something else while the job ran.
+		writeFile(t, final)
+
+		err := p.Commit()
+		p.Cleanup()
+		b, rerr := ioutil.ReadFile(final)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if replace {
+			if err != nil || string(b) != p.Path {
+				t.Errorf("Commit() replacing = %v, output %q; want the partial output", err, b)
+			}
+		} else if err == nil || string(b) != final {
+			t.Errorf("Commit() = %v, output %q; want an error, the output untouched", err, b)
+		}
+		if exists(p.Path) {
+			t.Errorf("partial output left behind, replace %v", replace)
+		}
+	}
+}
+
+func TestPartialOutputCleanup(t *testing.T) {
+	dir := useJournal(t)
+	final := filepath.Join(dir, "frames")
+	p := newPartialOutput(final, false)
+	// Exports write a folder of frames.
+	if err := os.Mkdir(p.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(p.Path, "frames000000.jpg"))
+
+	p.Cleanup()
+	p.Cleanup()
+	if exists(p.Path) || exists(final) {
+		t.Errorf("Cleanup() left outputs behind")
+	}
+	if got := journal(t); got != nil {
+		t.Errorf("journal = %v after cleanup, want none", got)
+	}
+}
+
+func TestCleanPartialOutputs(t *testing.T) {
+	dir := useJournal(t)
+	file := filepath.Join(dir, ".partial-out.mp4")
+	folder := filepath.Join(dir, ".partial-frames")
+	missing := filepath.Join(dir, ".partial-gone.mp4")
+	other := filepath.Join(dir, "out.mp4")
+	writeFile(t, file)
+	if err := os.Mkdir(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(folder, "frames000000.jpg"))
+	writeFile(t, other)
+	journalPartial(true, file, folder, missing, other)
+
+	CleanPartialOutputs()
+	for _, f := range []string{file, folder} {
+		if exists(f) {
+			t.Errorf("%v not removed", f)
+		}
+	}
+	if !exists(other) {
+		t.Errorf("%v removed, though not a partial output", other)
+	}
+	if exists(PartialJournal) {
+		t.Errorf("journal not cleared")
+	}
+}
@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"context"
+	"image"
+	"os"
+	"time"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+	"timelapse-queue/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// DecodeWorkers is the number of frames decoded ahead in parallel. Zero uses
+	// one worker per CPU.
+	DecodeWorkers = 0
+	// ScaledDecode enables decoding frames at reduced size when the crop region
+	// has enough resolution to spare for the output profile.
+	ScaledDecode = true
+)
+
+// frameTime returns the capture time of image idx from its EXIF data, falling
+// back to the file modification time, or zero if neither is available.
+func frameTime(timelapse filebrowse.ITimelapse, idx int) time.Time {
+	if x, err := filebrowse.FrameExif(timelapse, idx); err == nil && !x.Time.IsZero() {
+		return x.Time
+	}
+	if fi, err := os.Stat(timelapse.GetPathForIndex(idx)); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+// decodeScaleTarget returns the smallest decode size (in libjpeg's 1/8 DCT
+// scaling steps) at which the crop region still covers the output profile, or
+// an empty rectangle if frames must be decoded at full size.
+func decodeScaleTarget(src, region image.Rectangle, outp *Profile) image.Rectangle {
+	for sf := 1; sf < 8; sf++ {
+		if sf*region.Dx()/8 >= outp.Width && sf*region.Dy()/8 >= outp.Height {
+			return image.Rect(0, 0, (sf*src.Dx()+7)/8, (sf*src.Dy()+7)/8)
+		}
+	}
+	return image.Rectangle{}
+}
+
+// singleImage produces a stream containing just the frame at idx.
+func singleImage(t filebrowse.ITimelapse, idx int, opts *filebrowse.ImageOptions) (<-chan *image.RGBA, chan error) {
+	errc := make(chan error, 1)
+	imagec := make(chan *image.RGBA, 1)
+	img, err := filebrowse.Image(t, idx, opts)
+	if err != nil {
+		errc <- err
+	} else {
+		imagec <- img
+	}
+	close(imagec)
+	close(errc)
+	return imagec, errc
+}
+
+// buildPipeline assembles the processing stages for a job, producing a stream
+// of output frames. If frame is non-negative, only that frame of the timelapse
+// is processed (e.g. for previews), otherwise the job's full range is read.
+func buildPipeline(ctx context.Context, logger *log.Logger, config Config, timelapse filebrowse.ITimelapse, pool *util.FramePool, frame int) (<-chan *image.RGBA, chan error, error) {
+	outp, err := config.GetOutputProfile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start, end := config.GetStartEnd()
+	skip := config.GetSkip()
+	if frame >= 0 {
+		start = frame
+	}
+
+	specs := config.GetStages()
+	env := config.GetStageEnv()
+	env.Pool = pool
+	env.Name = timelapse.TimelapseName()
+	env.SourceFrame = inputSourceFrame(config, start)
+	env.FrameTime = func(idx int) time.Time {
+		return frameTime(timelapse, idx)
+	}
+
+	imopts := &filebrowse.ImageOptions{
+		Workers: DecodeWorkers,
+		Pool:    pool,
+	}
+	if ScaledDecode && process.ScalableChain(specs) {
+		src, err := filebrowse.ImageBounds(timelapse, start)
+		if err != nil {
+			return nil, nil, err
+		}
+		if target := decodeScaleTarget(src, config.GetRegion(), outp); !target.Empty() {
+			imopts.ScaleTarget = target
+			env.Source = process.SizeAfterRotate(src, config.GetRotate()).Size()
+			logger.Infof("Decoding frames at reduced size, target %v", target.Size())
+		}
+	}
+
+	stages, err := process.NewStages(specs, *env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var imagec <-chan *image.RGBA
+	var imerrc chan error
+	if frame >= 0 {
+		imagec, imerrc = singleImage(timelapse, frame, imopts)
+	} else {
+		// Stages such as motion blur consume every frame, blending those that
+		// would otherwise be skipped.
+		readSkip := skip
+		if process.ChainReadsSkipped(specs) {
+			readSkip = 1
+		}
+		if blend := config.GetBlendSchedule(); blend != nil {
+			// Each output frame blends the frames captured either side of it.
+			imagec, imerrc = filebrowse.ImageIndices(ctx, timelapse, process.BlendReads(blend), imopts)
+			tb := &process.TimeBlend{Frames: blend, Pool: pool}
+			imagec, imerrc = tb.Process(ctx, imagec, imerrc)
+		} else if sched := config.GetFrameSchedule(); sched != nil {
+			// Speed ramps read a variable selection of frames.
+			imagec, imerrc = filebrowse.ImageIndices(ctx, timelapse, sched, imopts)
+		} else {
+			imagec, imerrc = filebrowse.Images(ctx, timelapse, start, end, readSkip, imopts)
+		}
+	}
+
+	for _, p := range stages {
+		imagec, imerrc = p.Process(ctx, imagec, imerrc)
+	}
+	return imagec, imerrc, nil
+}
+
+// inputSourceFrame maps the index of a frame read from the timelapse, starting
+// at start, to the index of its image. Chains reading skipped frames read
+// every frame.
+func inputSourceFrame(config Config, start int) func(n int) int {
+	first, end := config.GetStartEnd()
+	if sched := config.GetFrameSchedule(); sched != nil && start == first {
+		return func(n int) int {
+			if n >= len(sched) {
+				return sched[len(sched)-1]
+			}
+			return sched[n]
+		}
+	}
+	skip := config.GetSkip()
+	if process.ChainReadsSkipped(config.GetStages()) {
+		skip = 1
+	}
+	return func(n int) int {
+		idx := start + n*skip
+		if idx > end {
+			// Stacking emits trailing frames past the end of the sequence.
+			idx = end
+		}
+		return idx
+	}
+}
+
+// outputSourceFrame maps the index of a frame output by the job's pipeline to
+// the index of the timelapse image it was derived from.
+func outputSourceFrame(config Config) func(n int) int {
+	start, _ := config.GetStartEnd()
+	in := inputSourceFrame(config, start)
+	specs := config.GetStages()
+	skip := config.GetSkip()
+	return func(n int) int {
+		return in(process.ChainSourceFrame(specs, skip, n))
+	}
+}
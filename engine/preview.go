@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+	"timelapse-queue/util"
+
+	"github.com/pixiv/go-libjpeg/jpeg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	previewDeadline = time.Minute
+)
+
+// PreviewServer renders a single frame through a job's processing pipeline,
+// so that settings can be checked before the job is queued. Setting the
+// "before" parameter renders the frame without color adjustments or grading.
+type PreviewServer struct {
+	Browser *filebrowse.FileBrowser
+}
+
+func (s *PreviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	config := &baseConfig{}
+	if err := json.Unmarshal([]byte(r.Form.Get("request")), config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idx, err := strconv.Atoi(r.Form.Get("index"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Form.Get("before") != "" {
+		var specs []process.StageSpec
+		for _, spec := range config.GetStages() {
+			if t := process.GetStageByName(spec.Type); t == nil || !t.Grading {
+				specs = append(specs, spec)
+			}
+		}
+		config.Stages = specs
+		// The chain already includes any crossfade stage.
+		if config.Interpolate == InterpolateCrossfade {
+			config.Interpolate = ""
+		}
+	}
+
+	t, err := s.Browser.GetTimelapse(config.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if idx < 0 || idx >= t.ImageCount() {
+		http.Error(w, fmt.Sprintf("index out of timelapse range %d to %d", 0, t.ImageCount()-1), http.StatusBadRequest)
+		return
+	}
+
+	if err := config.Resolve(s.Browser); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := config.validateProcessing(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelf := context.WithTimeout(r.Context(), previewDeadline)
+	defer cancelf()
+
+	// Pipeline progress is only interesting for jobs.
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	pool := util.NewFramePool()
+	defer pool.Close()
+
+	imagec, errc, err := buildPipeline(ctx, logger, config, t, pool, idx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case img, ok := <-imagec:
+		if !ok {
+			err := <-errc
+			if err == nil {
+				err = fmt.Errorf("no frame produced")
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		if err := jpeg.Encode(w, img, &jpeg.EncoderOptions{Quality: 90}); err != nil {
+			log.Errorf("Failed to encode preview: %v", err)
+		}
+	case <-ctx.Done():
+		http.Error(w, ctx.Err().Error(), http.StatusGatewayTimeout)
+	}
+}
@@ -11,6 +11,8 @@ type Profile struct {
 	Width      int
 	Height     int
 	FFmpegArgs []string
+	// Resample is the default resampling filter for this profile.
+	Resample string
 }
 
 // Profiles defines the possible output configurations.
@@ -45,6 +47,26 @@ var Profiles = []*Profile{
 			"-pix_fmt", "yuv420p",
 		},
 	},
+	{
+		Name:   "480p (854x480)",
+		Width:  854,
+		Height: 480,
+		FFmpegArgs: []string{
+			"-level:v", "3.1",
+			"-profile:v", "high",
+			"-pix_fmt", "yuv420p",
+		},
+	},
+	{
+		Name:   "360p (640x360)",
+		Width:  640,
+		Height: 360,
+		FFmpegArgs: []string{
+			"-level:v", "3.0",
+			"-profile:v", "high",
+			"-pix_fmt", "yuv420p",
+		},
+	},
 }
 
 func GetProfileByName(name string) (*Profile, error) {
@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"runtime"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -29,11 +30,21 @@ type Job struct {
 	ID       int
 	LogPath  string
 	Progress int
+	// Error describes why the job failed, if it did.
+	Error string
 
 	Timelapse filebrowse.ITimelapse
 
 	ImagePath     string
 	TimelapseName string
+	// OutputFile is the name of the job's output in the timelapse output path.
+	OutputFile string
+	// OutputDir is the job's output directory, relative to the root of its
+	// output location.
+	OutputDir string
+	// Preview is set for quick preview renders, which can be promoted to the
+	// full job once done.
+	Preview bool
 
 	Config Config
 
@@ -63,6 +74,7 @@ type JobQueue struct {
 	addc    chan *Job
 	cancelc chan *jobOp
 	removec chan *jobOp
+	fetchc  chan *jobFetch
 }
 
 func NewJobQueue() *JobQueue {
@@ -72,6 +84,7 @@ func NewJobQueue() *JobQueue {
 		addc:    make(chan *Job),
 		cancelc: make(chan *jobOp),
 		removec: make(chan *jobOp),
+		fetchc:  make(chan *jobFetch),
 	}
 }
 
@@ -80,6 +93,12 @@ type jobOp struct {
 	Errc chan error
 }
 
+// jobFetch requests a snapshot of a job, or nil if not found.
+type jobFetch struct {
+	ID   int
+	Jobc chan *Job
+}
+
 func (q *JobQueue) nextJob() *Job {
 	for _, j := range q.Queue {
 		if j.State == StatePending {
@@ -130,14 +149,45 @@ func (q *JobQueue) cancelJob(ID int) error {
 	return nil
 }
 
+// promotedJob returns the full job for a finished preview j, with the same
+// config. Validation reads the sequence, so is done outside the queue loop.
+func promotedJob(ctx context.Context, j *Job) (*Job, error) {
+	pc, ok := j.Config.(*baseConfig)
+	if !ok || !pc.Preview {
+		return nil, fmt.Errorf("job %v is not a preview", j.ID)
+	}
+	if j.State != StateDone {
+		return nil, fmt.Errorf("preview job %v has not finished", j.ID)
+	}
+	full := *pc
+	full.Preview = false
+	full.PreviewEvery = 0
+	if err := full.Validate(ctx, j.Timelapse); err != nil {
+		return nil, err
+	}
+	return newJob(&full, j.Timelapse), nil
+}
+
 func (q *JobQueue) maybeStartNext(ctx context.Context) {
 	if q.current != nil {
 		return // Job already running.
 	}
-	j := q.nextJob()
-	if j == nil {
-		return // No jobs remaining.
+	var j *Job
+	for {
+		if j = q.nextJob(); j == nil {
+			return // No jobs remaining.
+		}
+		// Outputs may have been written since the job was validated, e.g. by
+		// jobs queued ahead of it.
+		err := j.Config.CheckOverwrite()
+		if err == nil {
+			break
+		}
+		log.Errorf("job %v failed: %v", j.ID, err)
+		j.State = StateFailed
+		j.Error = err.Error()
 	}
+	j.OutputFile = j.Config.GetFilename()
 	// Start next job.
 	j.State = StateActive
 	j.LogPath = j.Config.GetDebugFilename()
@@ -160,6 +210,7 @@ func (q *JobQueue) markJobDone(err error) {
 	j.stop = time.Now()
 	if err != nil {
 		j.State = StateFailed
+		j.Error = err.Error()
 	} else {
 		j.State = StateDone
 		j.Progress = 100
@@ -170,24 +221,32 @@ func (q *JobQueue) markJobDone(err error) {
 	q.current = nil
 
 	log.Infof("job completed")
-
-	// Ensure we run a GC cycle before running the next job.
-	// There are probably heap fragmentation issues that are causing more problems...
-	runtime.GC()
-	log.Infof("gc complete")
 }
 
-func (q *JobQueue) AddJob(config Config, t filebrowse.ITimelapse) {
-	j := &Job{
+func newJob(config Config, t filebrowse.ITimelapse) *Job {
+	return &Job{
 		State:         StatePending,
 		Timelapse:     t,
 		ImagePath:     t.ImagePath(),
 		TimelapseName: t.TimelapseName(),
+		OutputFile:    config.GetFilename(),
+		OutputDir:     config.GetOutputDir(),
+		Preview:       config.GetConvertOptions().Preview,
 		Config:        config,
-		ID:            q.jobIDgen,
 	}
+}
+
+func (q *JobQueue) AddJob(config Config, t filebrowse.ITimelapse) {
+	q.addc <- newJob(config, t)
+}
+
+// enqueue assigns the job an ID and adds it to the queue. Must be called from
+// the queue loop.
+func (q *JobQueue) enqueue(j *Job) {
+	j.ID = q.jobIDgen
 	q.jobIDgen += 1
-	q.addc <- j
+	q.Queue = append(q.Queue, j)
+	log.Info("new job added to queue")
 }
 
 func (q *JobQueue) toJSON() *jsonResp {
@@ -217,8 +276,7 @@ func (q *JobQueue) Loop(ctx context.Context) {
 	for {
 		select {
 		case j := <-q.addc:
-			q.Queue = append(q.Queue, j)
-			log.Info("new job added to queue")
+			q.enqueue(j)
 			q.maybeStartNext(ctx)
 		case t := <-q.cancelc:
 			log.Infof("issue cancel of job %d", t.ID)
@@ -228,6 +286,13 @@ func (q *JobQueue) Loop(ctx context.Context) {
 			log.Infof("remove job %d", t.ID)
 			err := q.removeJob(t.ID)
 			t.Errc <- err
+		case f := <-q.fetchc:
+			var jc *Job
+			if j := q.getJob(f.ID); j != nil {
+				c := *j
+				jc = &c
+			}
+			f.Jobc <- jc
 		case err := <-q.jobdonec:
 			q.markJobDone(err)
 			q.maybeStartNext(ctx)
@@ -259,16 +324,29 @@ func (q *JobQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp.Result)
 }
 
-func (q *JobQueue) handlePostJobOp(w http.ResponseWriter, r *http.Request, opc chan *jobOp) {
+// postJobID returns the ID of the job a POSTed job operation applies to.
+func postJobID(r *http.Request) (int, error) {
 	if r.Method != "POST" {
-		http.Error(w, "Requires POST", http.StatusBadRequest)
-		return
+		return 0, fmt.Errorf("Requires POST")
 	}
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return 0, err
 	}
-	ID, err := strconv.Atoi(r.Form.Get("id"))
+	return strconv.Atoi(r.Form.Get("id"))
+}
+
+// fetchJob returns a snapshot of the job, or nil if not found.
+func (q *JobQueue) fetchJob(ID int) *Job {
+	f := &jobFetch{
+		ID:   ID,
+		Jobc: make(chan *Job),
+	}
+	q.fetchc <- f
+	return <-f.Jobc
+}
+
+func (q *JobQueue) handlePostJobOp(w http.ResponseWriter, r *http.Request, opc chan *jobOp) {
+	ID, err := postJobID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -293,3 +371,61 @@ func (q *JobQueue) ServeCancel(w http.ResponseWriter, r *http.Request) {
 func (q *JobQueue) ServeRemove(w http.ResponseWriter, r *http.Request) {
 	q.handlePostJobOp(w, r, q.removec)
 }
+
+func (q *JobQueue) ServePromote(w http.ResponseWriter, r *http.Request) {
+	ID, err := postJobID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j := q.fetchJob(ID)
+	if j == nil {
+		http.Error(w, fmt.Sprintf("job %v not found", ID), http.StatusNotFound)
+		return
+	}
+	full, err := promotedJob(r.Context(), j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infof("promote preview job %d", ID)
+	q.addc <- full
+}
+
+// ServePreview serves the output of a finished preview job, with support for
+// range requests so it can be played in the browser.
+func (q *JobQueue) ServePreview(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j := q.fetchJob(ID)
+	if j == nil {
+		http.Error(w, fmt.Sprintf("job %v not found", ID), http.StatusNotFound)
+		return
+	}
+	if !j.Preview {
+		http.Error(w, fmt.Sprintf("job %v is not a preview", ID), http.StatusBadRequest)
+		return
+	}
+	if j.State != StateDone {
+		http.Error(w, fmt.Sprintf("preview job %v has not finished", ID), http.StatusConflict)
+		return
+	}
+
+	p := j.Config.GetOutputFullPath(j.OutputFile)
+	file, err := os.Open(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeContent(w, r, filepath.Base(p), fi.ModTime(), file)
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPromotedJob(t *testing.T) {
+	setMinFreeSpace(t, 0)
+
+	fb, tl := writeTestSequence(t, 6, image.Pt(1920, 1080))
+	preview := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "out",
+		OutputProfileName: "1080p (1920x1080)",
+		Width:             1920,
+		Height:            1080,
+		EndFrame:          5,
+		Skip:              1,
+		Preview:           true,
+		PreviewEvery:      2,
+	}
+	if err := preview.Resolve(fb); err != nil {
+		t.Fatal(err)
+	}
+	if err := preview.Validate(context.Background(), tl); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	j := newJob(preview, tl)
+	j.ID = 3
+
+	if _, err := promotedJob(context.Background(), j); err == nil {
+		t.Errorf("promotedJob() of a pending preview succeeded")
+	}
+
+	j.State = StateDone
+	full, err := promotedJob(context.Background(), j)
+	if err != nil {
+		t.Fatalf("promotedJob() error = %v", err)
+	}
+	if full.Preview || full.State != StatePending {
+		t.Errorf("promoted job preview %v, state %v; want a pending full job", full.Preview, full.State)
+	}
+	if got := full.Config.GetExpectedFrames(); got != 6 {
+		t.Errorf("promoted job expects %d frames, want 6", got)
+	}
+	p, err := full.Config.GetOutputProfile()
+	if err != nil || p.Width != 1920 {
+		t.Errorf("promoted job profile %+v, %v; want full size", p, err)
+	}
+	if !preview.Preview || preview.GetSkip() != 2 {
+		t.Errorf("promotedJob() modified the preview config")
+	}
+
+	j.Config = full.Config
+	if _, err := promotedJob(context.Background(), j); err == nil {
+		t.Errorf("promotedJob() of a full job succeeded")
+	}
+}
+
+func TestStartRechecksOverwrite(t *testing.T) {
+	setMinFreeSpace(t, 0)
+
+	fb, tl := writeTestSequence(t, 2, image.Pt(1920, 1080))
+	config := &baseConfig{
+		Path:              tl.ImagePath(),
+		OutputName:        "out",
+		OutputProfileName: "1080p (1920x1080)",
+		Width:             1920,
+		Height:            1080,
+		EndFrame:          1,
+	}
+	if err := config.Resolve(fb); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(context.Background(), tl); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	// Written by a job queued ahead.
+	if err := ioutil.WriteFile(config.GetOutputFullPath("out.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := &JobQueue{}
+	q.enqueue(newJob(config, tl))
+	q.maybeStartNext(context.Background())
+	if j := q.Queue[0]; j.State != StateFailed || j.Error == "" {
+		t.Errorf("job state %v, error %q; want failed over the existing output", j.State, j.Error)
+	}
+	if q.current != nil {
+		t.Errorf("job started over an existing output")
+	}
+}
@@ -2,42 +2,100 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"syscall"
 
 	"timelapse-queue/filebrowse"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func rename(src, dst string) error {
-	// Avoid overwrites.
+func rename(src, dst string, overwrite bool) error {
+	// Avoid overwrites, unless requested.
 	_, err := os.Stat(dst)
-	if !os.IsNotExist(err) {
+	if !overwrite && !os.IsNotExist(err) {
 		return fmt.Errorf("Output file %s already exists", dst)
 	}
 	// Do move.
-	if err := os.Rename(src, dst); err != nil {
+	err = os.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		// The output location is on another filesystem.
+		err = moveAcross(src, dst, overwrite)
+	}
+	if err != nil {
 		return fmt.Errorf("Failed to move %s to %s: %v", src, dst, err)
 	}
 	return nil
 }
 
+// moveAcross moves src to dst by copying it into a partial output, synced
+// before it is committed, then removing src.
+func moveAcross(src, dst string, overwrite bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	part := newPartialOutput(dst, overwrite)
+	defer part.Cleanup()
+	out, err := os.OpenFile(part.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := part.Commit(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
 func ConvertRename(ctx context.Context, logger *log.Logger, config Config, timelapse filebrowse.ITimelapse, progress chan<- int) error {
 
 	start, end := config.GetStartEnd()
 	skip := config.GetSkip()
+	overwrite := config.GetConvertOptions().Overwrite
 
 	total := timelapse.ImageCount()
 
+	// Overwriting must never replace a source image not yet moved.
+	sources := make(map[string]bool)
+	if overwrite {
+		for src := range filebrowse.ImagePaths(timelapse, start, end, skip) {
+			sources[src] = true
+		}
+	}
+
 	i := 0
 	for src := range filebrowse.ImagePaths(timelapse, start, end, skip) {
 		ext := filepath.Ext(src)
-		dst := timelapse.GetOutputFullPath(fmt.Sprintf("%s%06d%s", config.GetFilename(), i, ext))
+		dst := config.GetOutputFullPath(fmt.Sprintf("%s%06d%s", config.GetFilename(), i, ext))
+		if sources[dst] && dst != src {
+			err := fmt.Errorf("Output file %s is a source image", dst)
+			logger.Errorf("FAILED: %v", err)
+			return err
+		}
 
 		logger.Infof("Rename %q to %q", src, dst)
-		if err := rename(src, dst); err != nil {
+		if err := rename(src, dst, overwrite); err != nil {
 			logger.Errorf("FAILED: %v", err)
 			return err
 		}
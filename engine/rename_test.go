@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMoveAcross(t *testing.T) {
+	useJournal(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "IMG_0001.JPG")
+	dst := filepath.Join(dir, "out000000.JPG")
+	if err := ioutil.WriteFile(src, []byte("frame"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dst)
+
+	// An existing output is only replaced when overwriting.
+	if err := moveAcross(src, dst, false); err == nil {
+		t.Errorf("moveAcross() over an existing output succeeded")
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != dst {
+		t.Errorf("moveAcross() changed the existing output to %q", b)
+	}
+	if !exists(src) {
+		t.Fatalf("failed moveAcross() removed the source")
+	}
+
+	if err := moveAcross(src, dst, true); err != nil {
+		t.Fatalf("moveAcross() error = %v", err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "frame" {
+		t.Errorf("moved file contains %q, want %q", b, "frame")
+	}
+	if fi, err := os.Stat(dst); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("moved file mode = %v (%v), want 0600", fi.Mode().Perm(), err)
+	}
+	for _, f := range []string{src, partialPath(dst)} {
+		if exists(f) {
+			t.Errorf("%v left behind by moveAcross()", f)
+		}
+	}
+	if paths := journal(t); len(paths) != 0 {
+		t.Errorf("journal = %v after moveAcross(), want empty", paths)
+	}
+}
+
+func TestRenameAcrossFilesystems(t *testing.T) {
+	useJournal(t)
+	other, err := ioutil.TempDir("/dev/shm", "rename")
+	if err != nil {
+		t.Skipf("no tmpfs: %v", err)
+	}
+	defer os.RemoveAll(other)
+
+	src := filepath.Join(t.TempDir(), "IMG_0001.JPG")
+	dst := filepath.Join(other, "out000000.JPG")
+	writeFile(t, src)
+	if err := os.Link(src, dst); !errors.Is(err, syscall.EXDEV) {
+		t.Skipf("%v is on the same filesystem as the source", other)
+	}
+
+	if err := rename(src, dst, false); err != nil {
+		t.Fatalf("rename() error = %v", err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != src {
+		t.Errorf("renamed file contains %q, want %q", b, src)
+	}
+	if exists(src) {
+		t.Errorf("rename() left the source behind")
+	}
+}
@@ -39,6 +39,11 @@ func (s *TestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := config.Resolve(s.Browser); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := config.Validate(r.Context(), t); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+
+	"timelapse-queue/filebrowse"
+	"timelapse-queue/process"
+	"timelapse-queue/util"
+
+	"github.com/davecgh/go-spew/spew"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConvertStill runs the job's processing pipeline and composites the frames
+// into a single image, written next to the sequence.
+func ConvertStill(pctx context.Context, logger *log.Logger, config Config, timelapse filebrowse.ITimelapse, progress chan<- int) error {
+	opts := config.GetConvertOptions()
+	format := getImageFormat(opts.StillFormat)
+	if format == nil {
+		return fmt.Errorf("invalid still image format %v", opts.StillFormat)
+	}
+
+	ctx, cancelf := context.WithCancel(pctx)
+	defer cancelf()
+
+	pool := util.NewFramePool()
+	defer pool.Close()
+
+	logger.Infof("Starting job: %+v", spew.Sdump(config))
+
+	imagec, imerrc, err := buildPipeline(ctx, logger, config, timelapse, pool, -1)
+	if err != nil {
+		return err
+	}
+
+	// Report progress as frames reach the compositor.
+	expected := config.GetExpectedFrames()
+	countc := make(chan *image.RGBA)
+	go func() {
+		defer close(countc)
+		i := 0
+		for img := range imagec {
+			countc <- img
+			i++
+			progress <- 100 * i / expected
+		}
+	}()
+
+	still := process.Still{
+		Mode:   opts.Still,
+		Frames: expected,
+		Pool:   pool,
+	}
+	img, err := still.Composite(ctx, countc, imerrc)
+	if err != nil {
+		// Wait for the pipeline to wind down, so no progress is reported after
+		// returning.
+		cancelf()
+		for img := range countc {
+			pool.Put(img)
+		}
+		log.Errorf("Failed to composite %v image: %v", opts.Still, err)
+		logger.Errorf("Failed to composite %v image: %v", opts.Still, err)
+		return err
+	}
+	defer pool.Put(img)
+
+	part := newPartialOutput(config.GetOutputFullPath(config.GetFilename()), opts.Overwrite)
+	defer part.Cleanup()
+	logger.Infof("Writing %v %v image to %v", img.Rect.Size(), opts.Still, part.Final)
+	f, err := os.Create(part.Path)
+	if err != nil {
+		return err
+	}
+	if err := format.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode %v: %v", format.Name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := commitWithSidecar(part, config, timelapse, part.Commit); err != nil {
+		return err
+	}
+	log.Info("Conversion succeeded.")
+	return nil
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"timelapse-queue/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outputExpectation describes an encoded output, to be checked by
+// verifyOutput.
+type outputExpectation struct {
+	Codec         string
+	Frames        int
+	Width, Height int
+	FPS           int
+	// Tolerance is extra slack on the frame count, e.g. for interpolation.
+	Tolerance int
+}
+
+// probeResult is the subset of ffprobe JSON output used for verification.
+type probeResult struct {
+	Streams []struct {
+		CodecName     string `json:"codec_name"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		NbReadPackets string `json:"nb_read_packets"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// probeOutput runs ffprobe on the first video stream of path.
+func probeOutput(ctx context.Context, ffprobe, path string) (*probeResult, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		// Counting packets reads the whole file without decoding it, which is
+		// enough to spot truncation.
+		"-count_packets",
+		"-show_entries", "stream=codec_name,width,height,nb_read_packets:format=duration",
+		"-of", "json",
+		path,
+	}
+	out, err := exec.CommandContext(ctx, ffprobe, args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed: %v: %s", err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	r := &probeResult{}
+	if err := json.Unmarshal(out, r); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+	if len(r.Streams) == 0 {
+		return nil, fmt.Errorf("no video stream in output")
+	}
+	return r, nil
+}
+
+// frameTolerance is the allowed difference between expected and encoded frame
+// counts, to absorb rounding in frame rate conversion.
+func frameTolerance(frames int) int {
+	if t := frames / 100; t > 1 {
+		return t
+	}
+	return 1
+}
+
+// check compares the probe result against want, returning all mismatches.
+func (r *probeResult) check(want outputExpectation) []string {
+	var bad []string
+	s := r.Streams[0]
+	if s.CodecName != want.Codec {
+		bad = append(bad, fmt.Sprintf("codec %q, expected %q", s.CodecName, want.Codec))
+	}
+	if s.Width != want.Width || s.Height != want.Height {
+		bad = append(bad, fmt.Sprintf("resolution %dx%d, expected %dx%d", s.Width, s.Height, want.Width, want.Height))
+	}
+	tol := frameTolerance(want.Frames) + want.Tolerance
+	frames, err := strconv.Atoi(s.NbReadPackets)
+	if err != nil {
+		bad = append(bad, fmt.Sprintf("unknown frame count %q", s.NbReadPackets))
+	} else if d := frames - want.Frames; d > tol || d < -tol {
+		bad = append(bad, fmt.Sprintf("%d frames, expected %d", frames, want.Frames))
+	}
+	dur, err := strconv.ParseFloat(r.Format.Duration, 64)
+	wantDur := float64(want.Frames) / float64(want.FPS)
+	if err != nil {
+		bad = append(bad, fmt.Sprintf("unknown duration %q", r.Format.Duration))
+	} else if math.Abs(dur-wantDur) > float64(tol+1)/float64(want.FPS) {
+		bad = append(bad, fmt.Sprintf("duration %.2fs, expected %.2fs", dur, wantDur))
+	}
+	return bad
+}
+
+// verifyOutput checks the encoded output at path with ffprobe, since FFmpeg
+// can exit cleanly after writing a truncated file, e.g. on a full disk.
+func verifyOutput(ctx context.Context, logger *log.Logger, path string, want outputExpectation) error {
+	ffprobe, err := util.LocateFFprobe()
+	if err != nil {
+		return fmt.Errorf("unable to locate ffprobe to verify output: %v", err)
+	}
+	r, err := probeOutput(ctx, ffprobe, path)
+	if err != nil {
+		return fmt.Errorf("output verification failed: %v", err)
+	}
+	logger.Infof("Verifying output %v: %+v, expected %+v", path, r, want)
+	if bad := r.check(want); len(bad) > 0 {
+		return fmt.Errorf("output verification failed: %s", strings.Join(bad, "; "))
+	}
+	logger.Infof("Output verified")
+	return nil
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFrameTolerance(t *testing.T) {
+	for _, tc := range []struct{ frames, want int }{
+		{0, 1},
+		{150, 1},
+		{250, 2},
+		{3000, 30},
+	} {
+		if got := frameTolerance(tc.frames); got != tc.want {
+			t.Errorf("frameTolerance(%d) = %d, want %d", tc.frames, got, tc.want)
+		}
+	}
+}
+
+func TestProbeResultCheck(t *testing.T) {
+	want := outputExpectation{
+		Codec:  "h264",
+		Frames: 300,
+		Width:  1920,
+		Height: 1080,
+		FPS:    30,
+	}
+	probe := func(codec string, w, h int, packets, duration string) *probeResult {
+		js, err := json.Marshal(map[string]interface{}{
+			"streams": []map[string]interface{}{{
+				"codec_name":      codec,
+				"width":           w,
+				"height":          h,
+				"nb_read_packets": packets,
+			}},
+			"format": map[string]string{"duration": duration},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &probeResult{}
+		if err := json.Unmarshal(js, r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	for _, tc := range []struct {
+		name string
+		r    *probeResult
+		want outputExpectation
+		// bad lists a substring of each expected mismatch, in order.
+		bad []string
+	}{
+		{
+			name: "match",
+			r:    probe("h264", 1920, 1080, "300", "10.000000"),
+			want: want,
+		},
+		{
+			name: "within tolerance",
+			r:    probe("h264", 1920, 1080, "303", "10.100000"),
+			want: want,
+		},
+		{
+			name: "codec",
+			r:    probe("hevc", 1920, 1080, "300", "10.000000"),
+			want: want,
+			bad:  []string{`codec "hevc"`},
+		},
+		{
+			name: "resolution",
+			r:    probe("h264", 1280, 720, "300", "10.000000"),
+			want: want,
+			bad:  []string{"resolution 1280x720"},
+		},
+		{
+			name: "truncated",
+			r:    probe("h264", 1920, 1080, "120", "4.000000"),
+			want: want,
+			bad:  []string{"120 frames", "duration 4.00s"},
+		},
+		{
+			name: "duration only",
+			r:    probe("h264", 1920, 1080, "300", "20.000000"),
+			want: want,
+			bad:  []string{"duration 20.00s"},
+		},
+		{
+			name: "unknown counts",
+			r:    probe("h264", 1920, 1080, "N/A", "N/A"),
+			want: want,
+			bad:  []string{`unknown frame count "N/A"`, `unknown duration "N/A"`},
+		},
+		{
+			name: "interpolation tolerance",
+			r:    probe("h264", 1920, 1080, "296", "9.866667"),
+			want: func() outputExpectation { w := want; w.Tolerance = 4; return w }(),
+		},
+		{
+			name: "everything",
+			r:    probe("gif", 640, 360, "10", "1.000000"),
+			want: want,
+			bad:  []string{"codec", "resolution", "frames", "duration"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.r.check(tc.want)
+			if len(got) != len(tc.bad) {
+				t.Fatalf("check() = %q, want %d mismatches", got, len(tc.bad))
+			}
+			var missing []string
+			for i, b := range tc.bad {
+				if !strings.Contains(got[i], b) {
+					missing = append(missing, b)
+				}
+			}
+			if diff := cmp.Diff([]string(nil), missing); diff != "" {
+				t.Errorf("check() = %q, missing mismatches (-want +got):\n%s", got, diff)
+			}
+		})
+	}
+}
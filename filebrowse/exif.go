@@ -0,0 +1,372 @@
+package filebrowse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	tagImageWidth    = 0x0100
+	tagImageLength   = 0x0101
+	tagOrientation   = 0x0112
+	tagPixelXDim     = 0xA002
+	tagPixelYDim     = 0xA003
+	tagExifIFD       = 0x8769
+	tagGPSIFD        = 0x8825
+	tagDateTime      = 0x0132
+	tagDateTimeOrig  = 0x9003
+	tagOffsetTimeOri = 0x9011
+	tagSubSec        = 0x9290
+	tagSubSecOrig    = 0x9291
+
+	tagGPSLatRef = 1
+	tagGPSLat    = 2
+	tagGPSLonRef = 3
+	tagGPSLon    = 4
+	tagGPSAltRef = 5
+	tagGPSAlt    = 6
+
+	exifTimeLayout = "2006:01:02 15:04:05"
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+
+	// ErrNoExif is returned when an image has no EXIF metadata.
+	ErrNoExif = errors.New("no EXIF metadata")
+)
+
+// Exif holds the capture metadata of interest from an image's EXIF block.
+type Exif struct {
+	// Time is the capture time, or zero if not recorded. Cameras rarely record
+	// a timezone, in which case local time is assumed.
+	Time time.Time
+
+	// Location, if HasGPS is set. Altitude is in meters above sea level.
+	HasGPS                        bool
+	Latitude, Longitude, Altitude float64
+}
+
+// ReadExifSegment returns the raw EXIF APP1 segment payload of a JPEG,
+// including the "Exif" header, or ErrNoExif.
+func ReadExifSegment(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var marker [2]byte
+	if _, err := io.ReadFull(br, marker[:]); err != nil {
+		return nil, err
+	}
+	if marker != [2]byte{0xFF, 0xD8} {
+		return nil, fmt.Errorf("not a JPEG")
+	}
+	for {
+		if _, err := io.ReadFull(br, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker %x", marker)
+		}
+		// Start of scan or end of image: metadata segments must come first.
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, ErrNoExif
+		}
+		var l uint16
+		if err := binary.Read(br, binary.BigEndian, &l); err != nil {
+			return nil, err
+		}
+		if l < 2 {
+			return nil, fmt.Errorf("invalid JPEG segment length")
+		}
+		seg := make([]byte, l-2)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(seg, exifHeader) {
+			return seg, nil
+		}
+	}
+}
+
+// WriteExifSegment writes the encoded JPEG data to w, with seg as returned by
+// ReadExifSegment inserted as an APP1 segment following the start of image.
+func WriteExifSegment(w io.Writer, data, seg []byte) error {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return fmt.Errorf("not a JPEG")
+	}
+	if len(seg)+2 > 0xFFFF {
+		return fmt.Errorf("EXIF segment too large")
+	}
+	var hdr [6]byte
+	copy(hdr[:], data[:2])
+	hdr[2], hdr[3] = 0xFF, 0xE1
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(seg)+2))
+	for _, b := range [][]byte{hdr[:], seg, data[2:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tiffReader decodes IFD entries from a TIFF structure.
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+var tiffTypeSize = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8,
+}
+
+func (t *tiffReader) ifd(off uint32) (map[uint16]*ifdEntry, error) {
+	if int(off)+2 > len(t.b) {
+		return nil, fmt.Errorf("IFD offset out of range")
+	}
+	n := int(t.order.Uint16(t.b[off:]))
+	entries := make(map[uint16]*ifdEntry)
+	for i := 0; i < n; i++ {
+		p := int(off) + 2 + i*12
+		if p+12 > len(t.b) {
+			return nil, fmt.Errorf("IFD entry out of range")
+		}
+		e := &ifdEntry{
+			typ:   t.order.Uint16(t.b[p+2:]),
+			count: t.order.Uint32(t.b[p+4:]),
+		}
+		sz, ok := tiffTypeSize[e.typ]
+		if !ok {
+			continue
+		}
+		// Computed in 64 bits, as corrupt counts overflow the product.
+		total := uint64(sz) * uint64(e.count)
+		if total <= 4 {
+			e.value = t.b[p+8 : p+8+int(total)]
+		} else {
+			voff := uint64(t.order.Uint32(t.b[p+8:]))
+			if voff+total > uint64(len(t.b)) {
+				continue
+			}
+			e.value = t.b[voff : voff+total]
+		}
+		entries[t.order.Uint16(t.b[p:])] = e
+	}
+	return entries, nil
+}
+
+func (t *tiffReader) str(e *ifdEntry) string {
+	if e == nil || e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(e.value), "\x00 ")
+}
+
+func (t *tiffReader) long(e *ifdEntry) (uint32, bool) {
+	if e == nil || e.count < 1 {
+		return 0, false
+	}
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+func (t *tiffReader) rationals(e *ifdEntry) []float64 {
+	if e == nil || e.typ != 5 || uint64(len(e.value)) < 8*uint64(e.count) {
+		return nil
+	}
+	var out []float64
+	for i := uint32(0); i < e.count; i++ {
+		num := t.order.Uint32(e.value[i*8:])
+		den := t.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return nil
+		}
+		out = append(out, float64(num)/float64(den))
+	}
+	return out
+}
+
+func (t *tiffReader) degrees(e *ifdEntry, ref string, negRef string) (float64, bool) {
+	v := t.rationals(e)
+	if len(v) != 3 {
+		return 0, false
+	}
+	d := v[0] + v[1]/60 + v[2]/3600
+	if ref == negRef {
+		d = -d
+	}
+	return d, true
+}
+
+// setLongs overwrites the values of count 1 SHORT and LONG entries of the IFD
+// at off, which are stored inline. Other entries are left unchanged.
+func (t *tiffReader) setLongs(off uint32, values map[uint16]uint32) {
+	if uint64(off)+2 > uint64(len(t.b)) {
+		return
+	}
+	n := int(t.order.Uint16(t.b[off:]))
+	for i := 0; i < n; i++ {
+		p := int(off) + 2 + i*12
+		if p+12 > len(t.b) {
+			return
+		}
+		v, ok := values[t.order.Uint16(t.b[p:])]
+		if !ok || t.order.Uint32(t.b[p+4:]) != 1 {
+			continue
+		}
+		switch t.order.Uint16(t.b[p+2:]) {
+		case 3:
+			t.order.PutUint16(t.b[p+8:], uint16(v))
+		case 4:
+			t.order.PutUint32(t.b[p+8:], v)
+		}
+	}
+}
+
+// newTiffReader reads the TIFF header of an EXIF APP1 segment payload.
+func newTiffReader(seg []byte) (*tiffReader, error) {
+	if !bytes.HasPrefix(seg, exifHeader) {
+		return nil, ErrNoExif
+	}
+	b := seg[len(exifHeader):]
+	if len(b) < 8 {
+		return nil, fmt.Errorf("truncated EXIF")
+	}
+	t := &tiffReader{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order")
+	}
+	return t, nil
+}
+
+// RewriteExifSegment returns a copy of an EXIF APP1 segment payload adjusted to
+// describe a processed image of the given size: upright, with the new pixel
+// dimensions, and without the thumbnail, which shows the original framing.
+func RewriteExifSegment(seg []byte, size image.Point) ([]byte, error) {
+	out := append([]byte(nil), seg...)
+	t, err := newTiffReader(out)
+	if err != nil {
+		return nil, err
+	}
+	off := t.order.Uint32(t.b[4:])
+	ifd0, err := t.ifd(off)
+	if err != nil {
+		return nil, err
+	}
+	w, h := uint32(size.X), uint32(size.Y)
+	t.setLongs(off, map[uint16]uint32{
+		tagOrientation: 1,
+		tagImageWidth:  w,
+		tagImageLength: h,
+	})
+	// The thumbnail is IFD1, linked from the end of IFD0.
+	next := uint64(off) + 2 + 12*uint64(t.order.Uint16(t.b[off:]))
+	if next+4 <= uint64(len(t.b)) {
+		t.order.PutUint32(t.b[next:], 0)
+	}
+	if exifOff, ok := t.long(ifd0[tagExifIFD]); ok {
+		t.setLongs(exifOff, map[uint16]uint32{
+			tagPixelXDim: w,
+			tagPixelYDim: h,
+		})
+	}
+	return out, nil
+}
+
+// ParseExif decodes an EXIF APP1 segment payload.
+func ParseExif(seg []byte) (*Exif, error) {
+	t, err := newTiffReader(seg)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := t.ifd(t.order.Uint32(t.b[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Exif{}
+	// Fractional seconds are held in separate tags of the Exif IFD, one for
+	// each time.
+	ts, frac := t.str(ifd0[tagDateTime]), ""
+	loc := time.Local
+	if off, ok := t.long(ifd0[tagExifIFD]); ok {
+		if sub, err := t.ifd(off); err == nil {
+			frac = t.str(sub[tagSubSec])
+			if s := t.str(sub[tagDateTimeOrig]); s != "" {
+				ts, frac = s, t.str(sub[tagSubSecOrig])
+			}
+			if o := t.str(sub[tagOffsetTimeOri]); o != "" {
+				if z, err := time.Parse("-07:00", o); err == nil {
+					_, secs := z.Zone()
+					loc = time.FixedZone(o, secs)
+				}
+			}
+		}
+	}
+	if ts != "" {
+		if frac != "" && strings.Trim(frac, "0123456789") == "" {
+			ts += "." + frac
+		}
+		if tm, err := time.ParseInLocation(exifTimeLayout, ts, loc); err == nil {
+			x.Time = tm
+		}
+	}
+
+	if off, ok := t.long(ifd0[tagGPSIFD]); ok {
+		if gps, err := t.ifd(off); err == nil {
+			lat, okLat := t.degrees(gps[tagGPSLat], t.str(gps[tagGPSLatRef]), "S")
+			lon, okLon := t.degrees(gps[tagGPSLon], t.str(gps[tagGPSLonRef]), "W")
+			if okLat && okLon {
+				x.HasGPS = true
+				x.Latitude, x.Longitude = lat, lon
+				if alt := t.rationals(gps[tagGPSAlt]); len(alt) == 1 {
+					x.Altitude = alt[0]
+					if e := gps[tagGPSAltRef]; e != nil && len(e.value) > 0 && e.value[0] == 1 {
+						x.Altitude = -x.Altitude
+					}
+				}
+			}
+		}
+	}
+	return x, nil
+}
+
+// ReadExif reads the EXIF metadata of the JPEG at path.
+func ReadExif(path string) (*Exif, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	seg, err := ReadExifSegment(f)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExif(seg)
+}
+
+// FrameExif reads the EXIF metadata of the frame at the given index.
+func FrameExif(t ITimelapse, idx int) (*Exif, error) {
+	return ReadExif(t.GetPathForIndex(idx))
+}
@@ -0,0 +1,302 @@
+package filebrowse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+// buildIFD encodes entries at offset off, placing out-of-line values after the
+// IFD. Returns the encoded bytes.
+func buildIFD(off uint32, entries []testEntry) []byte {
+	be := binary.BigEndian
+	head := new(bytes.Buffer)
+	extra := new(bytes.Buffer)
+	extraOff := off + 2 + uint32(len(entries))*12 + 4
+	binary.Write(head, be, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(head, be, e.tag)
+		binary.Write(head, be, e.typ)
+		binary.Write(head, be, e.count)
+		if len(e.data) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.data)
+			head.Write(v)
+		} else {
+			binary.Write(head, be, extraOff+uint32(extra.Len()))
+			extra.Write(e.data)
+		}
+	}
+	binary.Write(head, be, uint32(0)) // No next IFD.
+	return append(head.Bytes(), extra.Bytes()...)
+}
+
+func rationals(vs ...uint32) []byte {
+	b := make([]byte, 0, len(vs)*4)
+	for _, v := range vs {
+		b = append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func long(v uint32) []byte {
+	return rationals(v)
+}
+
+// testExifSegment returns an EXIF segment with capture time and GPS location.
+func testExifSegment() []byte {
+	dt := []byte("2021:06:05 14:30:15\x00")
+	off := []byte("-07:00\x00")
+
+	// Layout: header (8), IFD0 at 8, Exif IFD at 100, GPS IFD at 200.
+	ifd0 := buildIFD(8, []testEntry{
+		{tagExifIFD, 4, 1, long(100)},
+		{tagGPSIFD, 4, 1, long(200)},
+	})
+	exifIFD := buildIFD(100, []testEntry{
+		{tagDateTimeOrig, 2, uint32(len(dt)), dt},
+		{tagOffsetTimeOri, 2, uint32(len(off)), off},
+	})
+	gps := buildIFD(200, []testEntry{
+		{tagGPSLatRef, 2, 2, []byte("N\x00")},
+		{tagGPSLat, 5, 3, rationals(47, 1, 30, 1, 0, 1)},
+		{tagGPSLonRef, 2, 2, []byte("W\x00")},
+		{tagGPSLon, 5, 3, rationals(122, 1, 15, 1, 36, 1)},
+		{tagGPSAlt, 5, 1, rationals(1500, 10)},
+	})
+
+	tiff := make([]byte, 512)
+	copy(tiff, []byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+	copy(tiff[8:], ifd0)
+	copy(tiff[100:], exifIFD)
+	copy(tiff[200:], gps)
+	return append(append([]byte(nil), exifHeader...), tiff...)
+}
+
+func TestParseExif(t *testing.T) {
+	x, err := ParseExif(testExifSegment())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := time.Date(2021, 6, 5, 21, 30, 15, 0, time.UTC)
+	if !x.Time.Equal(want) {
+		t.Errorf("got time %v, want %v", x.Time, want)
+	}
+	if !x.HasGPS {
+		t.Fatalf("expected GPS location")
+	}
+	if math.Abs(x.Latitude-47.5) > 1e-9 || math.Abs(x.Longitude+122.26) > 1e-9 || x.Altitude != 150 {
+		t.Errorf("got location %v, %v, %v", x.Latitude, x.Longitude, x.Altitude)
+	}
+}
+
+func TestParseExifMalformed(t *testing.T) {
+	segment := func(ifds ...[]byte) []byte {
+		seg := append(append([]byte(nil), exifHeader...), 'M', 'M', 0, 42, 0, 0, 0, 8)
+		for _, ifd := range ifds {
+			seg = append(seg, ifd...)
+		}
+		return seg
+	}
+	// A one entry IFD0 at 8 is 18 bytes, so a following IFD starts at 26.
+	gpsAt := func(entries ...testEntry) []byte {
+		return segment(buildIFD(8, []testEntry{{tagGPSIFD, 4, 1, long(26)}}), buildIFD(26, entries))
+	}
+	tests := []struct {
+		name string
+		seg  []byte
+	}{
+		{"truncated", append(append([]byte(nil), exifHeader...), 'M', 'M', 0)},
+		{"IFD past end", append(append([]byte(nil), exifHeader...), 'M', 'M', 0, 42, 0, 0, 1, 0)},
+		{"entries past end", segment([]byte{0, 9, 0, 1})},
+		// 8 * 0x20000000 wraps to zero in 32 bits.
+		{"overflowing rational count", gpsAt(
+			testEntry{tagGPSLatRef, 2, 2, []byte("N\x00")},
+			testEntry{tagGPSLat, 5, 0x20000000, long(0)},
+		)},
+		{"huge counts", segment(buildIFD(8, []testEntry{
+			{tagExifIFD, 4, 0xFFFFFFFF, long(8)},
+			{tagDateTime, 2, 0xFFFFFFFF, long(8)},
+		}))},
+		{"empty pointer", segment(buildIFD(8, []testEntry{
+			{tagExifIFD, 3, 0, nil},
+		}))},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Errors are expected; panics are not.
+			if x, err := ParseExif(tc.seg); err == nil && x.HasGPS {
+				t.Errorf("ParseExif() = %+v, want no GPS location", x)
+			}
+		})
+	}
+
+	// Corrupt each byte of a valid segment in turn.
+	seg := testExifSegment()
+	for i := len(exifHeader); i < len(seg); i++ {
+		for _, v := range []byte{0x00, 0x20, 0x7F, 0xFF} {
+			b := append([]byte(nil), seg...)
+			b[i] = v
+			ParseExif(b)
+		}
+	}
+}
+
+func TestRewriteExifSegment(t *testing.T) {
+	// Layout: header (8), IFD0 at 8 linking IFD1 at 200, Exif IFD at 100.
+	ifd0 := buildIFD(8, []testEntry{
+		{tagOrientation, 3, 1, []byte{0, 6}},
+		{tagExifIFD, 4, 1, long(100)},
+		{tagDateTime, 2, 4, []byte("abc\x00")},
+	})
+	binary.BigEndian.PutUint32(ifd0[len(ifd0)-4:], 200)
+	exifIFD := buildIFD(100, []testEntry{
+		{tagPixelXDim, 4, 1, long(4000)},
+		{tagPixelYDim, 3, 1, []byte{0x0B, 0xB8}},
+	})
+	tiff := make([]byte, 256)
+	copy(tiff, []byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+	copy(tiff[8:], ifd0)
+	copy(tiff[100:], exifIFD)
+	copy(tiff[200:], buildIFD(200, []testEntry{{tagImageWidth, 4, 1, long(160)}}))
+	seg := append(append([]byte(nil), exifHeader...), tiff...)
+	orig := append([]byte(nil), seg...)
+
+	out, err := RewriteExifSegment(seg, image.Pt(1920, 1080))
+	if err != nil {
+		t.Fatalf("RewriteExifSegment() error = %v", err)
+	}
+	if !bytes.Equal(seg, orig) {
+		t.Errorf("RewriteExifSegment() modified its input")
+	}
+	r, err := newTiffReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got0, err := r.ifd(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := r.long(got0[tagOrientation]); v != 1 {
+		t.Errorf("orientation = %d, want 1", v)
+	}
+	if s := r.str(got0[tagDateTime]); s != "abc" {
+		t.Errorf("date = %q, want unchanged", s)
+	}
+	if next := binary.BigEndian.Uint32(r.b[8+2+3*12:]); next != 0 {
+		t.Errorf("IFD1 offset = %d, want 0", next)
+	}
+	gotExif, err := r.ifd(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := r.long(gotExif[tagPixelXDim])
+	y, _ := r.long(gotExif[tagPixelYDim])
+	if x != 1920 || y != 1080 {
+		t.Errorf("pixel dimensions = %d x %d, want 1920 x 1080", x, y)
+	}
+
+	if _, err := RewriteExifSegment([]byte("JFIF"), image.Pt(1, 1)); err != ErrNoExif {
+		t.Errorf("RewriteExifSegment() of non-EXIF error = %v, want ErrNoExif", err)
+	}
+}
+
+func TestReadExifSegment(t *testing.T) {
+	jpg := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 4, 1, 2, 0xFF, 0xE1, 0, 8}
+	jpg = append(jpg, exifHeader...)
+	seg, err := ReadExifSegment(bytes.NewReader(jpg))
+	if err != nil || !bytes.Equal(seg, exifHeader) {
+		t.Errorf("got %q, %v", seg, err)
+	}
+
+	noExif := []byte{0xFF, 0xD8, 0xFF, 0xDA}
+	if _, err := ReadExifSegment(bytes.NewReader(noExif)); err != ErrNoExif {
+		t.Errorf("got %v, want ErrNoExif", err)
+	}
+}
+
+func TestWriteExifSegment(t *testing.T) {
+	jpg := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 4, 1, 2, 0xFF, 0xDA}
+	seg := append(append([]byte{}, exifHeader...), 1, 2, 3)
+	out := new(bytes.Buffer)
+	if err := WriteExifSegment(out, jpg, seg); err != nil {
+		t.Fatalf("WriteExifSegment() error = %v", err)
+	}
+	got, err := ReadExifSegment(bytes.NewReader(out.Bytes()))
+	if err != nil || !bytes.Equal(got, seg) {
+		t.Errorf("read back %q, %v; want %q", got, err, seg)
+	}
+	if !bytes.HasSuffix(out.Bytes(), jpg[2:]) {
+		t.Errorf("original segments not preserved: %x", out.Bytes())
+	}
+
+	if err := WriteExifSegment(new(bytes.Buffer), []byte{0x89, 'P'}, seg); err == nil {
+		t.Errorf("WriteExifSegment() of non-JPEG succeeded")
+	}
+}
+
+func TestParseExifSubSec(t *testing.T) {
+	dt := func(s string) testEntry { return testEntry{tagDateTime, 2, uint32(len(s) + 1), []byte(s + "\x00")} }
+	str := func(tag uint16, s string) testEntry { return testEntry{tag, 2, uint32(len(s) + 1), []byte(s + "\x00")} }
+	// segment builds an EXIF segment with IFD0 at 8 and the Exif IFD at 100.
+	segment := func(ifd0 []testEntry, exif []testEntry) []byte {
+		ifd0 = append(ifd0, testEntry{tagExifIFD, 4, 1, long(100)})
+		tiff := make([]byte, 256)
+		copy(tiff, []byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+		copy(tiff[8:], buildIFD(8, ifd0))
+		copy(tiff[100:], buildIFD(100, exif))
+		return append(append([]byte(nil), exifHeader...), tiff...)
+	}
+	utc := []testEntry{str(tagOffsetTimeOri, "+00:00")}
+	tests := []struct {
+		name string
+		seg  []byte
+		want time.Time
+	}{
+		{
+			name: "original with sub-second",
+			seg:  segment(nil, append(utc, str(tagDateTimeOrig, "2021:06:05 14:30:15"), str(tagSubSecOrig, "25"))),
+			want: time.Date(2021, 6, 5, 14, 30, 15, 250000000, time.UTC),
+		},
+		{
+			name: "modification time with sub-second",
+			seg:  segment([]testEntry{dt("2021:06:05 14:30:16")}, append(utc, str(tagSubSec, "5"))),
+			want: time.Date(2021, 6, 5, 14, 30, 16, 500000000, time.UTC),
+		},
+		{
+			name: "original ignores the modification sub-second",
+			seg: segment([]testEntry{dt("2021:06:05 14:30:16")},
+				append(utc, str(tagDateTimeOrig, "2021:06:05 14:30:15"), str(tagSubSec, "5"))),
+			want: time.Date(2021, 6, 5, 14, 30, 15, 0, time.UTC),
+		},
+		{
+			name: "padded sub-second",
+			seg:  segment(nil, append(utc, str(tagDateTimeOrig, "2021:06:05 14:30:15"), str(tagSubSecOrig, "075 "))),
+			want: time.Date(2021, 6, 5, 14, 30, 15, 75000000, time.UTC),
+		},
+		{
+			name: "malformed sub-second",
+			seg:  segment(nil, append(utc, str(tagDateTimeOrig, "2021:06:05 14:30:15"), str(tagSubSecOrig, "x1"))),
+			want: time.Date(2021, 6, 5, 14, 30, 15, 0, time.UTC),
+		},
+	}
+	for _, tc := range tests {
+		x, err := ParseExif(tc.seg)
+		if err != nil {
+			t.Errorf("%s: ParseExif() error = %v", tc.name, err)
+			continue
+		}
+		if !x.Time.Equal(tc.want) {
+			t.Errorf("%s: time = %v, want %v", tc.name, x.Time, tc.want)
+		}
+	}
+}
@@ -30,11 +30,17 @@ var (
 	excludeRE = regexp.MustCompile(`^\..*`)
 
 	allowedEXT = []string{"jpg", "jpeg"}
+
+	// Other files listed for use as job inputs (e.g. color LUTs, fonts,
+	// watermarks and audio tracks).
+	fileEXT = []string{".cube", ".ttf", ".otf", ".png", ".mp3", ".m4a", ".aac", ".wav", ".flac", ".ogg"}
 )
 
 type FileBrowser struct {
 	// Root is the base of the file system to serve up
 	Root string
+	// OutputRoot is an optional separate, writable base for job outputs.
+	OutputRoot string
 
 	// listCache is the cache used for (possibly expensive) file list operations
 	listCache *cache.Cache
@@ -52,9 +58,14 @@ type Directory struct {
 	Name string
 	Path string
 }
+type File struct {
+	Name string
+	Path string
+}
 type Response struct {
 	Parents    []*Directory
 	Dirs       []*Directory
+	Files      []*File
 	Timelapses []*Timelapse
 }
 
@@ -63,7 +74,17 @@ func (f *FileBrowser) GetFullPath(p string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	b, err := filepath.EvalSymlinks(filepath.Join(root, p))
+	return confine(f.Root, filepath.Join(root, p))
+}
+
+// confine resolves symlinks in the absolute path p, returning an error if the
+// result is outside of root.
+func confine(root, p string) (string, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	b, err := filepath.EvalSymlinks(p)
 	if err != nil {
 		return "", err
 	}
@@ -73,6 +94,16 @@ func (f *FileBrowser) GetFullPath(p string) (string, error) {
 	return b, nil
 }
 
+func isListedFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, valid := range fileEXT {
+		if ext == valid {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *FileBrowser) getSingleTimelapse(p string) (*Timelapse, error) {
 	dir, name := path.Split(p)
 
@@ -172,6 +203,13 @@ func (f *FileBrowser) listPath(p string) (*Response, error) {
 			r.Dirs = append(r.Dirs, d)
 			continue
 		}
+		if isListedFile(finfo.Name()) {
+			r.Files = append(r.Files, &File{
+				Name: finfo.Name(),
+				Path: rel,
+			})
+			continue
+		}
 		ms := timelapseRE.FindStringSubmatch(finfo.Name())
 		if ms == nil || len(ms) != 4 {
 			continue
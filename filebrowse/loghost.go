@@ -10,16 +10,17 @@ type LogHost struct {
 	Browser *FileBrowser
 }
 
-func (h *LogHost) writeLog(path, name string, w http.ResponseWriter) error {
-	t, err := h.Browser.GetTimelapse(path)
+func (h *LogHost) writeLog(path, loc, dir, name string, w http.ResponseWriter) error {
+	p, err := h.Browser.GetOutputFile(path, loc, dir, name)
 	if err != nil {
 		return err
 	}
 
-	txt, err := os.Open(t.GetOutputFullPath(name))
+	txt, err := os.Open(p)
 	if err != nil {
 		return err
 	}
+	defer txt.Close()
 
 	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 	_, err = io.Copy(w, txt)
@@ -37,7 +38,7 @@ func (h *LogHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	path := r.Form.Get("path")
 	name := r.Form.Get("name")
-	if err := h.writeLog(path, name, w); err != nil {
+	if err := h.writeLog(path, r.Form.Get("loc"), r.Form.Get("dir"), name, w); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
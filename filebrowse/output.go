@@ -0,0 +1,97 @@
+package filebrowse
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Output locations, selecting the root job outputs are written within.
+const (
+	// OutputSource writes next to the source images.
+	OutputSource = "source"
+	// OutputBrowse writes to a directory within the browse root.
+	OutputBrowse = "browse"
+	// OutputSeparate writes to a directory within the separate output root.
+	OutputSeparate = "output"
+)
+
+// outputRoot returns the root of the output location loc.
+func (f *FileBrowser) outputRoot(loc string) (string, error) {
+	switch loc {
+	case "", OutputSource, OutputBrowse:
+		return f.Root, nil
+	case OutputSeparate:
+		if f.OutputRoot == "" {
+			return "", errors.New("no separate output root configured")
+		}
+		return f.OutputRoot, nil
+	}
+	return "", fmt.Errorf("invalid output location %v", loc)
+}
+
+// GetOutputDir returns the absolute directory outputs of t are written to, for
+// output location loc and dir relative to its root. Outputs next to the source
+// ignore dir. The directory need not exist yet.
+func (f *FileBrowser) GetOutputDir(t ITimelapse, loc, dir string) (string, error) {
+	root, err := f.outputRoot(loc)
+	if err != nil {
+		return "", err
+	}
+	if loc == "" || loc == OutputSource {
+		return t.GetOutputFullPath(""), nil
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	p, err := resolveMissing(filepath.Join(root, dir))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errors.New("permission denied, output directory not in root")
+	}
+	return p, nil
+}
+
+// resolveMissing resolves symlinks in the absolute path p, which need not
+// exist, by resolving its deepest existing ancestor.
+func resolveMissing(p string) (string, error) {
+	var rest []string
+	for {
+		b, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{b}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// GetOutputFile returns the absolute path of the existing output name of the
+// timelapse at path, written to output location loc and dir.
+func (f *FileBrowser) GetOutputFile(path, loc, dir, name string) (string, error) {
+	t, err := f.GetTimelapse(path)
+	if err != nil {
+		return "", err
+	}
+	d, err := f.GetOutputDir(t, loc, dir)
+	if err != nil {
+		return "", err
+	}
+	root, err := f.outputRoot(loc)
+	if err != nil {
+		return "", err
+	}
+	return confine(root, filepath.Join(d, name))
+}
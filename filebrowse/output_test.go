@@ -0,0 +1,69 @@
+package filebrowse
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetOutputDir(t *testing.T) {
+	data, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"browse/renders", "out/existing", "elsewhere"} {
+		if err := os.MkdirAll(filepath.Join(data, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Symlinks within the roots may point out of them.
+	for link, target := range map[string]string{
+		"browse/escape":     "../elsewhere",
+		"out/escape":        filepath.Join(data, "elsewhere"),
+		"out/inside":        "existing",
+		"browse/renders/up": "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(data, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &FileBrowser{Root: filepath.Join(data, "browse"), OutputRoot: filepath.Join(data, "out")}
+	tl := &Timelapse{Path: "2021/day1/G0021015.JPG", browser: f}
+	tests := []struct {
+		loc, dir string
+		want     string
+		wantErr  bool
+	}{
+		{loc: "", want: "browse/2021/day1"},
+		{loc: OutputSource, dir: "ignored", want: "browse/2021/day1"},
+		{loc: OutputBrowse, dir: "renders/2021", want: "browse/renders/2021"},
+		{loc: OutputSeparate, dir: "", want: "out"},
+		{loc: OutputSeparate, dir: "a/../b", want: "out/b"},
+		{loc: OutputSeparate, dir: "inside/new/deeper", want: "out/existing/new/deeper"},
+		{loc: OutputBrowse, dir: "renders/up/new", want: "browse/new"},
+		{loc: OutputBrowse, dir: "../out", wantErr: true},
+		{loc: OutputSeparate, dir: "a/../../browse", wantErr: true},
+		{loc: OutputBrowse, dir: "escape", wantErr: true},
+		{loc: OutputBrowse, dir: "escape/new", wantErr: true},
+		{loc: OutputSeparate, dir: "escape/new/deeper", wantErr: true},
+		{loc: "elsewhere", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := f.GetOutputDir(tl, tc.loc, tc.dir)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("GetOutputDir(%q, %q) = %v, want error", tc.loc, tc.dir, got)
+			}
+			continue
+		}
+		if want := filepath.Join(data, tc.want); err != nil || got != want {
+			t.Errorf("GetOutputDir(%q, %q) = %v, %v, want %v", tc.loc, tc.dir, got, err, want)
+		}
+	}
+
+	f.OutputRoot = ""
+	if _, err := f.GetOutputDir(tl, OutputSeparate, ""); err == nil {
+		t.Errorf("GetOutputDir() without an output root succeeded")
+	}
+}
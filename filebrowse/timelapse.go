@@ -6,8 +6,11 @@ import (
 	"image"
 	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 
+	"timelapse-queue/util"
+
 	"github.com/pixiv/go-libjpeg/jpeg"
 )
 
@@ -94,7 +97,7 @@ func (t *Timelapse) GetPathForIndex(idx int) string {
 	return t.GetOutputFullPath(base)
 }
 
-func getImage(path string) (*image.RGBA, error) {
+func getImage(path string, opts *jpeg.DecoderOptions) (*image.RGBA, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -102,7 +105,7 @@ func getImage(path string) (*image.RGBA, error) {
 	defer f.Close()
 
 	// Requires libjpeg-turbo
-	img, err := jpeg.DecodeIntoRGBA(f, &jpeg.DecoderOptions{})
+	img, err := jpeg.DecodeIntoRGBA(f, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -126,23 +129,164 @@ func ImagePaths(t ITimelapse, start, end, skip int) <-chan string {
 	return pathc
 }
 
+// ImageOptions controls how Images decodes the sequence.
+type ImageOptions struct {
+	// Workers is the number of frames decoded ahead concurrently. Zero uses one
+	// worker per CPU.
+	Workers int
+	// ScaleTarget, if non-empty, allows libjpeg to decode using DCT scaling to
+	// the smallest size that is at least as large as the target.
+	ScaleTarget image.Rectangle
+	// Pool, if set, adopts decoded frames so their buffers are recycled once
+	// released downstream. The decoder still allocates each frame; see
+	// util.FramePool.
+	Pool *util.FramePool
+}
+
+func (o *ImageOptions) pool() *util.FramePool {
+	if o == nil {
+		return nil
+	}
+	return o.Pool
+}
+
+func (o *ImageOptions) workers() int {
+	if o == nil || o.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return o.Workers
+}
+
+func (o *ImageOptions) decoderOptions() *jpeg.DecoderOptions {
+	if o == nil {
+		return &jpeg.DecoderOptions{}
+	}
+	return &jpeg.DecoderOptions{ScaleTarget: o.ScaleTarget}
+}
+
+// ImageBounds returns the full size bounds of the image at the given index
+// without decoding the image data.
+func ImageBounds(t ITimelapse, idx int) (image.Rectangle, error) {
+	f, err := os.Open(t.GetPathForIndex(idx))
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	defer f.Close()
+
+	c, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return image.Rect(0, 0, c.Width, c.Height), nil
+}
+
+// Image decodes the single frame at the given index.
+func Image(t ITimelapse, idx int, opts *ImageOptions) (*image.RGBA, error) {
+	if idx < 0 || idx >= t.ImageCount() {
+		return nil, fmt.Errorf("index out of timelapse range %d to %d", 0, t.ImageCount()-1)
+	}
+	img, err := getImage(t.GetPathForIndex(idx), opts.decoderOptions())
+	if err != nil {
+		return nil, err
+	}
+	opts.pool().Adopt(img)
+	return img, nil
+}
+
+type decodeResult struct {
+	img *image.RGBA
+	err error
+	// repeat marks an index equal to the previous one, which is not decoded
+	// again.
+	repeat bool
+}
+
 // Images produces a stream of images for this timelapse.
 // Optionally supply non-zero start & end for bounded timelapse.
-func Images(ctx context.Context, t ITimelapse, start, end, skip int) (<-chan *image.RGBA, chan error) {
+//
+// Frames are decoded concurrently up to opts.Workers ahead of the consumer,
+// but are always delivered in sequence order. A nil opts uses the defaults.
+func Images(ctx context.Context, t ITimelapse, start, end, skip int, opts *ImageOptions) (<-chan *image.RGBA, chan error) {
+	if end == 0 {
+		end = t.ImageCount() - 1
+	}
+	var indices []int
+	for i := start; i <= end; i += skip {
+		indices = append(indices, i)
+	}
+	return ImageIndices(ctx, t, indices, opts)
+}
+
+// ImageIndices produces a stream of the images at indices, in order, which may
+// repeat. Decoding is as for Images, except that an index repeating the
+// previous one is delivered as a copy of that frame rather than decoded again.
+func ImageIndices(ctx context.Context, t ITimelapse, indices []int, opts *ImageOptions) (<-chan *image.RGBA, chan error) {
 	errc := make(chan error, 1)
 	imagec := make(chan *image.RGBA)
+
+	workers := opts.workers()
+	dopts := opts.decoderOptions()
+	pool := opts.pool()
+
+	// Results of in-flight decodes, in sequence order. The capacity bounds the
+	// number of decoded frames waiting on the consumer.
+	pending := make(chan chan decodeResult, workers)
+
+	dctx, cancelf := context.WithCancel(ctx)
+	go func() {
+		defer close(pending)
+		for k, i := range indices {
+			resc := make(chan decodeResult, 1)
+			select {
+			case <-dctx.Done():
+				return
+			case pending <- resc:
+			}
+			if k > 0 && i == indices[k-1] {
+				resc <- decodeResult{repeat: true}
+				continue
+			}
+			go func(path string) {
+				img, err := getImage(path, dopts)
+				resc <- decodeResult{img: img, err: err}
+			}(t.GetPathForIndex(i))
+		}
+	}()
+
 	go func() {
 		defer close(imagec)
 		defer close(errc)
-		for path := range ImagePaths(t, start, end, skip) {
-			img, err := getImage(path)
-			if err != nil {
-				errc <- err
+		defer cancelf()
+		// A copy of the last frame delivered, while the next index repeats it.
+		// Downstream stages may modify or release the frame they receive.
+		var last *image.RGBA
+		defer func() { pool.Put(last) }()
+		k := 0
+		for resc := range pending {
+			var res decodeResult
+			select {
+			case <-ctx.Done():
+				return
+			case res = <-resc:
+			}
+			if res.err != nil {
+				errc <- res.err
 				return
 			}
+			img := res.img
+			if res.repeat {
+				img, last = last, nil
+			} else {
+				pool.Adopt(img)
+			}
+			if k++; k < len(indices) && indices[k] == indices[k-1] {
+				last = pool.Get(img.Rect)
+				copy(last.Pix, img.Pix)
+			}
 			// Write to channel as long as the context is still alive.
 			select {
 			case <-ctx.Done():
+				pool.Put(img)
 				return
 			case imagec <- img:
 			}
@@ -0,0 +1,169 @@
+package filebrowse
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeSequence writes count solid grey JPEGs, frame i at level 20*i, and
+// returns them as a timelapse.
+func writeSequence(t *testing.T, count int) *Timelapse {
+	dir := t.TempDir()
+	tl := &Timelapse{
+		Path:    "G0000001.JPG",
+		Prefix:  "G",
+		Ext:     "JPG",
+		NumLen:  7,
+		Count:   count,
+		Start:   1,
+		browser: &FileBrowser{Root: dir},
+	}
+	for i := 0; i < count; i++ {
+		img := image.NewGray(image.Rect(0, 0, 32, 16))
+		for j := range img.Pix {
+			img.Pix[j] = uint8(20 * i)
+		}
+		f, err := os.Create(tl.GetPathForIndex(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 100}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return tl
+}
+
+// frameLevels reads the stream and returns the grey level of each frame, to
+// the nearest multiple of 20.
+func frameLevels(imagec <-chan *image.RGBA, errc chan error) ([]int, error) {
+	var got []int
+	for img := range imagec {
+		c := color.GrayModel.Convert(img.At(img.Rect.Min.X, img.Rect.Min.Y)).(color.Gray)
+		got = append(got, (int(c.Y)+10)/20)
+	}
+	return got, <-errc
+}
+
+func TestImagesOrder(t *testing.T) {
+	tl := writeSequence(t, 9)
+	for _, workers := range []int{1, 3, 16} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			imagec, errc := Images(context.Background(), tl, 1, 8, 2, &ImageOptions{Workers: workers})
+			got, err := frameLevels(imagec, errc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]int{1, 3, 5, 7}, got); diff != "" {
+				t.Errorf("Images() frames mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// countingTimelapse counts the paths requested for each index, as each is
+// requested once per decode.
+type countingTimelapse struct {
+	*Timelapse
+	mu    sync.Mutex
+	reads map[int]int
+}
+
+func (c *countingTimelapse) GetPathForIndex(idx int) string {
+	c.mu.Lock()
+	c.reads[idx]++
+	c.mu.Unlock()
+	return c.Timelapse.GetPathForIndex(idx)
+}
+
+func TestImageIndices(t *testing.T) {
+	tl := &countingTimelapse{Timelapse: writeSequence(t, 6), reads: make(map[int]int)}
+	want := []int{0, 0, 3, 2, 5, 5, 5, 2}
+	imagec, errc := ImageIndices(context.Background(), tl, want, &ImageOptions{Workers: 4})
+	var got []int
+	for img := range imagec {
+		c := color.GrayModel.Convert(img.At(0, 0)).(color.Gray)
+		got = append(got, (int(c.Y)+10)/20)
+		// Repeated frames are copies, so changing one can't change the next.
+		for i := range img.Pix {
+			img.Pix[i] = 255
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ImageIndices() frames mismatch (-want +got):\n%s", diff)
+	}
+	// Only consecutive repeats reuse the previous frame.
+	if diff := cmp.Diff(map[int]int{0: 1, 2: 2, 3: 1, 5: 1}, tl.reads); diff != "" {
+		t.Errorf("ImageIndices() decodes per index mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestImagesScaled(t *testing.T) {
+	tl := writeSequence(t, 2)
+	imagec, errc := Images(context.Background(), tl, 0, 1, 1, &ImageOptions{ScaleTarget: image.Rect(0, 0, 8, 4)})
+	for img := range imagec {
+		if got, want := img.Rect.Size(), image.Pt(8, 4); got != want {
+			t.Errorf("scaled decode size = %v, want %v", got, want)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImagesError(t *testing.T) {
+	tl := writeSequence(t, 6)
+	if err := os.Remove(tl.GetPathForIndex(2)); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			// Frames decoded ahead of the failed one are dropped, and the
+			// stream ends with its error.
+			imagec, errc := Images(context.Background(), tl, 0, 5, 1, &ImageOptions{Workers: workers})
+			got, err := frameLevels(imagec, errc)
+			if !os.IsNotExist(err) {
+				t.Errorf("Images() with a missing frame error = %v, want not exist", err)
+			}
+			if diff := cmp.Diff([]int{0, 1}, got); diff != "" {
+				t.Errorf("Images() frames before error mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImagesCancel(t *testing.T) {
+	tl := writeSequence(t, 30)
+	ctx, cancelf := context.WithCancel(context.Background())
+	defer cancelf()
+	imagec, errc := Images(ctx, tl, 0, 0, 1, &ImageOptions{Workers: 3})
+	if _, ok := <-imagec; !ok {
+		t.Fatalf("Images() closed before the first frame")
+	}
+	cancelf()
+
+	// The stream closes without delivering the rest of the sequence. Frames
+	// already decoded may race the cancel, so only its end is checked.
+	n := 0
+	for range imagec {
+		n++
+	}
+	if n == 29 {
+		t.Errorf("Images() delivered the whole sequence after cancel")
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Images() error after cancel = %v, want nil", err)
+	}
+}
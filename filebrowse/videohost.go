@@ -0,0 +1,67 @@
+package filebrowse
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// videoTypes maps the extensions of servable job outputs to content types.
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// VideoHost serves the output videos of finished jobs, with support for range
+// requests so they can be played in the browser.
+type VideoHost struct {
+	Browser *FileBrowser
+}
+
+// openVideo opens the named output of the timelapse at path, written to output
+// location loc and dir, confined to the location's root.
+func (h *VideoHost) openVideo(path, loc, dir, name string) (*os.File, os.FileInfo, error) {
+	if _, ok := videoTypes[strings.ToLower(filepath.Ext(name))]; !ok {
+		return nil, nil, fmt.Errorf("%q is not a video", name)
+	}
+	full, err := h.Browser.GetOutputFile(path, loc, dir, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, fmt.Errorf("%q is not a file", name)
+	}
+	return f, fi, nil
+}
+
+func (h *VideoHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := r.Form.Get("name")
+	f, fi, err := h.openVideo(r.Form.Get("path"), r.Form.Get("loc"), r.Form.Get("dir"), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", videoTypes[strings.ToLower(filepath.Ext(name))])
+	// Outputs are only rewritten as a whole, so size and time identify them.
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
@@ -0,0 +1,75 @@
+package filebrowse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVideoHost(t *testing.T) {
+	root, err := ioutil.TempDir("", "videohost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "videohost-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	for i := 15; i < 18; i++ {
+		if err := ioutil.WriteFile(filepath.Join(root, fmt.Sprintf("G00210%d.JPG", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "out.mp4"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret.mp4"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.mp4"), filepath.Join(root, "escape.mp4")); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &VideoHost{NewFileBrowser(root)}
+	tests := []struct {
+		name     string
+		file     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "range", file: "out.mp4", wantCode: http.StatusPartialContent, wantBody: "2345"},
+		{name: "symlink escape", file: "escape.mp4", wantCode: http.StatusNotFound},
+		{name: "not a video", file: "G0021015.JPG", wantCode: http.StatusNotFound},
+		{name: "missing", file: "none.mp4", wantCode: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/video?path=G0021015.JPG&name="+tc.file, nil)
+			r.Header.Set("Range", "bytes=2-5")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Fatalf("ServeHTTP() code = %d, want %d: %s", w.Code, tc.wantCode, w.Body)
+			}
+			if tc.wantBody == "" {
+				return
+			}
+			if got := w.Body.String(); got != tc.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", got, tc.wantBody)
+			}
+			if got := w.Header().Get("Content-Type"); got != "video/mp4" {
+				t.Errorf("Content-Type = %q, want video/mp4", got)
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Errorf("ETag not set")
+			}
+		})
+	}
+}
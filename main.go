@@ -8,6 +8,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"syscall"
 	"time"
@@ -26,11 +27,31 @@ var (
 	portSSL = flag.Int("port_ssl", 8443, "Port to host web frontend (https). Requires cert files set in env.")
 	root    = flag.String("root", "/home/jeff", "Filesystem root.")
 
+	outputRoot     = flag.String("output_root", "", "Optional separate writable root for job outputs.")
+	outputLocation = flag.String("output_location", filebrowse.OutputSource, "Default output location of jobs: source, browse or output (within output_root).")
+	outputDir      = flag.String("output_dir", "", "Default output directory of jobs, relative to the output location root. May contain {date}, {name}, {profile} and {codec}.")
+
+	decodeWorkers  = flag.Int("decode_workers", 0, "Number of frames to decode ahead in parallel. Zero uses one per CPU.")
+	scaledDecode   = flag.Bool("scaled_decode", true, "Decode frames at reduced size when the crop region allows.")
+	partialJournal = flag.String("partial_journal", cacheFile("partial"), "File recording in-progress temporary outputs, removed at startup if left behind. Empty disables.")
+	sizeHistory    = flag.String("size_history", cacheFile("sizes.json"), "File recording output sizes of finished jobs, to refine size estimates. Empty keeps them in memory.")
+	minFreeMB      = flag.Int64("min_free_mb", 1024, "Free space in MiB to keep on the output filesystem. Jobs that would use it are rejected or cancelled.")
+
 	// Timestamp that can be set with ldflags for versioning.
 	// Expected to be empty, or unix seconds.
 	BuildTimestamp string
 )
 
+// cacheFile returns the path of the named state file in the user cache
+// directory, or "" if there is none.
+func cacheFile(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "timelapse-queue", name)
+}
+
 func maxAgeHandler(seconds int, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Cache-Control", fmt.Sprintf("max-age=%d, public, must-revalidate, proxy-revalidate", seconds))
@@ -58,13 +79,44 @@ func main() {
 		log.Infof("Located ffmpeg binary, %v", ffmpegp)
 	}
 
+	ffprobep, err := util.LocateFFprobe()
+	if err != nil {
+		log.Errorf("Unable to locate ffprobe binary: %v", err)
+		fmt.Println("Either ensure the ffprobe binary is in $PATH,")
+		fmt.Println("or set the FFPROBE environment variable.")
+		os.Exit(1)
+		return
+	} else {
+		log.Infof("Located ffprobe binary, %v", ffprobep)
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	filebrowse.WatchMountHealthy(*root)
 	fb := filebrowse.NewFileBrowser(*root)
+	fb.OutputRoot = *outputRoot
 	ih := &filebrowse.ImageHost{fb}
 	lh := &filebrowse.LogHost{fb}
+	vh := &filebrowse.VideoHost{fb}
+
+	engine.DecodeWorkers = *decodeWorkers
+	engine.ScaledDecode = *scaledDecode
+	engine.Version = BuildTimestamp
+	engine.DefaultOutputLocation = *outputLocation
+	engine.DefaultOutputDir = *outputDir
+	engine.PartialJournal = *partialJournal
+	engine.SizeHistory = *sizeHistory
+	engine.MinFreeSpace = *minFreeMB << 20
+	for _, p := range []string{*partialJournal, *sizeHistory} {
+		if p == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			log.Warnf("Unable to create state directory: %v", err)
+		}
+	}
+	engine.CleanPartialOutputs()
 
 	jq := engine.NewJobQueue()
 	go jq.Loop(context.Background())
@@ -73,16 +125,27 @@ func main() {
 		Browser: fb,
 		Queue:   jq,
 	}
+	preview := &engine.PreviewServer{
+		Browser: fb,
+	}
+	estimate := &engine.EstimateServer{
+		Browser: fb,
+	}
 
 	go func() {
 		http.Handle("/filebrowser", fb)
 		http.HandleFunc("/timelapse", fb.ServeTimelapse)
 		http.Handle("/image", ih)
 		http.Handle("/log", lh)
+		http.Handle("/video", vh)
 		http.Handle("/convert", eng)
+		http.Handle("/preview", preview)
+		http.Handle("/estimate", estimate)
 		http.Handle("/queue", jq)
 		http.HandleFunc("/queue-cancel", jq.ServeCancel)
 		http.HandleFunc("/queue-remove", jq.ServeRemove)
+		http.HandleFunc("/queue-promote", jq.ServePromote)
+		http.HandleFunc("/queue-preview", jq.ServePreview)
 		http.HandleFunc("/profiles", engine.ServeProfiles)
 		http.Handle("/metrics", promhttp.Handler())
 		http.Handle("/",
@@ -0,0 +1,239 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Adjustments are basic tonal and color corrections. The zero value leaves
+// images unchanged.
+type Adjustments struct {
+	// Exposure in stops (EV), -5 to 5.
+	Exposure float64
+	// Contrast, -1 to 1.
+	Contrast float64
+	// Highlights and Shadows brighten (positive) or darken (negative) the
+	// bright and dark tones respectively, -1 to 1.
+	Highlights, Shadows float64
+	// Temperature shifts white balance towards blue (negative) or amber
+	// (positive), -1 to 1.
+	Temperature float64
+	// Tint shifts white balance towards green (negative) or magenta
+	// (positive), -1 to 1.
+	Tint float64
+	// Saturation, -1 (monochrome) to 1.
+	Saturation float64
+	// Gamma correction, 0.1 to 5. Zero is treated as 1 (unchanged).
+	Gamma float64
+}
+
+// AdjustKeyframe sets the adjustments at a given source frame. Adjustments
+// between keyframes are linearly interpolated.
+type AdjustKeyframe struct {
+	Frame int
+	Adjustments
+}
+
+func checkRange(name string, v, min, max float64) error {
+	if v < min || v > max {
+		return fmt.Errorf("%s must be between %v and %v", name, min, max)
+	}
+	return nil
+}
+
+func (a *Adjustments) Validate() error {
+	checks := []error{
+		checkRange("exposure", a.Exposure, -5, 5),
+		checkRange("contrast", a.Contrast, -1, 1),
+		checkRange("highlights", a.Highlights, -1, 1),
+		checkRange("shadows", a.Shadows, -1, 1),
+		checkRange("temperature", a.Temperature, -1, 1),
+		checkRange("tint", a.Tint, -1, 1),
+		checkRange("saturation", a.Saturation, -1, 1),
+	}
+	if a.Gamma != 0 {
+		checks = append(checks, checkRange("gamma", a.Gamma, 0.1, 5))
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateKeyframes checks that keyframes are valid and in ascending frame order.
+func ValidateKeyframes(keys []AdjustKeyframe) error {
+	for i := range keys {
+		if err := keys[i].Validate(); err != nil {
+			return fmt.Errorf("keyframe %d: %v", keys[i].Frame, err)
+		}
+		if i > 0 && keys[i].Frame <= keys[i-1].Frame {
+			return fmt.Errorf("keyframes must be in ascending frame order")
+		}
+	}
+	return nil
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+func (a *Adjustments) gamma() float64 {
+	if a.Gamma == 0 {
+		return 1
+	}
+	return a.Gamma
+}
+
+// AdjustmentsAt interpolates the keyframes at the given source frame.
+func AdjustmentsAt(keys []AdjustKeyframe, frame int) Adjustments {
+	if len(keys) == 0 {
+		return Adjustments{}
+	}
+	if frame <= keys[0].Frame {
+		return keys[0].Adjustments
+	}
+	for i := 1; i < len(keys); i++ {
+		if frame > keys[i].Frame {
+			continue
+		}
+		a, b := keys[i-1].Adjustments, keys[i].Adjustments
+		t := float64(frame-keys[i-1].Frame) / float64(keys[i].Frame-keys[i-1].Frame)
+		return Adjustments{
+			Exposure:    lerp(a.Exposure, b.Exposure, t),
+			Contrast:    lerp(a.Contrast, b.Contrast, t),
+			Highlights:  lerp(a.Highlights, b.Highlights, t),
+			Shadows:     lerp(a.Shadows, b.Shadows, t),
+			Temperature: lerp(a.Temperature, b.Temperature, t),
+			Tint:        lerp(a.Tint, b.Tint, t),
+			Saturation:  lerp(a.Saturation, b.Saturation, t),
+			Gamma:       lerp(a.gamma(), b.gamma(), t),
+		}
+	}
+	return keys[len(keys)-1].Adjustments
+}
+
+// curves returns per-channel lookup tables combining white balance, exposure
+// and the tone curve.
+func (a *Adjustments) curves() [3][256]uint8 {
+	gains := [3]float64{
+		1 + 0.3*a.Temperature,
+		1 - 0.3*a.Tint,
+		1 - 0.3*a.Temperature,
+	}
+	exposure := math.Pow(2, a.Exposure)
+	invGamma := 1 / a.gamma()
+
+	var curves [3][256]uint8
+	for ch := 0; ch < 3; ch++ {
+		for i := 0; i < 256; i++ {
+			v := float64(i) / 255 * gains[ch] * exposure
+			v = math.Min(math.Max(v, 0), 1)
+			v = math.Pow(v, invGamma)
+			// Highlight and shadow recovery, anchored at black and white.
+			v += 2*a.Shadows*v*(1-v)*(1-v) + 2*a.Highlights*v*v*(1-v)
+			v = 0.5 + (v-0.5)*(1+a.Contrast)
+			curves[ch][i] = toByte(float32(v))
+		}
+	}
+	return curves
+}
+
+// Apply adjusts img in place.
+func (a *Adjustments) Apply(img *image.RGBA) {
+	curves := a.curves()
+	// Saturation in 16.16 fixed point.
+	sat := int32((1 + a.Saturation) * (1 << 16))
+	rowLen := img.Rect.Dx() * 4
+
+	parallelRows(img.Rect.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+			row := img.Pix[o : o+rowLen]
+			for x := 0; x < len(row); x += 4 {
+				r := int32(curves[0][row[x]])
+				g := int32(curves[1][row[x+1]])
+				b := int32(curves[2][row[x+2]])
+				if sat != 1<<16 {
+					// Rec. 709 luma.
+					l := (13933*r + 46871*g + 4732*b) >> 16
+					r = l + ((r-l)*sat)>>16
+					g = l + ((g-l)*sat)>>16
+					b = l + ((b-l)*sat)>>16
+				}
+				row[x] = clamp8(r)
+				row[x+1] = clamp8(g)
+				row[x+2] = clamp8(b)
+			}
+		}
+	})
+}
+
+func clamp8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// Adjust applies keyframed Adjustments to each frame, in place.
+type Adjust struct {
+	Keyframes []AdjustKeyframe
+	// SourceFrame maps the index of a frame within the stream to its source
+	// frame number, against which keyframes are matched.
+	SourceFrame func(n int) int
+}
+
+func (a *Adjust) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+		n := 0
+		for img := range inc {
+			adj := AdjustmentsAt(a.Keyframes, a.SourceFrame(n))
+			adj.Apply(img)
+			n++
+			select {
+			case <-ctx.Done():
+				return
+			case outc <- img:
+			}
+		}
+	}()
+	return outc, errc
+}
+
+type adjustParams struct {
+	Keyframes []AdjustKeyframe
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name:    "adjust",
+		Grading: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			var p adjustParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			if err := ValidateKeyframes(p.Keyframes); err != nil {
+				return nil, err
+			}
+			a := &Adjust{
+				Keyframes:   p.Keyframes,
+				SourceFrame: env.SourceFrame,
+			}
+			if a.SourceFrame == nil {
+				a.SourceFrame = func(n int) int { return n }
+			}
+			return a, nil
+		},
+	})
+}
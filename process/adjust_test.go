@@ -0,0 +1,46 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAdjustIdentity(t *testing.T) {
+	img := testPattern(23, 19)
+	want := append([]uint8(nil), img.Pix...)
+	adj := &Adjustments{}
+	adj.Apply(img)
+	if diff := cmp.Diff(want, img.Pix); diff != "" {
+		t.Errorf("zero adjustments changed the image: %v", diff)
+	}
+}
+
+func TestAdjustmentsAt(t *testing.T) {
+	keys := []AdjustKeyframe{
+		{Frame: 10, Adjustments: Adjustments{Exposure: 1}},
+		{Frame: 20, Adjustments: Adjustments{Exposure: 3, Gamma: 2}},
+	}
+	tests := []struct {
+		frame int
+		want  Adjustments
+	}{
+		{0, Adjustments{Exposure: 1}},
+		{15, Adjustments{Exposure: 2, Gamma: 1.5}},
+		{25, Adjustments{Exposure: 3, Gamma: 2}},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(test.want, AdjustmentsAt(keys, test.frame)); diff != "" {
+			t.Errorf("frame %d: %v", test.frame, diff)
+		}
+	}
+}
+
+func TestAdjustExposure(t *testing.T) {
+	img := solidFrame(64)
+	adj := &Adjustments{Exposure: 1, Saturation: -1}
+	adj.Apply(img)
+	if img.Pix[0] != 128 {
+		t.Errorf("got %d, want one stop brighter", img.Pix[0])
+	}
+}
@@ -2,16 +2,39 @@ package process
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"image"
 )
 
 type Crop struct {
 	Region image.Rectangle
+
+	// Source is the expected size of input frames that Region is relative to.
+	// If non-zero and frames arrive at a different size (e.g. due to scaled
+	// decoding), Region is scaled to match.
+	Source image.Point
+}
+
+// scaledRegion returns the crop region for an input frame with the given bounds.
+func (c *Crop) scaledRegion(b image.Rectangle) image.Rectangle {
+	if c.Source == (image.Point{}) || b.Size() == c.Source {
+		return c.Region
+	}
+	sx := func(v int) int { return b.Min.X + v*b.Dx()/c.Source.X }
+	sy := func(v int) int { return b.Min.Y + v*b.Dy()/c.Source.Y }
+	min := image.Point{X: sx(c.Region.Min.X), Y: sy(c.Region.Min.Y)}
+	return image.Rectangle{
+		Min: min,
+		Max: image.Point{
+			X: min.X + c.Region.Dx()*b.Dx()/c.Source.X,
+			Y: min.Y + c.Region.Dy()*b.Dy()/c.Source.Y,
+		},
+	}
 }
 
 func (c *Crop) crop(in *image.RGBA) (*image.RGBA, error) {
-	img := in.SubImage(c.Region)
+	img := in.SubImage(c.scaledRegion(in.Rect))
 	out, ok := img.(*image.RGBA)
 	if !ok {
 		return nil, fmt.Errorf("Output of subimage not RGBA")
@@ -38,3 +61,22 @@ func (c *Crop) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan er
 	}()
 	return outc, errc
 }
+
+func init() {
+	// The crop region is part of the job geometry, set by the job rather than
+	// params.
+	RegisterStage(&StageType{
+		Name:        "crop",
+		Crop:        true,
+		JobGeometry: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			if len(params) > 0 {
+				return nil, fmt.Errorf("crop region is set by the job")
+			}
+			if env.Region.Empty() {
+				return nil, fmt.Errorf("empty crop region")
+			}
+			return &Crop{Region: env.Region, Source: env.Source}, nil
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package process
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCropScaledRegion(t *testing.T) {
+	region := image.Rect(400, 200, 2000, 1100)
+	tests := []struct {
+		name   string
+		source image.Point
+		bounds image.Rectangle
+		want   image.Rectangle
+	}{
+		{
+			name:   "no source",
+			bounds: image.Rect(0, 0, 1000, 750),
+			want:   region,
+		},
+		{
+			name:   "full size",
+			source: image.Pt(4000, 3000),
+			bounds: image.Rect(0, 0, 4000, 3000),
+			want:   region,
+		},
+		{
+			name:   "half size",
+			source: image.Pt(4000, 3000),
+			bounds: image.Rect(0, 0, 2000, 1500),
+			want:   image.Rect(200, 100, 1000, 550),
+		},
+		{
+			name:   "quarter size offset",
+			source: image.Pt(4000, 3000),
+			bounds: image.Rect(10, 20, 1010, 770),
+			want:   image.Rect(110, 70, 510, 295),
+		},
+	}
+	for _, tc := range tests {
+		c := &Crop{Region: region, Source: tc.source}
+		if got := c.scaledRegion(tc.bounds); got != tc.want {
+			t.Errorf("%s: scaledRegion(%v) = %v, want %v", tc.name, tc.bounds, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+
+	"timelapse-queue/util"
+)
+
+// Crossfade increases the frame count by synthesizing intermediate frames
+// between each pair of input frames as a linear blend of the two.
+type Crossfade struct {
+	// Factor is the number of output frames per input frame interval; a
+	// factor of 3 inserts two blended frames between each input pair.
+	Factor int
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
+}
+
+// CrossfadeFrames returns the number of frames produced from n input frames.
+func CrossfadeFrames(n, factor int) int {
+	if n < 1 || factor < 2 {
+		return n
+	}
+	return (n-1)*factor + 1
+}
+
+// mix blends a and b into a new frame, with weight t/Factor given to b.
+func (c *Crossfade) mix(a, b *image.RGBA, t int) *image.RGBA {
+	return mixFrames(c.Pool, a, b, uint32(t<<16)/uint32(c.Factor))
+}
+
+// mixFrames blends a and b into a new frame from pool, with weight wb/65536
+// given to b.
+func mixFrames(pool *util.FramePool, a, b *image.RGBA, wb uint32) *image.RGBA {
+	r := a.Rect
+	out := pool.Get(image.Rectangle{Max: r.Size()})
+	wa := 1<<16 - wb
+	rowLen := r.Dx() * 4
+
+	parallelRows(r.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			arow := a.Pix[a.PixOffset(a.Rect.Min.X, a.Rect.Min.Y+y):]
+			brow := b.Pix[b.PixOffset(b.Rect.Min.X, b.Rect.Min.Y+y):]
+			drow := out.Pix[y*out.Stride : y*out.Stride+rowLen]
+			for x := range drow {
+				drow[x] = uint8((uint32(arow[x])*wa + uint32(brow[x])*wb + 1<<15) >> 16)
+			}
+		}
+	})
+	return out
+}
+
+func (c *Crossfade) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+		send := func(img *image.RGBA) bool {
+			select {
+			case <-ctx.Done():
+				c.Pool.Put(img)
+				return false
+			case outc <- img:
+				return true
+			}
+		}
+
+		var prev *image.RGBA
+		for img := range inc {
+			if prev != nil {
+				for t := 1; t < c.Factor; t++ {
+					if !send(c.mix(prev, img, t)) {
+						return
+					}
+				}
+			}
+			// The input frame is passed through, so keep a copy for blending
+			// with the next frame.
+			cur := c.Pool.Get(image.Rectangle{Max: img.Rect.Size()})
+			c.Pool.Put(prev)
+			copyFrame(cur, img)
+			prev = cur
+			if !send(img) {
+				return
+			}
+		}
+		c.Pool.Put(prev)
+	}()
+	return outc, errc
+}
+
+// copyFrame copies the pixels of src into dst, which must be the same size.
+func copyFrame(dst, src *image.RGBA) {
+	rowLen := src.Rect.Dx() * 4
+	for y := 0; y < src.Rect.Dy(); y++ {
+		so := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+		do := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y)
+		copy(dst.Pix[do:do+rowLen], src.Pix[so:so+rowLen])
+	}
+}
+
+// MaxCrossfadeFactor bounds the number of frames synthesized per input frame.
+const MaxCrossfadeFactor = 8
+
+type crossfadeParams struct {
+	Factor int
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name: "crossfade",
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			var p crossfadeParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Factor < 2 || p.Factor > MaxCrossfadeFactor {
+				return nil, fmt.Errorf("factor must be between 2 and %d", MaxCrossfadeFactor)
+			}
+			return &Crossfade{Factor: p.Factor, Pool: env.Pool}, nil
+		},
+		Frames: func(params json.RawMessage, n int) int {
+			var p crossfadeParams
+			decodeParams(params, &p)
+			return CrossfadeFrames(n, p.Factor)
+		},
+		SourceFrame: func(params json.RawMessage, n int) int {
+			var p crossfadeParams
+			if decodeParams(params, &p) != nil || p.Factor < 1 {
+				return n
+			}
+			return n / p.Factor
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package process
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"timelapse-queue/util"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCrossfadeFrames(t *testing.T) {
+	tests := []struct {
+		n, factor, want int
+	}{
+		{n: 0, factor: 3, want: 0},
+		{n: 1, factor: 3, want: 1},
+		{n: 5, factor: 1, want: 5},
+		{n: 2, factor: 2, want: 3},
+		{n: 5, factor: 3, want: 13},
+	}
+	for _, tc := range tests {
+		if got := CrossfadeFrames(tc.n, tc.factor); got != tc.want {
+			t.Errorf("CrossfadeFrames(%d, %d) = %d, want %d", tc.n, tc.factor, got, tc.want)
+		}
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+	tests := []struct {
+		name   string
+		factor int
+		input  []uint8
+		want   []uint8
+	}{
+		{
+			name:   "single frame",
+			factor: 3,
+			input:  []uint8{90},
+			want:   []uint8{90},
+		},
+		{
+			name:   "halves",
+			factor: 2,
+			input:  []uint8{0, 100, 50},
+			want:   []uint8{0, 50, 100, 75, 50},
+		},
+		{
+			name:   "thirds",
+			factor: 3,
+			input:  []uint8{0, 90, 0},
+			want:   []uint8{0, 30, 60, 90, 60, 30, 0},
+		},
+		{
+			name:   "rounding",
+			factor: 4,
+			input:  []uint8{0, 255},
+			want:   []uint8{0, 64, 128, 191, 255},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Crossfade{Factor: test.factor, Pool: util.NewFramePool()}
+			inc := make(chan *image.RGBA)
+			go func() {
+				defer close(inc)
+				for _, v := range test.input {
+					inc <- solidFrame(v)
+				}
+			}()
+			outc, _ := c.Process(context.Background(), inc, make(chan error, 1))
+			got := []uint8{}
+			for img := range outc {
+				if img.Rect.Size() != image.Pt(4, 3) {
+					t.Errorf("frame size = %v, want 4x3", img.Rect.Size())
+				}
+				got = append(got, img.Pix[0])
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("crossfaded frames diff: %v", diff)
+			}
+			if n := CrossfadeFrames(len(test.input), test.factor); n != len(got) {
+				t.Errorf("CrossfadeFrames() = %d, produced %d", n, len(got))
+			}
+		})
+	}
+}
+
+func TestCrossfadeMixSubImage(t *testing.T) {
+	c := &Crossfade{Factor: 2}
+	a := solidFrame(0)
+	b := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for i := range b.Pix {
+		b.Pix[i] = 200
+	}
+	sub := b.SubImage(image.Rect(2, 1, 6, 4)).(*image.RGBA)
+	out := c.mix(a, sub, 1)
+	if out.Rect != image.Rect(0, 0, 4, 3) {
+		t.Fatalf("mix() bounds = %v, want compact 4x3", out.Rect)
+	}
+	for i, v := range out.Pix {
+		if v != 100 {
+			t.Fatalf("mix() pixel byte %d = %d, want 100", i, v)
+		}
+	}
+}
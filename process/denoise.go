@@ -0,0 +1,234 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+
+	"timelapse-queue/util"
+)
+
+// Denoise reduces sensor noise by combining each frame with its neighbours in
+// time. Pixels which change too much between frames (moving subjects, stars)
+// are excluded so they don't ghost.
+type Denoise struct {
+	// Mode is one of DenoiseModes.
+	Mode string
+	// Radius is the number of frames either side of each frame that are
+	// combined with it.
+	Radius int
+	// Threshold is the largest difference in any channel from the center frame
+	// for a neighbouring pixel to contribute. Mean weights fall off linearly up
+	// to the threshold. Zero disables motion detection.
+	Threshold int
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
+}
+
+// DenoiseModes lists the supported temporal denoise modes.
+var DenoiseModes = []string{"mean", "median"}
+
+// ValidDenoiseMode returns whether the named mode is supported.
+func ValidDenoiseMode(mode string) bool {
+	for _, m := range DenoiseModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxDenoiseRadius bounds the number of frames held in memory by the stage.
+const MaxDenoiseRadius = 7
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// pixelDiff is the largest difference in any color channel.
+func pixelDiff(a, b []uint8) int {
+	d := absDiff(a[0], b[0])
+	if g := absDiff(a[1], b[1]); g > d {
+		d = g
+	}
+	if bl := absDiff(a[2], b[2]); bl > d {
+		d = bl
+	}
+	return d
+}
+
+// median sorts the (small) sample set in place and returns the middle value.
+func median(s []uint8) uint8 {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+	return s[len(s)/2]
+}
+
+// combine computes the denoised frame for center from the frames in window.
+func (d *Denoise) combine(center *image.RGBA, window []*image.RGBA) *image.RGBA {
+	out := d.Pool.Get(center.Rect)
+	w, h := center.Rect.Dx(), center.Rect.Dy()
+	useMedian := d.Mode == "median"
+
+	parallelRows(h, func(y0, y1 int) {
+		rows := make([][]uint8, len(window))
+		var samples [4][2*MaxDenoiseRadius + 1]uint8
+		weights := make([]int, len(window))
+		for y := y0; y < y1; y++ {
+			c := center.Pix[center.PixOffset(center.Rect.Min.X, center.Rect.Min.Y+y):]
+			o := out.Pix[out.PixOffset(out.Rect.Min.X, out.Rect.Min.Y+y):]
+			for i, img := range window {
+				rows[i] = img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+			}
+			for x := 0; x < w*4; x += 4 {
+				cp := c[x : x+4]
+				n, total := 0, 0
+				for i, row := range rows {
+					p := row[x : x+4]
+					wt := 256
+					if d.Threshold > 0 {
+						diff := pixelDiff(p, cp)
+						if diff >= d.Threshold {
+							weights[i] = 0
+							continue
+						}
+						wt = 256 * (d.Threshold - diff) / d.Threshold
+					}
+					weights[i] = wt
+					total += wt
+					if useMedian {
+						for ch := 0; ch < 4; ch++ {
+							samples[ch][n] = p[ch]
+						}
+					}
+					n++
+				}
+
+				if useMedian {
+					for ch := 0; ch < 4; ch++ {
+						o[x+ch] = median(samples[ch][:n])
+					}
+					continue
+				}
+				var acc [4]int
+				for i, row := range rows {
+					if wt := weights[i]; wt > 0 {
+						for ch := 0; ch < 4; ch++ {
+							acc[ch] += int(row[x+ch]) * wt
+						}
+					}
+				}
+				for ch := 0; ch < 4; ch++ {
+					o[x+ch] = uint8((acc[ch] + total/2) / total)
+				}
+			}
+		}
+	})
+	return out
+}
+
+func (d *Denoise) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+
+		r := d.Radius
+		window := &slidingWindow{size: 2*r + 1}
+		frames := make(map[int]*image.RGBA)
+		defer func() {
+			for _, img := range frames {
+				d.Pool.Put(img)
+			}
+		}()
+
+		// emit outputs the frame at center, combined with its neighbours
+		// currently in the window.
+		emit := func(center int) bool {
+			imgs := make([]*image.RGBA, 0, window.len())
+			for _, f := range window.frames {
+				if f >= center-r && f <= center+r {
+					imgs = append(imgs, frames[f])
+				}
+			}
+			out := d.combine(frames[center], imgs)
+			select {
+			case <-ctx.Done():
+				d.Pool.Put(out)
+				return false
+			case outc <- out:
+				return true
+			}
+		}
+
+		frame, next := 0, 0
+		for img := range inc {
+			frames[frame] = img
+			if old, ok := window.push(frame); ok {
+				d.Pool.Put(frames[old])
+				delete(frames, old)
+			}
+			// The window is complete once it reaches r frames past next.
+			if frame-next == r {
+				if !emit(next) {
+					return
+				}
+				next++
+			}
+			frame++
+		}
+
+		// Remaining frames have fewer neighbours after them.
+		for ; next < frame; next++ {
+			for window.len() > 0 && window.first() < next-r {
+				old := window.pop()
+				d.Pool.Put(frames[old])
+				delete(frames, old)
+			}
+			if !emit(next) {
+				return
+			}
+		}
+	}()
+	return outc, errc
+}
+
+type denoiseParams struct {
+	Mode      string
+	Radius    int
+	Threshold int
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name: "denoise",
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			p := denoiseParams{Mode: "mean", Radius: 2}
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			if !ValidDenoiseMode(p.Mode) {
+				return nil, fmt.Errorf("invalid denoise mode %v", p.Mode)
+			}
+			if p.Radius < 1 || p.Radius > MaxDenoiseRadius {
+				return nil, fmt.Errorf("radius must be between 1 and %d", MaxDenoiseRadius)
+			}
+			if p.Threshold < 0 || p.Threshold > 255 {
+				return nil, fmt.Errorf("threshold must be between 0 and 255")
+			}
+			return &Denoise{
+				Mode:      p.Mode,
+				Radius:    p.Radius,
+				Threshold: p.Threshold,
+				Pool:      env.Pool,
+			}, nil
+		},
+	})
+}
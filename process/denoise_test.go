@@ -0,0 +1,68 @@
+package process
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDenoise(t *testing.T) {
+	tests := []struct {
+		name    string
+		denoise Denoise
+		input   []uint8
+		want    []uint8
+	}{
+		{
+			name:    "mean",
+			denoise: Denoise{Mode: "mean", Radius: 1},
+			input:   []uint8{10, 40, 10, 70},
+			want:    []uint8{25, 20, 40, 40},
+		},
+		{
+			name:    "median",
+			denoise: Denoise{Mode: "median", Radius: 1},
+			input:   []uint8{10, 200, 20, 30, 30},
+			want:    []uint8{200, 20, 30, 30, 30},
+		},
+		{
+			name:    "motion threshold",
+			denoise: Denoise{Mode: "mean", Radius: 1, Threshold: 20},
+			input:   []uint8{100, 100, 200, 100},
+			want:    []uint8{100, 100, 200, 100},
+		},
+		{
+			name:    "short input",
+			denoise: Denoise{Mode: "median", Radius: 3},
+			input:   []uint8{10, 20},
+			want:    []uint8{20, 20},
+		},
+		{
+			name:    "single frame",
+			denoise: Denoise{Mode: "mean", Radius: 2},
+			input:   []uint8{42},
+			want:    []uint8{42},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inc := make(chan *image.RGBA)
+			go func() {
+				defer close(inc)
+				for _, v := range test.input {
+					inc <- solidFrame(v)
+				}
+			}()
+			outc, _ := test.denoise.Process(context.Background(), inc, make(chan error, 1))
+			got := []uint8{}
+			for img := range outc {
+				got = append(got, img.Pix[0])
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("denoised frames diff: %v", diff)
+			}
+		})
+	}
+}
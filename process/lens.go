@@ -0,0 +1,247 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+
+	"timelapse-queue/util"
+)
+
+// LensProfile describes radial lens distortion and vignetting, in coordinates
+// normalized so the image corners are at radius 1.
+//
+// A corrected pixel at radius r samples the source at r*(1 + K1*r^2 + K2*r^4).
+// Negative K1 corrects barrel distortion, positive K1 pincushion.
+type LensProfile struct {
+	Name   string
+	K1, K2 float64
+	// Vignette brightens the source by 1 + Vignette*r^2, 0 to 1.
+	Vignette float64
+}
+
+// Approximate corrections for common lenses.
+var LensPresets = []*LensProfile{
+	{Name: "gopro-wide", K1: -0.28, K2: 0.07, Vignette: 0.3},
+	{Name: "gopro-medium", K1: -0.16, K2: 0.03, Vignette: 0.2},
+	{Name: "gopro-narrow", K1: -0.06, Vignette: 0.1},
+	{Name: "fisheye", K1: -0.42, K2: 0.12, Vignette: 0.4},
+}
+
+func GetLensPreset(name string) *LensProfile {
+	for _, p := range LensPresets {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// scale is the factor from corrected to source radius, for squared radius r2.
+func (l *LensProfile) scale(r2 float64) float64 {
+	return 1 + l.K1*r2 + l.K2*r2*r2
+}
+
+func (l *LensProfile) distorts() bool {
+	return l.K1 != 0 || l.K2 != 0
+}
+
+func (l *LensProfile) Validate() error {
+	if err := checkRange("k1", l.K1, -1, 1); err != nil {
+		return err
+	}
+	if err := checkRange("k2", l.K2, -1, 1); err != nil {
+		return err
+	}
+	if err := checkRange("vignette", l.Vignette, 0, 1); err != nil {
+		return err
+	}
+	// The mapping must be monotonic or the image folds back on itself.
+	prev := 0.0
+	for i := 1; i <= 100; i++ {
+		r := float64(i) / 100
+		rd := r * l.scale(r*r)
+		if rd <= prev {
+			return fmt.Errorf("distortion coefficients fold the image at radius %.2f", r)
+		}
+		prev = rd
+	}
+	return nil
+}
+
+// lensGeometry holds the normalization for a given frame size.
+type lensGeometry struct {
+	cx, cy, norm float64
+}
+
+func newLensGeometry(w, h int) lensGeometry {
+	return lensGeometry{
+		cx:   float64(w) / 2,
+		cy:   float64(h) / 2,
+		norm: math.Hypot(float64(w), float64(h)) / 2,
+	}
+}
+
+// ValidRegion returns the largest centered rectangle of a corrected frame of
+// the given size that only samples from within the source frame.
+func (l *LensProfile) ValidRegion(size image.Point) image.Rectangle {
+	full := image.Rectangle{Max: size}
+	if !l.distorts() {
+		return full
+	}
+	g := newLensGeometry(size.X, size.Y)
+	hx, hy := g.cx/g.norm, g.cy/g.norm
+
+	// Whether the rectangle of the given fraction of the frame size maps
+	// entirely within the source, checked along its perimeter.
+	inside := func(s float64) bool {
+		const steps = 64
+		for i := 0; i <= steps; i++ {
+			t := 2*float64(i)/steps - 1
+			for _, p := range [][2]float64{{t * hx, hy}, {hx, t * hy}} {
+				x, y := s*p[0], s*p[1]
+				f := l.scale(x*x + y*y)
+				if math.Abs(x*f) > hx || math.Abs(y*f) > hy {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if inside(1) {
+		return full
+	}
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 20; i++ {
+		if mid := (lo + hi) / 2; inside(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	mx := int(math.Ceil(g.cx * (1 - lo)))
+	my := int(math.Ceil(g.cy * (1 - lo)))
+	return image.Rect(mx, my, size.X-mx, size.Y-my)
+}
+
+// clampedPixel returns the pixel of img nearest to (x, y), relative to its origin.
+func clampedPixel(img *image.RGBA, x, y, w, h int) []uint8 {
+	if x < 0 {
+		x = 0
+	} else if x >= w {
+		x = w - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= h {
+		y = h - 1
+	}
+	return img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):]
+}
+
+// LensCorrection undistorts and devignettes frames according to a LensProfile.
+type LensCorrection struct {
+	Profile *LensProfile
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
+}
+
+// correct returns the corrected frame, which has the same size as in.
+func (c *LensCorrection) correct(in *image.RGBA) *image.RGBA {
+	l := c.Profile
+	w, h := in.Rect.Dx(), in.Rect.Dy()
+	out := c.Pool.Get(image.Rect(0, 0, w, h))
+	g := newLensGeometry(w, h)
+
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := out.Pix[y*out.Stride:]
+			ny := (float64(y) + 0.5 - g.cy) / g.norm
+			for x := 0; x < w; x++ {
+				nx := (float64(x) + 0.5 - g.cx) / g.norm
+				f := l.scale(nx*nx + ny*ny)
+				sx, sy := nx*f, ny*f
+				gain := 1 + l.Vignette*(sx*sx+sy*sy)
+
+				// Bilinear sample at the source position.
+				fx := sx*g.norm + g.cx - 0.5
+				fy := sy*g.norm + g.cy - 0.5
+				x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+				if x0 < -1 || y0 < -1 || x0 >= w || y0 >= h {
+					// Outside the source frame.
+					o[x*4], o[x*4+1], o[x*4+2], o[x*4+3] = 0, 0, 0, 0
+					continue
+				}
+				ax, ay := fx-float64(x0), fy-float64(y0)
+				p00, p10 := clampedPixel(in, x0, y0, w, h), clampedPixel(in, x0+1, y0, w, h)
+				p01, p11 := clampedPixel(in, x0, y0+1, w, h), clampedPixel(in, x0+1, y0+1, w, h)
+				for ch := 0; ch < 3; ch++ {
+					top := float64(p00[ch])*(1-ax) + float64(p10[ch])*ax
+					bot := float64(p01[ch])*(1-ax) + float64(p11[ch])*ax
+					v := (top*(1-ay) + bot*ay) * gain
+					if v > 255 {
+						v = 255
+					}
+					o[x*4+ch] = uint8(v + 0.5)
+				}
+				o[x*4+3] = 0xFF
+			}
+		}
+	})
+	return out
+}
+
+func (c *LensCorrection) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+		for img := range inc {
+			out := c.correct(img)
+			c.Pool.Put(img)
+			select {
+			case <-ctx.Done():
+				c.Pool.Put(out)
+				return
+			case outc <- out:
+			}
+		}
+	}()
+	return outc, errc
+}
+
+// ValidRegion implements RegionLimiter.
+func (c *LensCorrection) ValidRegion(size image.Point) image.Rectangle {
+	return c.Profile.ValidRegion(size)
+}
+
+// lensParams selects a preset, or gives a LensProfile directly.
+type lensParams struct {
+	Preset           string
+	K1, K2, Vignette float64
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name:      "lens",
+		Geometric: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			var p lensParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			profile := &LensProfile{K1: p.K1, K2: p.K2, Vignette: p.Vignette}
+			if p.Preset != "" {
+				if profile = GetLensPreset(p.Preset); profile == nil {
+					return nil, fmt.Errorf("unknown lens preset %v", p.Preset)
+				}
+			}
+			if err := profile.Validate(); err != nil {
+				return nil, err
+			}
+			return &LensCorrection{Profile: profile, Pool: env.Pool}, nil
+		},
+	})
+}
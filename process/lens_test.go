@@ -0,0 +1,72 @@
+package process
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestLensIdentity(t *testing.T) {
+	src := testPattern(64, 48)
+	c := LensCorrection{Profile: &LensProfile{}}
+	out := c.correct(src)
+	if !bytes.Equal(out.Pix, src.Pix) {
+		t.Errorf("zero coefficients changed the image")
+	}
+}
+
+func TestLensValidRegion(t *testing.T) {
+	size := image.Pt(400, 300)
+	for _, p := range LensPresets {
+		if err := p.Validate(); err != nil {
+			t.Errorf("preset %s: %v", p.Name, err)
+		}
+		// Barrel correction zooms in, so every pixel has a source.
+		if r := p.ValidRegion(size); r != image.Rect(0, 0, 400, 300) {
+			t.Errorf("preset %s: got valid region %v", p.Name, r)
+		}
+	}
+
+	pin := &LensProfile{K1: 0.2}
+	r := pin.ValidRegion(size)
+	if r.Empty() || r.Dx() >= 400 || r.Dy() >= 300 {
+		t.Fatalf("got valid region %v", r)
+	}
+	if r.Min.X+r.Max.X != 400 || r.Min.Y+r.Max.Y != 300 {
+		t.Errorf("valid region %v not centered", r)
+	}
+
+	// Everything inside the valid region has a source pixel, the corners don't.
+	src := image.NewRGBA(image.Rectangle{Max: size})
+	for i := range src.Pix {
+		src.Pix[i] = 100
+	}
+	out := (&LensCorrection{Profile: pin}).correct(src)
+	for _, p := range []image.Point{r.Min, r.Max.Sub(image.Pt(1, 1)), {r.Min.X, r.Max.Y - 1}} {
+		if out.RGBAAt(p.X, p.Y).A == 0 {
+			t.Errorf("pixel %v in valid region has no source", p)
+		}
+	}
+	if out.RGBAAt(0, 0).A != 0 {
+		t.Errorf("expected corner outside source")
+	}
+
+	if err := (&LensProfile{K1: -1, K2: 0}).Validate(); err == nil {
+		t.Errorf("expected folding coefficients to be rejected")
+	}
+}
+
+func TestLensVignette(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for i := range src.Pix {
+		src.Pix[i] = 100
+	}
+	out := (&LensCorrection{Profile: &LensProfile{Vignette: 0.5}}).correct(src)
+	center, corner := out.RGBAAt(20, 15).R, out.RGBAAt(0, 0).R
+	if center != 100 {
+		t.Errorf("got center %d, want unchanged", center)
+	}
+	if corner < 145 || corner > 150 {
+		t.Errorf("got corner %d, want ~150", corner)
+	}
+}
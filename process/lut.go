@@ -0,0 +1,334 @@
+package process
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LUT3D is a 3D color lookup table, as loaded from an Adobe/Resolve .cube file.
+type LUT3D struct {
+	Title string
+	Size  int
+	// DomainMin and DomainMax bound the input values covered by the table.
+	DomainMin, DomainMax [3]float64
+	// Table holds Size^3 RGB entries with red varying fastest.
+	Table [][3]float32
+}
+
+// ParseCube reads a .cube 3D LUT.
+func ParseCube(r io.Reader) (*LUT3D, error) {
+	lut := &LUT3D{
+		DomainMax: [3]float64{1, 1, 1},
+	}
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		fields := strings.Fields(l)
+		switch fields[0] {
+		case "TITLE":
+			lut.Title = strings.Trim(strings.TrimSpace(strings.TrimPrefix(l, "TITLE")), `"`)
+			continue
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: malformed LUT_3D_SIZE", line)
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 2 || n > 256 {
+				return nil, fmt.Errorf("line %d: invalid LUT_3D_SIZE %q", line, fields[1])
+			}
+			lut.Size = n
+			lut.Table = make([][3]float32, 0, n*n*n)
+			continue
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("1D LUTs are not supported")
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := parseTriple(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				lut.DomainMin = v
+			} else {
+				lut.DomainMax = v
+			}
+			continue
+		}
+
+		if lut.Size == 0 {
+			return nil, fmt.Errorf("line %d: table data before LUT_3D_SIZE", line)
+		}
+		v, err := parseTriple(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(lut.Table) == cap(lut.Table) {
+			return nil, fmt.Errorf("line %d: too many table entries", line)
+		}
+		lut.Table = append(lut.Table, [3]float32{float32(v[0]), float32(v[1]), float32(v[2])})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if lut.Size == 0 {
+		return nil, fmt.Errorf("missing LUT_3D_SIZE")
+	}
+	if want := lut.Size * lut.Size * lut.Size; len(lut.Table) != want {
+		return nil, fmt.Errorf("got %d table entries, want %d", len(lut.Table), want)
+	}
+	for c := 0; c < 3; c++ {
+		if lut.DomainMax[c] <= lut.DomainMin[c] {
+			return nil, fmt.Errorf("invalid domain")
+		}
+	}
+	return lut, nil
+}
+
+func parseTriple(fields []string) ([3]float64, error) {
+	var v [3]float64
+	if len(fields) != 3 {
+		return v, fmt.Errorf("expected 3 values, got %d", len(fields))
+	}
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// LoadCubeFile reads a .cube 3D LUT from disk.
+func LoadCubeFile(path string) (*LUT3D, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lut, err := ParseCube(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return lut, nil
+}
+
+// LUTInterpolations lists the supported LUT interpolation methods.
+var LUTInterpolations = []string{"trilinear", "tetrahedral"}
+
+// ValidLUTInterpolation returns whether the named interpolation is supported.
+// The empty string selects the default, trilinear.
+func ValidLUTInterpolation(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, n := range LUTInterpolations {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ColorLUT applies a 3D LUT to each frame, in place.
+type ColorLUT struct {
+	LUT *LUT3D
+	// Strength blends between the original (0) and graded (1) colors.
+	Strength float64
+	// Interpolation is the name of the interpolation method.
+	Interpolation string
+}
+
+// lutAxis maps each 8-bit channel value to a lattice cell and offset within it.
+type lutAxis struct {
+	idx  [256]int
+	frac [256]float32
+}
+
+func (c *ColorLUT) axes() [3]*lutAxis {
+	var axes [3]*lutAxis
+	n := c.LUT.Size
+	for ch := 0; ch < 3; ch++ {
+		a := &lutAxis{}
+		min, max := c.LUT.DomainMin[ch], c.LUT.DomainMax[ch]
+		for v := 0; v < 256; v++ {
+			x := (float64(v)/255 - min) / (max - min) * float64(n-1)
+			if x < 0 {
+				x = 0
+			}
+			if x > float64(n-1) {
+				x = float64(n - 1)
+			}
+			i := int(x)
+			if i >= n-1 {
+				i = n - 2
+			}
+			a.idx[v] = i
+			a.frac[v] = float32(x - float64(i))
+		}
+		axes[ch] = a
+	}
+	return axes
+}
+
+func (c *ColorLUT) at(r, g, b int) [3]float32 {
+	n := c.LUT.Size
+	return c.LUT.Table[r+n*(g+n*b)]
+}
+
+func lerp3(a, b [3]float32, t float32) [3]float32 {
+	return [3]float32{
+		a[0] + (b[0]-a[0])*t,
+		a[1] + (b[1]-a[1])*t,
+		a[2] + (b[2]-a[2])*t,
+	}
+}
+
+func (c *ColorLUT) trilinear(r, g, b int, fr, fg, fb float32) [3]float32 {
+	c00 := lerp3(c.at(r, g, b), c.at(r+1, g, b), fr)
+	c10 := lerp3(c.at(r, g+1, b), c.at(r+1, g+1, b), fr)
+	c01 := lerp3(c.at(r, g, b+1), c.at(r+1, g, b+1), fr)
+	c11 := lerp3(c.at(r, g+1, b+1), c.at(r+1, g+1, b+1), fr)
+	return lerp3(lerp3(c00, c10, fg), lerp3(c01, c11, fg), fb)
+}
+
+func (c *ColorLUT) tetrahedral(r, g, b int, fr, fg, fb float32) [3]float32 {
+	c000 := c.at(r, g, b)
+	c111 := c.at(r+1, g+1, b+1)
+	var v1, v2 [3]float32
+	var w0, w1, w2, w3 float32
+	switch {
+	case fr >= fg && fg >= fb:
+		v1, v2 = c.at(r+1, g, b), c.at(r+1, g+1, b)
+		w0, w1, w2, w3 = 1-fr, fr-fg, fg-fb, fb
+	case fr >= fb && fb >= fg:
+		v1, v2 = c.at(r+1, g, b), c.at(r+1, g, b+1)
+		w0, w1, w2, w3 = 1-fr, fr-fb, fb-fg, fg
+	case fb >= fr && fr >= fg:
+		v1, v2 = c.at(r, g, b+1), c.at(r+1, g, b+1)
+		w0, w1, w2, w3 = 1-fb, fb-fr, fr-fg, fg
+	case fg >= fr && fr >= fb:
+		v1, v2 = c.at(r, g+1, b), c.at(r+1, g+1, b)
+		w0, w1, w2, w3 = 1-fg, fg-fr, fr-fb, fb
+	case fg >= fb && fb >= fr:
+		v1, v2 = c.at(r, g+1, b), c.at(r, g+1, b+1)
+		w0, w1, w2, w3 = 1-fg, fg-fb, fb-fr, fr
+	default: // fb >= fg >= fr
+		v1, v2 = c.at(r, g, b+1), c.at(r, g+1, b+1)
+		w0, w1, w2, w3 = 1-fb, fb-fg, fg-fr, fr
+	}
+	var out [3]float32
+	for ch := 0; ch < 3; ch++ {
+		out[ch] = w0*c000[ch] + w1*v1[ch] + w2*v2[ch] + w3*c111[ch]
+	}
+	return out
+}
+
+func toByte(v float32) uint8 {
+	v = v*255 + 0.5
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// Apply grades img in place.
+func (c *ColorLUT) Apply(img *image.RGBA) {
+	axes := c.axes()
+	interp := c.trilinear
+	if c.Interpolation == "tetrahedral" {
+		interp = c.tetrahedral
+	}
+	strength := float32(c.Strength)
+	if strength <= 0 {
+		return
+	}
+	if strength > 1 {
+		strength = 1
+	}
+	rowLen := img.Rect.Dx() * 4
+
+	parallelRows(img.Rect.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+			row := img.Pix[o : o+rowLen]
+			for x := 0; x < len(row); x += 4 {
+				pr, pg, pb := row[x], row[x+1], row[x+2]
+				v := interp(axes[0].idx[pr], axes[1].idx[pg], axes[2].idx[pb],
+					axes[0].frac[pr], axes[1].frac[pg], axes[2].frac[pb])
+				for ch := 0; ch < 3; ch++ {
+					in := float32(row[x+ch]) / 255
+					row[x+ch] = toByte(in + (v[ch]-in)*strength)
+				}
+			}
+		}
+	})
+}
+
+func (c *ColorLUT) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+		for img := range inc {
+			c.Apply(img)
+			select {
+			case <-ctx.Done():
+				return
+			case outc <- img:
+			}
+		}
+	}()
+	return outc, errc
+}
+
+type lutParams struct {
+	// Path of the .cube file, relative to the file browser root.
+	Path          string
+	Strength      float64
+	Interpolation string
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name:    "lut",
+		Grading: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			p := lutParams{Strength: 1}
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			path, err := env.resolve(p.Path)
+			if err != nil {
+				return nil, err
+			}
+			lut, err := LoadCubeFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if p.Strength < 0 || p.Strength > 1 {
+				return nil, fmt.Errorf("strength must be between 0 and 1")
+			}
+			if !ValidLUTInterpolation(p.Interpolation) {
+				return nil, fmt.Errorf("invalid interpolation %v", p.Interpolation)
+			}
+			return &ColorLUT{
+				LUT:           lut,
+				Strength:      p.Strength,
+				Interpolation: p.Interpolation,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,93 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+)
+
+// identityCube builds an identity .cube LUT of the given size.
+func identityCube(n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TITLE \"identity\"\n# comment\nLUT_3D_SIZE %d\n", n)
+	for bl := 0; bl < n; bl++ {
+		for g := 0; g < n; g++ {
+			for r := 0; r < n; r++ {
+				d := float64(n - 1)
+				fmt.Fprintf(&b, "%f %f %f\n", float64(r)/d, float64(g)/d, float64(bl)/d)
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestColorLUTIdentity(t *testing.T) {
+	lut, err := ParseCube(strings.NewReader(identityCube(5)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if lut.Title != "identity" {
+		t.Errorf("got title %q", lut.Title)
+	}
+	for _, interp := range LUTInterpolations {
+		img := testPattern(31, 17)
+		want := append([]uint8(nil), img.Pix...)
+		c := &ColorLUT{LUT: lut, Interpolation: interp}
+		c.Apply(img)
+		for i := range want {
+			if d := int(img.Pix[i]) - int(want[i]); d < -1 || d > 1 {
+				t.Fatalf("%s: byte %d is %d, want %d", interp, i, img.Pix[i], want[i])
+			}
+		}
+	}
+}
+
+func TestColorLUTStrength(t *testing.T) {
+	// Inverts all colors.
+	lut, err := ParseCube(strings.NewReader(`LUT_3D_SIZE 2
+1 1 1
+0 1 1
+1 0 1
+0 0 1
+1 1 0
+0 1 0
+1 0 0
+0 0 0
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tests := []struct {
+		strength float64
+		want     []uint8
+	}{
+		{strength: 0, want: []uint8{0, 255, 100}},
+		{strength: 0.5, want: []uint8{128, 128, 128}},
+		{strength: 1, want: []uint8{255, 0, 155}},
+	}
+	for _, tc := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		copy(img.Pix, []uint8{0, 255, 100, 255})
+		c := &ColorLUT{LUT: lut, Strength: tc.strength}
+		c.Apply(img)
+		if got := img.Pix[:3]; !bytes.Equal(got, tc.want) {
+			t.Errorf("strength %v: got %v, want %v", tc.strength, got, tc.want)
+		}
+	}
+}
+
+func TestParseCubeErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"LUT_1D_SIZE 4\n",
+		"0 0 0\n",
+		"LUT_3D_SIZE 2\n0 0 0\n",
+		"LUT_3D_SIZE 2\n0 0\n",
+	} {
+		if _, err := ParseCube(strings.NewReader(in)); err == nil {
+			t.Errorf("expected error parsing %q", in)
+		}
+	}
+}
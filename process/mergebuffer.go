@@ -3,6 +3,8 @@ package process
 import (
 	"image"
 	"sort"
+
+	"timelapse-queue/util"
 )
 
 // Caches past image merges in order to significantly speed up overlap merging.
@@ -16,6 +18,9 @@ type BufferItem struct {
 
 type Buffer struct {
 	items []*BufferItem
+
+	// Pool provides merged frames and receives frames dropped from the buffer.
+	Pool *util.FramePool
 }
 
 func (b *Buffer) Add(frame int, img *image.RGBA) {
@@ -30,6 +35,7 @@ func (b *Buffer) RemoveOld(frame int) {
 	items := []*BufferItem{}
 	for _, item := range b.items {
 		if item.Frames[0] <= frame {
+			b.Pool.Put(item.Result)
 			continue
 		}
 		items = append(items, item)
@@ -125,7 +131,7 @@ func (b *Buffer) Generate(frames []int, merger Merger) *image.RGBA {
 		} else {
 			new := &BufferItem{
 				Frames: append(tail.Frames, item.Frames...),
-				Result: merger.Blend(item.Result, tail.Result),
+				Result: merger.Blend(item.Result, tail.Result, b.Pool),
 			}
 			b.items = append(b.items, new)
 			item = new
@@ -4,6 +4,8 @@ import (
 	"image"
 	"testing"
 
+	"timelapse-queue/util"
+
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -68,7 +70,7 @@ func TestSpanning(t *testing.T) {
 type fakeMerger struct {
 }
 
-func (f *fakeMerger) Blend(img1, img2 *image.RGBA) *image.RGBA {
+func (f *fakeMerger) Blend(img1, img2 *image.RGBA, pool *util.FramePool) *image.RGBA {
 	return nil
 }
 
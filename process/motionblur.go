@@ -0,0 +1,203 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+
+	"timelapse-queue/util"
+)
+
+// MotionBlur averages consecutive input frames into each output frame,
+// simulating a longer exposure. Unlike the Stacker, every frame in the window
+// contributes to the result rather than just the lightest or darkest pixel.
+type MotionBlur struct {
+	// Step is the number of input frames consumed per output frame; 0 or 1
+	// produces one output frame per input frame.
+	Step int
+	// Window is the number of input frames blended into each output frame,
+	// starting at that output's first input frame; 0 uses Step.
+	Window int
+	// Weighting is the name of the weighting applied across the window.
+	Weighting string
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
+}
+
+// BlurWeightings lists the supported motion blur weightings.
+var BlurWeightings = []string{"box", "triangle"}
+
+// ValidBlurWeighting returns whether the named weighting is supported. The
+// empty string selects the default box weighting.
+func ValidBlurWeighting(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, w := range BlurWeightings {
+		if w == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MotionBlur) step() int {
+	if m.Step < 1 {
+		return 1
+	}
+	return m.Step
+}
+
+func (m *MotionBlur) window() int {
+	if m.Window < 1 {
+		return m.step()
+	}
+	return m.Window
+}
+
+// weights returns fixed point weights (summing to 1<<16) for n frames.
+func (m *MotionBlur) weights(n int) []uint32 {
+	raw := make([]uint32, n)
+	var sum uint32
+	for j := range raw {
+		raw[j] = 1
+		if m.Weighting == "triangle" {
+			raw[j] = uint32(j + 1)
+			if r := uint32(n - j); r < raw[j] {
+				raw[j] = r
+			}
+		}
+		sum += raw[j]
+	}
+	var total uint32
+	for j := range raw {
+		raw[j] = raw[j] << 16 / sum
+		total += raw[j]
+	}
+	// Assign rounding error to the middle frame.
+	raw[n/2] += 1<<16 - total
+	return raw
+}
+
+// blend computes the weighted average of frames into a new frame.
+func (m *MotionBlur) blend(frames []*image.RGBA) *image.RGBA {
+	r := frames[0].Rect
+	out := m.Pool.Get(image.Rectangle{Max: r.Size()})
+	w := m.weights(len(frames))
+	rowLen := r.Dx() * 4
+
+	parallelRows(r.Dy(), func(y0, y1 int) {
+		acc := make([]uint32, rowLen)
+		for y := y0; y < y1; y++ {
+			for x := range acc {
+				acc[x] = 0
+			}
+			for j, f := range frames {
+				srow := f.Pix[f.PixOffset(f.Rect.Min.X, f.Rect.Min.Y+y):]
+				for x := range acc {
+					acc[x] += uint32(srow[x]) * w[j]
+				}
+			}
+			drow := out.Pix[y*out.Stride:]
+			for x, v := range acc {
+				drow[x] = uint8((v + 1<<15) >> 16)
+			}
+		}
+	})
+	return out
+}
+
+func (m *MotionBlur) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+		step, window := m.step(), m.window()
+
+		// Frames not yet released, starting at input frame index first.
+		var frames []*image.RGBA
+		first := 0
+		// Input frame index at which the next output frame's window starts.
+		next := 0
+
+		// emit blends the window for the next output frame, then drops frames
+		// no longer needed by later windows.
+		emit := func() bool {
+			end := next - first + window
+			if end > len(frames) {
+				end = len(frames)
+			}
+			out := m.blend(frames[next-first : end])
+			next += step
+			for first < next && len(frames) > 0 {
+				m.Pool.Put(frames[0])
+				frames = frames[1:]
+				first++
+			}
+			select {
+			case <-ctx.Done():
+				m.Pool.Put(out)
+				return false
+			case outc <- out:
+				return true
+			}
+		}
+
+		i := 0
+		for img := range inc {
+			if i < next {
+				// Only possible if the window is shorter than the step.
+				m.Pool.Put(img)
+				i++
+				first++
+				continue
+			}
+			frames = append(frames, img)
+			i++
+			if i == next+window {
+				if !emit() {
+					return
+				}
+			}
+		}
+		// Flush trailing output frames with partial windows.
+		for next < i {
+			if !emit() {
+				return
+			}
+		}
+	}()
+	return outc, errc
+}
+
+type motionBlurParams struct {
+	Window    int
+	Weighting string
+}
+
+func init() {
+	// The blur steps over the job's skip, blending the skipped frames.
+	RegisterStage(&StageType{
+		Name:         "motionblur",
+		ReadsSkipped: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			var p motionBlurParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Window < 0 || env.Frames > 0 && p.Window > env.Frames {
+				return nil, fmt.Errorf("window out of range")
+			}
+			if !ValidBlurWeighting(p.Weighting) {
+				return nil, fmt.Errorf("invalid weighting %v", p.Weighting)
+			}
+			return &MotionBlur{
+				Step:      env.Skip,
+				Window:    p.Window,
+				Weighting: p.Weighting,
+				Pool:      env.Pool,
+			}, nil
+		},
+	})
+}
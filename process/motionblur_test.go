@@ -0,0 +1,71 @@
+package process
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// solidFrame returns a small frame with every byte set to v.
+func solidFrame(v uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+	return img
+}
+
+func TestMotionBlur(t *testing.T) {
+	tests := []struct {
+		name  string
+		blur  MotionBlur
+		input []uint8
+		want  []uint8
+	}{
+		{
+			name:  "step",
+			blur:  MotionBlur{Step: 3},
+			input: []uint8{0, 30, 60, 90, 120, 150, 180},
+			want:  []uint8{30, 120, 180},
+		},
+		{
+			name:  "overlapping window",
+			blur:  MotionBlur{Step: 1, Window: 2},
+			input: []uint8{0, 10, 20},
+			want:  []uint8{5, 15, 20},
+		},
+		{
+			name:  "short window",
+			blur:  MotionBlur{Step: 3, Window: 2},
+			input: []uint8{0, 10, 100, 30, 40, 100, 60},
+			want:  []uint8{5, 35, 60},
+		},
+		{
+			name:  "triangle",
+			blur:  MotionBlur{Step: 3, Weighting: "triangle"},
+			input: []uint8{0, 100, 200},
+			want:  []uint8{100},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inc := make(chan *image.RGBA)
+			go func() {
+				defer close(inc)
+				for _, v := range test.input {
+					inc <- solidFrame(v)
+				}
+			}()
+			outc, _ := test.blur.Process(context.Background(), inc, make(chan error, 1))
+			got := []uint8{}
+			for img := range outc {
+				got = append(got, img.Pix[0])
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("blurred frames diff: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"timelapse-queue/util"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Anchor positions for overlay text and watermarks.
+var OverlayPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+const DefaultOverlayPosition = "bottom-right"
+
+// Built-in fonts, selectable by name instead of a font file path.
+var builtinFonts = map[string][]byte{
+	"regular": goregular.TTF,
+	"bold":    gobold.TTF,
+	"mono":    gomono.TTF,
+}
+
+// BuiltinFont reports whether name refers to one of the built-in fonts.
+func BuiltinFont(name string) bool {
+	_, ok := builtinFonts[name]
+	return name == "" || ok
+}
+
+func ValidOverlayPosition(p string) bool {
+	if p == "" {
+		return true
+	}
+	for _, v := range OverlayPositions {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// OverlayInfo is the data available to overlay text templates.
+type OverlayInfo struct {
+	// Index is the output frame number.
+	Index int
+	// Frame is the index of the source image in the timelapse.
+	Frame int
+	// Time is the capture time of the source image, if known.
+	Time time.Time
+	// Name is the name of the timelapse.
+	Name string
+}
+
+// ParseOverlayText parses an overlay text template, e.g.
+// `{{.Time.Format "2006-01-02 15:04"}}`.
+func ParseOverlayText(text string) (*template.Template, error) {
+	return template.New("overlay").Option("missingkey=error").Parse(text)
+}
+
+// LoadFont loads either a built-in font by name ("regular", "bold", "mono") or
+// a TrueType/OpenType font file, at the given size in pixels.
+func LoadFont(name string, size float64) (font.Face, error) {
+	data, ok := builtinFonts[name]
+	if name == "" {
+		data, ok = goregular.TTF, true
+	}
+	if !ok {
+		var err error
+		if data, err = ioutil.ReadFile(name); err != nil {
+			return nil, err
+		}
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font: %v", err)
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// ParseColor parses a hex color, #RGB, #RRGGBB or #RRGGBBAA.
+func ParseColor(s string) (color.NRGBA, error) {
+	h := strings.TrimPrefix(s, "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	if len(h) == 6 {
+		h += "ff"
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if len(h) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// LoadWatermark reads a PNG image, scaled to the given width (if non-zero)
+// preserving its aspect ratio.
+func LoadWatermark(path string, width int) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode watermark: %v", err)
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Rect, src, b.Min, draw.Src)
+	if width <= 0 || width == b.Dx() {
+		return img, nil
+	}
+	h := b.Dy() * width / b.Dx()
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, h))
+	resample(dst, img, Lanczos3, nil)
+	return dst, nil
+}
+
+// anchor places a box of the given size within bounds at the named position.
+func anchor(bounds image.Rectangle, size image.Point, position string, margin int) image.Point {
+	inner := bounds.Inset(margin)
+	var p image.Point
+	switch {
+	case position == "center":
+		p = inner.Min.Add(inner.Size().Sub(size).Div(2))
+	case strings.HasPrefix(position, "top"):
+		p.Y = inner.Min.Y
+	default:
+		p.Y = inner.Max.Y - size.Y
+	}
+	switch {
+	case position == "center":
+	case strings.HasSuffix(position, "left"):
+		p.X = inner.Min.X
+	default:
+		p.X = inner.Max.X - size.X
+	}
+	return p
+}
+
+// Overlay burns text and a watermark image into each frame, in place.
+type Overlay struct {
+	// Text is rendered with the frame's OverlayInfo. May be nil for no text.
+	Text *template.Template
+	Face font.Face
+	// Position of the text, one of OverlayPositions.
+	Position string
+	// Margin is the distance, in pixels, from the edge of the frame.
+	Margin int
+	Color  color.NRGBA
+	// Background is drawn behind the text, extending Padding pixels around it.
+	// Fully transparent disables the box.
+	Background color.NRGBA
+	Padding    int
+
+	// Watermark is drawn at WatermarkPosition with the given opacity, from 0
+	// (invisible) to 1. May be nil for no watermark.
+	Watermark         *image.RGBA
+	WatermarkOpacity  float64
+	WatermarkPosition string
+
+	// Info returns the template data for output frame n.
+	Info func(n int) OverlayInfo
+
+	// Pool receives frames dropped on cancel or error.
+	Pool *util.FramePool
+}
+
+func (o *Overlay) drawWatermark(img *image.RGBA) {
+	if o.WatermarkOpacity <= 0 {
+		return
+	}
+	alpha := uint8(255)
+	if o.WatermarkOpacity < 1 {
+		alpha = uint8(o.WatermarkOpacity*255 + 0.5)
+	}
+	p := anchor(img.Rect, o.Watermark.Rect.Size(), o.WatermarkPosition, o.Margin)
+	r := image.Rectangle{Min: p, Max: p.Add(o.Watermark.Rect.Size())}
+	draw.DrawMask(img, r, o.Watermark, image.Point{}, image.NewUniform(color.Alpha{A: alpha}), image.Point{}, draw.Over)
+}
+
+func (o *Overlay) drawText(img *image.RGBA, text string) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	m := o.Face.Metrics()
+	lineHeight := m.Height.Ceil()
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(o.Color),
+		Face: o.Face,
+	}
+	width := 0
+	for _, l := range lines {
+		if w := d.MeasureString(l).Ceil(); w > width {
+			width = w
+		}
+	}
+	size := image.Point{X: width + 2*o.Padding, Y: lineHeight*len(lines) + 2*o.Padding}
+	box := image.Rectangle{Min: anchor(img.Rect, size, o.Position, o.Margin)}
+	box.Max = box.Min.Add(size)
+
+	if o.Background.A > 0 {
+		draw.Draw(img, box, image.NewUniform(o.Background), image.Point{}, draw.Over)
+	}
+	for i, l := range lines {
+		x := box.Min.X + o.Padding
+		if o.Position == "center" {
+			x += (width - d.MeasureString(l).Ceil()) / 2
+		} else if strings.HasSuffix(o.Position, "right") {
+			x += width - d.MeasureString(l).Ceil()
+		}
+		y := box.Min.Y + o.Padding + i*lineHeight + m.Ascent.Ceil()
+		d.Dot = fixed.P(x, y)
+		d.DrawString(l)
+	}
+}
+
+// Apply draws the overlay for output frame n onto img.
+func (o *Overlay) Apply(img *image.RGBA, n int) error {
+	if o.Watermark != nil {
+		o.drawWatermark(img)
+	}
+	if o.Text == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := o.Text.Execute(&b, o.Info(n)); err != nil {
+		return fmt.Errorf("overlay text: %v", err)
+	}
+	if s := strings.TrimSpace(b.String()); s != "" {
+		o.drawText(img, s)
+	}
+	return nil
+}
+
+func (o *Overlay) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	errout := make(chan error, 1)
+	go func() {
+		defer close(outc)
+		defer close(errout)
+		n := 0
+		var aerr error
+		for img := range inc {
+			if aerr != nil {
+				// Drain the stream so upstream stages can exit.
+				o.Pool.Put(img)
+				continue
+			}
+			if aerr = o.Apply(img, n); aerr != nil {
+				o.Pool.Put(img)
+				continue
+			}
+			n++
+			select {
+			case <-ctx.Done():
+				o.Pool.Put(img)
+				return
+			case outc <- img:
+			}
+		}
+		// Upstream errors are reported after all of its frames, so a failure
+		// to apply the overlay came first.
+		if err := <-errc; err != nil && aerr == nil {
+			aerr = err
+		}
+		if aerr != nil {
+			errout <- aerr
+		}
+	}()
+	return outc, errout
+}
+
+type overlayParams struct {
+	// Text is a template rendered with OverlayInfo, see ParseOverlayText.
+	Text string
+	// Font is a built-in font name or a font file relative to the file browser
+	// root. Size is in output pixels; zero is relative to the output height.
+	Font              string
+	Size              float64
+	Position          string
+	Color, Background string
+
+	// Watermark is a PNG image relative to the file browser root. Width is in
+	// output pixels; zero keeps the image's own size.
+	Watermark         string
+	WatermarkOpacity  float64
+	WatermarkWidth    int
+	WatermarkPosition string
+}
+
+func newOverlay(p *overlayParams, env *StageEnv) (*Overlay, error) {
+	out := env.Output
+	size := p.Size
+	if size == 0 {
+		size = float64(out.Y) / 24
+	}
+	if size < 4 || size > float64(out.Y)/2 {
+		return nil, fmt.Errorf("size must be between 4 and %d", out.Y/2)
+	}
+	if !ValidOverlayPosition(p.Position) || !ValidOverlayPosition(p.WatermarkPosition) {
+		return nil, fmt.Errorf("invalid position")
+	}
+	o := &Overlay{
+		Pool:              env.Pool,
+		Position:          p.Position,
+		Margin:            int(size / 2),
+		Color:             color.NRGBA{R: 255, G: 255, B: 255, A: 255},
+		Padding:           int(size / 4),
+		WatermarkOpacity:  p.WatermarkOpacity,
+		WatermarkPosition: p.WatermarkPosition,
+	}
+	if o.Position == "" {
+		o.Position = DefaultOverlayPosition
+	}
+	if o.WatermarkPosition == "" {
+		o.WatermarkPosition = "top-right"
+	}
+
+	if p.Text != "" {
+		t, err := ParseOverlayText(p.Text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid text: %v", err)
+		}
+		// Field errors are only reported on execution.
+		if err := t.Execute(ioutil.Discard, OverlayInfo{}); err != nil {
+			return nil, fmt.Errorf("invalid text: %v", err)
+		}
+		o.Text = t
+		font := p.Font
+		if !BuiltinFont(font) {
+			if font, err = env.resolve(font); err != nil {
+				return nil, fmt.Errorf("font file: %v", err)
+			}
+		}
+		if o.Face, err = LoadFont(font, size); err != nil {
+			return nil, fmt.Errorf("invalid font: %v", err)
+		}
+		if p.Color != "" {
+			if o.Color, err = ParseColor(p.Color); err != nil {
+				return nil, err
+			}
+		}
+		if p.Background != "" {
+			if o.Background, err = ParseColor(p.Background); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.Watermark != "" {
+		if p.WatermarkOpacity < 0 || p.WatermarkOpacity > 1 {
+			return nil, fmt.Errorf("watermark opacity must be between 0 and 1")
+		}
+		if p.WatermarkWidth < 0 || p.WatermarkWidth > out.X {
+			return nil, fmt.Errorf("watermark width must be between 0 and %d", out.X)
+		}
+		path, err := env.resolve(p.Watermark)
+		if err != nil {
+			return nil, fmt.Errorf("watermark file: %v", err)
+		}
+		wm, err := LoadWatermark(path, p.WatermarkWidth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watermark: %v", err)
+		}
+		if wm.Rect.Dx() > out.X || wm.Rect.Dy() > out.Y {
+			return nil, fmt.Errorf("watermark larger than output")
+		}
+		o.Watermark = wm
+	}
+
+	o.Info = func(n int) OverlayInfo {
+		oi := OverlayInfo{Index: n, Frame: n, Name: env.Name}
+		if env.SourceFrame != nil {
+			oi.Frame = env.SourceFrame(n)
+		}
+		if env.FrameTime != nil {
+			oi.Time = env.FrameTime(oi.Frame)
+		}
+		return oi
+	}
+	return o, nil
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name: "overlay",
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			p := overlayParams{WatermarkOpacity: 1}
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			return newOverlay(&p, env)
+		},
+	})
+}
@@ -0,0 +1,190 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"timelapse-queue/util"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.NRGBA
+	}{
+		{"#fff", color.NRGBA{255, 255, 255, 255}},
+		{"#102030", color.NRGBA{0x10, 0x20, 0x30, 255}},
+		{"10203080", color.NRGBA{0x10, 0x20, 0x30, 0x80}},
+	}
+	for _, tc := range tests {
+		got, err := ParseColor(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseColor(%q) = %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "#12", "#gggggg", "#1234567"} {
+		if _, err := ParseColor(bad); err == nil {
+			t.Errorf("ParseColor(%q) expected error", bad)
+		}
+	}
+}
+
+// inkBounds returns the bounds of pixels that differ from v.
+func inkBounds(img *image.RGBA, v uint8) image.Rectangle {
+	var r image.Rectangle
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if img.RGBAAt(x, y).R != v {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+func TestOverlayText(t *testing.T) {
+	face, err := LoadFont("mono", 16)
+	if err != nil {
+		t.Fatalf("load font: %v", err)
+	}
+	text, err := ParseOverlayText(`{{.Time.Format "2006-01-02"}} #{{.Frame}}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	var got OverlayInfo
+	for _, pos := range OverlayPositions {
+		o := Overlay{
+			Text:     text,
+			Face:     face,
+			Position: pos,
+			Margin:   4,
+			Color:    color.NRGBA{255, 255, 255, 255},
+			Info: func(n int) OverlayInfo {
+				got = OverlayInfo{Index: n, Frame: 10 + n, Time: time.Date(2021, 6, 5, 0, 0, 0, 0, time.UTC)}
+				return got
+			},
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 320, 120))
+		if err := o.Apply(img, 2); err != nil {
+			t.Fatalf("%s: apply: %v", pos, err)
+		}
+		if got.Frame != 12 {
+			t.Errorf("%s: got info for frame %d", pos, got.Frame)
+		}
+
+		ink := inkBounds(img, 0)
+		if ink.Empty() {
+			t.Fatalf("%s: no text drawn", pos)
+		}
+		mid := img.Rect.Max.Div(2)
+		switch pos {
+		case "top-left":
+			if ink.Max.X > mid.X || ink.Max.Y > mid.Y || ink.Min.X < 4 || ink.Min.Y < 4 {
+				t.Errorf("%s: text at %v", pos, ink)
+			}
+		case "bottom-right":
+			if ink.Min.X < mid.X || ink.Min.Y < mid.Y || ink.Max.X > 316 || ink.Max.Y > 116 {
+				t.Errorf("%s: text at %v", pos, ink)
+			}
+		case "center":
+			if !ink.Overlaps(image.Rectangle{Min: mid, Max: mid.Add(image.Pt(1, 1))}) {
+				t.Errorf("%s: text at %v", pos, ink)
+			}
+		}
+	}
+}
+
+func TestOverlayWatermark(t *testing.T) {
+	wm := image.NewRGBA(image.Rect(0, 0, 10, 5))
+	for i := range wm.Pix {
+		wm.Pix[i] = 200
+	}
+	o := Overlay{
+		Watermark:         wm,
+		WatermarkOpacity:  0.5,
+		WatermarkPosition: "top-left",
+		Margin:            2,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	if err := o.Apply(img, 0); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if ink := inkBounds(img, 0); ink != image.Rect(2, 2, 12, 7) {
+		t.Errorf("watermark drawn at %v", ink)
+	}
+	if c := img.RGBAAt(5, 5); c.R < 99 || c.R > 101 {
+		t.Errorf("got watermark pixel %v, want half opacity", c)
+	}
+	o.WatermarkOpacity = 0
+	img = image.NewRGBA(image.Rect(0, 0, 40, 20))
+	if err := o.Apply(img, 0); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if ink := inkBounds(img, 0); !ink.Empty() {
+		t.Errorf("zero opacity watermark drawn at %v", ink)
+	}
+}
+
+func TestOverlayProcessErrors(t *testing.T) {
+	upstream := errors.New("read failed")
+	failing := template.Must(template.New("").Funcs(template.FuncMap{
+		"fail": func() (string, error) { return "", errors.New("no time") },
+	}).Parse("{{fail}}"))
+
+	for _, tc := range []struct {
+		name       string
+		text       *template.Template
+		wantFrames int
+		wantErr    string
+	}{
+		{name: "upstream", wantFrames: 3, wantErr: "read failed"},
+		{name: "apply", text: failing, wantErr: "overlay text"},
+	} {
+		pool := util.NewFramePool()
+		bufs := make(map[*uint8]bool)
+		var imgs []*image.RGBA
+		for i := 0; i < 3; i++ {
+			img := pool.Get(image.Rect(0, 0, 8, 8))
+			bufs[&img.Pix[0]] = true
+			imgs = append(imgs, img)
+		}
+		inc := make(chan *image.RGBA)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(errc)
+			defer close(inc)
+			for _, img := range imgs {
+				inc <- img
+			}
+			errc <- upstream
+		}()
+
+		o := &Overlay{Text: tc.text, Info: func(int) OverlayInfo { return OverlayInfo{} }, Pool: pool}
+		outc, errout := o.Process(context.Background(), inc, errc)
+		frames := 0
+		for img := range outc {
+			frames++
+			pool.Put(img)
+		}
+		err := <-errout
+		if frames != tc.wantFrames {
+			t.Errorf("%s: got %d frames, want %d", tc.name, frames, tc.wantFrames)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: Process() error = %v, want %q", tc.name, err, tc.wantErr)
+		}
+		// Every frame read, delivered or dropped, is back in the pool.
+		for i := 0; i < 3; i++ {
+			if img := pool.Get(image.Rect(0, 0, 8, 8)); !bufs[&img.Pix[0]] {
+				t.Errorf("%s: frame %d not released", tc.name, i)
+			}
+		}
+	}
+}
@@ -1,9 +1,20 @@
 package process
 
 import (
+	"context"
 	"image"
 )
 
+// Process is a pipeline stage, transforming a stream of frames. Errors from
+// upstream are passed along on the returned error channel.
 type Process interface {
-	Process(<-chan *image.RGBA, chan error) (<-chan *image.RGBA, chan error)
+	Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error)
+}
+
+// RegionLimiter is implemented by stages which may leave parts of the frame
+// without image data, such as the edges after lens correction.
+type RegionLimiter interface {
+	// ValidRegion returns the part of an output frame of the given size which
+	// holds image data.
+	ValidRegion(size image.Point) image.Rectangle
 }
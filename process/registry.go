@@ -0,0 +1,272 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"sort"
+	"time"
+
+	"timelapse-queue/util"
+)
+
+// StageSpec is one step of a job's filter chain: the name of a registered
+// stage type and its JSON encoded parameters.
+type StageSpec struct {
+	Type   string
+	Params json.RawMessage `json:",omitempty"`
+}
+
+// NewStageSpec encodes params into a spec for the named stage type.
+func NewStageSpec(typ string, params interface{}) StageSpec {
+	s := StageSpec{Type: typ}
+	if params != nil {
+		// Params are plain structs, which always encode.
+		s.Params, _ = json.Marshal(params)
+	}
+	return s
+}
+
+// StageEnv describes the job a stage is built for.
+type StageEnv struct {
+	// Output is the output resolution.
+	Output image.Point
+	// Region and Rotate are the job's crop region and rotation in degrees.
+	Region image.Rectangle
+	Rotate int
+	// Source is the expected size of frames before cropping, if frames may be
+	// decoded at reduced size. See Crop.Source.
+	Source image.Point
+	// Filter is the default resampling filter name.
+	Filter string
+
+	// Frames is the number of frames in the job's range of the sequence, and
+	// Skip the number of frames advanced per frame read. Frames is zero if
+	// unknown, e.g. for previews.
+	Frames, Skip int
+
+	// SourceFrame maps the index of a frame in the stage's input stream to the
+	// index of the timelapse image it came from.
+	SourceFrame func(n int) int
+	// FrameTime returns the capture time of a timelapse image.
+	FrameTime func(idx int) time.Time
+	// Name is the name of the timelapse.
+	Name string
+
+	// Resolve returns the absolute path of an input file, given relative to
+	// the file browser root.
+	Resolve func(path string) (string, error)
+
+	// Pool provides frames for the stage. May be nil.
+	Pool *util.FramePool
+}
+
+func (e *StageEnv) resolve(path string) (string, error) {
+	if e.Resolve == nil {
+		return "", fmt.Errorf("input files not resolved")
+	}
+	return e.Resolve(path)
+}
+
+// StageType is a kind of processing stage that can be used in a filter chain.
+type StageType struct {
+	Name string
+	// New builds and validates a stage from its parameters.
+	New func(params json.RawMessage, env *StageEnv) (Process, error)
+
+	// ReadsSkipped is set for stages which make use of the frames that would
+	// otherwise be skipped, so the full sequence must be read. Each output
+	// frame n is derived from input frames from n*Skip on.
+	ReadsSkipped bool
+	// Crop and Resize are set for the stages cropping frames to the job's
+	// region and scaling them to the output size. Chains crop, then resize,
+	// exactly once.
+	Crop, Resize bool
+	// Geometric is set for stages which only move pixels, so frames may be
+	// decoded at reduced size when only geometric stages precede the crop.
+	Geometric bool
+	// JobGeometry is set for stages applying the job's own rotation, region
+	// and output size. Jobs which don't process frames, such as renames,
+	// ignore them.
+	JobGeometry bool
+	// Grading is set for color grading stages, left out when previewing the
+	// frame before grading.
+	Grading bool
+	// InMetadata is set for stages whose parameters are recorded in output
+	// metadata.
+	InMetadata bool
+	// Frames, if set, returns the number of frames output for n input frames.
+	Frames func(params json.RawMessage, n int) int
+	// SourceFrame, if set, maps an output frame index to the input frame it
+	// was derived from.
+	SourceFrame func(params json.RawMessage, n int) int
+}
+
+var stageTypes = map[string]*StageType{}
+
+// RegisterStage makes a stage type available to filter chains.
+func RegisterStage(t *StageType) {
+	if _, ok := stageTypes[t.Name]; ok {
+		panic(fmt.Sprintf("stage %v registered twice", t.Name))
+	}
+	stageTypes[t.Name] = t
+}
+
+func GetStageByName(name string) *StageType {
+	return stageTypes[name]
+}
+
+// StageNames lists the registered stage types.
+func StageNames() []string {
+	var names []string
+	for n := range stageTypes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeParams strictly decodes stage parameters into v. Empty parameters
+// leave v unchanged.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	d := json.NewDecoder(bytes.NewReader(params))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+// NewStages builds the stages of a filter chain, in order. Each stage's env has
+// SourceFrame adjusted for the stages before it; env.SourceFrame maps the frames
+// as read, which is every frame if the chain reads skipped frames.
+func NewStages(specs []StageSpec, env StageEnv) ([]Process, error) {
+	if env.Skip < 1 {
+		env.Skip = 1
+	}
+	var stages []Process
+	skipping := false
+	for i, spec := range specs {
+		t := GetStageByName(spec.Type)
+		if t == nil {
+			return nil, fmt.Errorf("stage %d: unknown stage type %q", i, spec.Type)
+		}
+		if t.ReadsSkipped {
+			if skipping {
+				return nil, fmt.Errorf("only one stage may read skipped frames")
+			}
+			skipping = true
+		}
+		senv := env
+		p, err := t.New(spec.Params, &senv)
+		if err != nil {
+			return nil, fmt.Errorf("%s stage: %v", spec.Type, err)
+		}
+		stages = append(stages, p)
+
+		if env.SourceFrame == nil {
+			continue
+		}
+		if t.ReadsSkipped {
+			prev, skip := env.SourceFrame, env.Skip
+			env.SourceFrame = func(n int) int {
+				return prev(n * skip)
+			}
+		}
+		if t.SourceFrame != nil {
+			prev, params := env.SourceFrame, spec.Params
+			env.SourceFrame = func(n int) int {
+				return prev(t.SourceFrame(params, n))
+			}
+		}
+	}
+	return stages, nil
+}
+
+// CheckChainGeometry checks that a filter chain crops to the job's region and
+// then resizes to the output size, once each.
+func CheckChainGeometry(specs []StageSpec) error {
+	crop, resize := -1, -1
+	for i, spec := range specs {
+		t := GetStageByName(spec.Type)
+		if t == nil {
+			return fmt.Errorf("stage %d: unknown stage type %q", i, spec.Type)
+		}
+		if t.Crop {
+			if crop >= 0 {
+				return fmt.Errorf("stage %d: only one %v stage allowed", i, spec.Type)
+			}
+			crop = i
+		}
+		if t.Resize {
+			if resize >= 0 {
+				return fmt.Errorf("stage %d: only one %v stage allowed", i, spec.Type)
+			}
+			resize = i
+		}
+	}
+	if crop < 0 || resize < 0 {
+		return fmt.Errorf("stages must include crop and resize")
+	}
+	if resize < crop {
+		return fmt.Errorf("stages must crop before resizing")
+	}
+	return nil
+}
+
+// ScalableChain returns whether frames may be decoded at reduced size, i.e.
+// the chain only has geometric stages before the crop.
+func ScalableChain(specs []StageSpec) bool {
+	for _, spec := range specs {
+		t := GetStageByName(spec.Type)
+		switch {
+		case t == nil:
+			return false
+		case t.Crop:
+			return true
+		case !t.Geometric:
+			return false
+		}
+	}
+	return false
+}
+
+// ChainFrames returns the number of frames output by a filter chain for n
+// input frames.
+func ChainFrames(specs []StageSpec, n int) int {
+	for _, spec := range specs {
+		if t := GetStageByName(spec.Type); t != nil && t.Frames != nil {
+			n = t.Frames(spec.Params, n)
+		}
+	}
+	return n
+}
+
+// ChainSourceFrame maps the index of a frame output by a filter chain to the
+// input frame it was derived from, given the job's skip.
+func ChainSourceFrame(specs []StageSpec, skip, n int) int {
+	for i := len(specs) - 1; i >= 0; i-- {
+		t := GetStageByName(specs[i].Type)
+		if t == nil {
+			continue
+		}
+		if t.SourceFrame != nil {
+			n = t.SourceFrame(specs[i].Params, n)
+		}
+		if t.ReadsSkipped {
+			n *= skip
+		}
+	}
+	return n
+}
+
+// ChainReadsSkipped returns whether any stage uses skipped frames.
+func ChainReadsSkipped(specs []StageSpec) bool {
+	for _, spec := range specs {
+		if t := GetStageByName(spec.Type); t != nil && t.ReadsSkipped {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package process
+
+import (
+	"encoding/json"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestNewStages(t *testing.T) {
+	env := StageEnv{
+		Output: image.Point{X: 16, Y: 9},
+		Region: image.Rect(0, 0, 32, 18),
+		Frames: 100,
+	}
+	tests := []struct {
+		name    string
+		specs   []StageSpec
+		wantErr string
+	}{
+		{
+			name: "valid",
+			specs: []StageSpec{
+				{Type: "crop"},
+				{Type: "resize", Params: json.RawMessage(`{"Filter": "bilinear"}`)},
+				NewStageSpec("stack", stackParams{Window: 10, Mode: "lighten"}),
+			},
+		},
+		{
+			name:    "unknown type",
+			specs:   []StageSpec{{Type: "sharpen"}},
+			wantErr: "unknown stage type",
+		},
+		{
+			name:    "unknown param",
+			specs:   []StageSpec{{Type: "resize", Params: json.RawMessage(`{"Size": 2}`)}},
+			wantErr: "resize stage: json: unknown field",
+		},
+		{
+			name:    "invalid param",
+			specs:   []StageSpec{NewStageSpec("stack", stackParams{Window: 200, Mode: "lighten"})},
+			wantErr: "stack stage: window out of range",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stages, err := NewStages(tc.specs, env)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("NewStages() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStages() error = %v", err)
+			}
+			if len(stages) != len(tc.specs) {
+				t.Errorf("NewStages() built %d stages, want %d", len(stages), len(tc.specs))
+			}
+		})
+	}
+}
+
+func TestStageSourceFrame(t *testing.T) {
+	env := StageEnv{
+		SourceFrame: func(n int) int { return 10 + 3*n },
+	}
+	specs := []StageSpec{
+		NewStageSpec("crossfade", crossfadeParams{Factor: 2}),
+		NewStageSpec("adjust", adjustParams{}),
+	}
+	stages, err := NewStages(specs, env)
+	if err != nil {
+		t.Fatalf("NewStages() error = %v", err)
+	}
+	a := stages[1].(*Adjust)
+	// Output frame 5 is crossfaded from input frame 2, read from image 16.
+	if got := a.SourceFrame(5); got != 16 {
+		t.Errorf("SourceFrame(5) = %d, want 16", got)
+	}
+	if got := ChainSourceFrame(specs, 3, 5); got != 2 {
+		t.Errorf("ChainSourceFrame(5) = %d, want 2", got)
+	}
+}
+
+func TestStageSourceFrameReadsSkipped(t *testing.T) {
+	// Frames are read one by one for motion blur with a skip of 3.
+	env := StageEnv{
+		Skip:        3,
+		SourceFrame: func(n int) int { return 10 + n },
+	}
+	specs := []StageSpec{
+		NewStageSpec("adjust", adjustParams{}),
+		NewStageSpec("motionblur", motionBlurParams{}),
+		NewStageSpec("crossfade", crossfadeParams{Factor: 2}),
+		NewStageSpec("adjust", adjustParams{}),
+	}
+	stages, err := NewStages(specs, env)
+	if err != nil {
+		t.Fatalf("NewStages() error = %v", err)
+	}
+	if got := stages[0].(*Adjust).SourceFrame(4); got != 14 {
+		t.Errorf("before blur SourceFrame(4) = %d, want 14", got)
+	}
+	// Output frame 5 is crossfaded from blurred frame 2, starting at read
+	// frame 6.
+	if got := stages[3].(*Adjust).SourceFrame(5); got != 16 {
+		t.Errorf("after blur SourceFrame(5) = %d, want 16", got)
+	}
+	if got := ChainSourceFrame(specs, 3, 5); got != 6 {
+		t.Errorf("ChainSourceFrame(5) = %d, want 6", got)
+	}
+
+	twice := append(specs, NewStageSpec("motionblur", motionBlurParams{}))
+	if _, err := NewStages(twice, env); err == nil {
+		t.Errorf("NewStages() with two motion blur stages succeeded")
+	}
+}
+
+func TestCheckChainGeometry(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   []string
+		wantErr bool
+	}{
+		{name: "default", types: []string{"rotate", "crop", "resize", "adjust"}},
+		{name: "processing before crop", types: []string{"denoise", "crop", "adjust", "resize"}},
+		{name: "no crop", types: []string{"resize"}, wantErr: true},
+		{name: "no resize", types: []string{"crop", "adjust"}, wantErr: true},
+		{name: "resize first", types: []string{"resize", "crop"}, wantErr: true},
+		{name: "two crops", types: []string{"crop", "crop", "resize"}, wantErr: true},
+		{name: "unknown", types: []string{"crop", "resize", "sharpen"}, wantErr: true},
+	}
+	for _, tc := range tests {
+		var specs []StageSpec
+		for _, typ := range tc.types {
+			specs = append(specs, StageSpec{Type: typ})
+		}
+		if err := CheckChainGeometry(specs); (err != nil) != tc.wantErr {
+			t.Errorf("%s: CheckChainGeometry() error = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestScalableChain(t *testing.T) {
+	tests := []struct {
+		types []string
+		want  bool
+	}{
+		{types: []string{"crop", "resize"}, want: true},
+		{types: []string{"lens", "rotate", "crop", "denoise", "resize"}, want: true},
+		{types: []string{"denoise", "crop", "resize"}, want: false},
+		{types: []string{"rotate"}, want: false},
+	}
+	for _, tc := range tests {
+		var specs []StageSpec
+		for _, typ := range tc.types {
+			specs = append(specs, StageSpec{Type: typ})
+		}
+		if got := ScalableChain(specs); got != tc.want {
+			t.Errorf("ScalableChain(%v) = %v, want %v", tc.types, got, tc.want)
+		}
+	}
+}
+
+func TestChainFrames(t *testing.T) {
+	specs := []StageSpec{
+		NewStageSpec("stack", stackParams{Window: 4, Mode: "lighten"}),
+		NewStageSpec("crossfade", crossfadeParams{Factor: 2}),
+	}
+	if got, want := ChainFrames(specs, 10), CrossfadeFrames(14, 2); got != want {
+		t.Errorf("ChainFrames() = %d, want %d", got, want)
+	}
+	if ChainReadsSkipped(specs) {
+		t.Errorf("ChainReadsSkipped() = true, want false")
+	}
+	if !ChainReadsSkipped(append(specs, StageSpec{Type: "motionblur"})) {
+		t.Errorf("ChainReadsSkipped() with motion blur = false, want true")
+	}
+}
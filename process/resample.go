@@ -0,0 +1,250 @@
+package process
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+
+	"timelapse-queue/util"
+)
+
+// Fixed point precision of the precomputed kernel weights.
+const weightBits = 14
+
+// Filter is a resampling kernel used by the Resizer.
+type Filter struct {
+	Name string
+	// Support is the kernel radius, in source pixels, when not downscaling.
+	Support float64
+	// Kernel returns the weight of a sample at distance x. A nil kernel selects
+	// the nearest sample.
+	Kernel func(x float64) float64
+}
+
+var (
+	Nearest = &Filter{
+		Name: "nearest",
+	}
+	Bilinear = &Filter{
+		Name:    "bilinear",
+		Support: 1,
+		Kernel: func(x float64) float64 {
+			x = math.Abs(x)
+			if x < 1 {
+				return 1 - x
+			}
+			return 0
+		},
+	}
+	Bicubic = &Filter{
+		Name:    "bicubic",
+		Support: 2,
+		// Catmull-Rom spline (a = -0.5).
+		Kernel: func(x float64) float64 {
+			x = math.Abs(x)
+			switch {
+			case x < 1:
+				return (1.5*x-2.5)*x*x + 1
+			case x < 2:
+				return ((-0.5*x+2.5)*x-4)*x + 2
+			}
+			return 0
+		},
+	}
+	Lanczos3 = &Filter{
+		Name:    "lanczos3",
+		Support: 3,
+		Kernel: func(x float64) float64 {
+			x = math.Abs(x)
+			if x == 0 {
+				return 1
+			}
+			if x < 3 {
+				return 3 * math.Sin(math.Pi*x) * math.Sin(math.Pi*x/3) / (math.Pi * math.Pi * x * x)
+			}
+			return 0
+		},
+	}
+
+	// Filters lists the available resampling filters.
+	Filters = []*Filter{Nearest, Bilinear, Bicubic, Lanczos3}
+)
+
+// DefaultFilter is used when no filter is configured.
+const DefaultFilter = "bicubic"
+
+// GetFilterByName returns the named resampling filter, or nil if unknown.
+func GetFilterByName(name string) *Filter {
+	for _, f := range Filters {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// taps holds the precomputed contributions of source pixels to each output
+// pixel along one axis.
+type taps struct {
+	// Index of the first contributing source pixel, per output pixel.
+	start []int
+	// Fixed point weights, n per output pixel.
+	weights []int32
+	n       int
+}
+
+func (f *Filter) taps(srcLen, dstLen int) *taps {
+	scale := float64(srcLen) / float64(dstLen)
+
+	if f.Kernel == nil {
+		t := &taps{
+			start:   make([]int, dstLen),
+			weights: make([]int32, dstLen),
+			n:       1,
+		}
+		for i := range t.start {
+			s := int((float64(i) + 0.5) * scale)
+			if s >= srcLen {
+				s = srcLen - 1
+			}
+			t.start[i] = s
+			t.weights[i] = 1 << weightBits
+		}
+		return t
+	}
+
+	// Widen the kernel when downscaling to avoid aliasing.
+	fscale := math.Max(scale, 1)
+	support := f.Support * fscale
+	n := int(math.Ceil(support))*2 + 1
+	if n > srcLen {
+		n = srcLen
+	}
+
+	t := &taps{
+		start:   make([]int, dstLen),
+		weights: make([]int32, dstLen*n),
+		n:       n,
+	}
+	fw := make([]float64, n)
+	for i := 0; i < dstLen; i++ {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - support))
+		if start < 0 {
+			start = 0
+		}
+		if start+n > srcLen {
+			start = srcLen - n
+		}
+		t.start[i] = start
+
+		var sum float64
+		for k := 0; k < n; k++ {
+			fw[k] = f.Kernel((float64(start+k) - center) / fscale)
+			sum += fw[k]
+		}
+		// Normalize, distributing rounding error so the weights sum exactly to one.
+		var total int32
+		for k := 0; k < n; k++ {
+			w := int32(math.Round(fw[k] / sum * (1 << weightBits)))
+			t.weights[i*n+k] = w
+			total += w
+		}
+		t.weights[i*n+n/2] += (1 << weightBits) - total
+	}
+	return t
+}
+
+func clampPixel(v int32) uint8 {
+	v = (v + (1 << (weightBits - 1))) >> weightBits
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// parallelRows runs fn over [0, rows) split into contiguous chunks, one per CPU.
+func parallelRows(rows int, fn func(y0, y1 int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > rows {
+		workers = rows
+	}
+	chunk := (rows + workers - 1) / workers
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < rows; y0 += chunk {
+		y1 := y0 + chunk
+		if y1 > rows {
+			y1 = rows
+		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			fn(y0, y1)
+		}(y0, y1)
+	}
+	wg.Wait()
+}
+
+// resample scales src to fill dst using a separable filter. Rows are processed
+// in parallel. The intermediate frame is taken from the pool.
+func resample(dst, src *image.RGBA, f *Filter, pool *util.FramePool) {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	if sw == 0 || sh == 0 || dw == 0 || dh == 0 {
+		return
+	}
+
+	xt := f.taps(sw, dw)
+	yt := f.taps(sh, dh)
+
+	// Horizontal pass into an intermediate of size dw x sh.
+	tmpImg := pool.Get(image.Rect(0, 0, dw, sh))
+	defer pool.Put(tmpImg)
+	tmp, tmpStride := tmpImg.Pix, tmpImg.Stride
+	parallelRows(sh, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			srow := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+			trow := tmp[y*tmpStride:]
+			for x := 0; x < dw; x++ {
+				var r, g, b, a int32
+				s := xt.start[x] * 4
+				for _, w := range xt.weights[x*xt.n : (x+1)*xt.n] {
+					r += int32(srow[s]) * w
+					g += int32(srow[s+1]) * w
+					b += int32(srow[s+2]) * w
+					a += int32(srow[s+3]) * w
+					s += 4
+				}
+				trow[x*4] = clampPixel(r)
+				trow[x*4+1] = clampPixel(g)
+				trow[x*4+2] = clampPixel(b)
+				trow[x*4+3] = clampPixel(a)
+			}
+		}
+	})
+
+	// Vertical pass, accumulating whole rows at a time to stay cache friendly.
+	parallelRows(dh, func(y0, y1 int) {
+		acc := make([]int32, tmpStride)
+		for y := y0; y < y1; y++ {
+			for i := range acc {
+				acc[i] = 0
+			}
+			s := yt.start[y]
+			for k, w := range yt.weights[y*yt.n : (y+1)*yt.n] {
+				trow := tmp[(s+k)*tmpStride : (s+k+1)*tmpStride]
+				for i, v := range trow {
+					acc[i] += int32(v) * w
+				}
+			}
+			drow := dst.Pix[dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):]
+			for i, v := range acc {
+				drow[i] = clampPixel(v)
+			}
+		}
+	})
+}
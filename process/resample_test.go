@@ -0,0 +1,194 @@
+package process
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"timelapse-queue/util"
+
+	"github.com/nfnt/resize"
+)
+
+var update = flag.Bool("update", false, "Regenerate golden images in testdata")
+
+// testPattern builds a deterministic image with gradients, fine detail and
+// hard edges to exercise the resampling kernels.
+func testPattern(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{
+				R: uint8(255 * x / w),
+				G: uint8(255 * y / h),
+				A: 255,
+			}
+			if (x/4+y/4)%2 == 0 {
+				c.B = 255
+			}
+			if x > w/2 && y > h/2 && (x+y)%3 == 0 {
+				c.R, c.G, c.B = 255, 255, 255
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func psnr(a, b *image.RGBA) float64 {
+	var mse float64
+	for i := range a.Pix {
+		d := float64(a.Pix[i]) - float64(b.Pix[i])
+		mse += d * d
+	}
+	mse /= float64(len(a.Pix))
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestResampleGolden(t *testing.T) {
+	src := testPattern(97, 73)
+	sizes := []image.Point{{X: 41, Y: 31}, {X: 130, Y: 98}}
+	for _, f := range Filters {
+		for _, sz := range sizes {
+			name := fmt.Sprintf("resize_%s_%dx%d.png", f.Name, sz.X, sz.Y)
+			t.Run(name, func(t *testing.T) {
+				r := &Resizer{Size: sz, Filter: f}
+				got := r.resize(src)
+				path := filepath.Join("testdata", name)
+
+				if *update {
+					out, err := os.Create(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer out.Close()
+					if err := png.Encode(out, got); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				in, err := os.Open(path)
+				if err != nil {
+					t.Fatalf("open golden (run with -update to create): %v", err)
+				}
+				defer in.Close()
+				g, err := png.Decode(in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, ok := g.(*image.RGBA)
+				if !ok || want.Rect != got.Rect {
+					t.Fatalf("golden has unexpected format %T %v", g, g.Bounds())
+				}
+				if p := psnr(got, want); p < 50 {
+					t.Errorf("output differs from golden, PSNR %.1f dB", p)
+				}
+			})
+		}
+	}
+}
+
+func TestResampleMatchesReference(t *testing.T) {
+	src := testPattern(97, 73)
+	tests := []struct {
+		filter *Filter
+		interp resize.InterpolationFunction
+	}{
+		{Bilinear, resize.Bilinear},
+		{Bicubic, resize.Bicubic},
+		{Lanczos3, resize.Lanczos3},
+	}
+	for _, test := range tests {
+		t.Run(test.filter.Name, func(t *testing.T) {
+			r := &Resizer{Size: image.Point{X: 41, Y: 31}, Filter: test.filter}
+			got := r.resize(src)
+			want := resize.Resize(41, 31, src, test.interp).(*image.RGBA)
+			if p := psnr(got, want); p < 40 {
+				t.Errorf("output diverges from reference implementation, PSNR %.1f dB", p)
+			}
+		})
+	}
+}
+
+func TestResampleConstant(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 50, 40))
+	for i := range src.Pix {
+		src.Pix[i] = 123
+	}
+	// Use a sub-image to exercise non-zero origins.
+	sub := src.SubImage(image.Rect(5, 5, 45, 35)).(*image.RGBA)
+	for _, f := range Filters {
+		r := &Resizer{Size: image.Point{X: 17, Y: 23}, Filter: f}
+		got := r.resize(sub)
+		for i, v := range got.Pix {
+			if v != 123 {
+				t.Fatalf("%s: pixel byte %d is %d, want 123", f.Name, i, v)
+			}
+		}
+	}
+}
+
+func TestResizeSubImage(t *testing.T) {
+	src := testPattern(64, 48)
+	sub := src.SubImage(image.Rect(8, 4, 40, 28)).(*image.RGBA)
+	r := &Resizer{Size: image.Point{X: 32, Y: 24}}
+	out := r.resize(sub)
+	if out.Rect != image.Rect(0, 0, 32, 24) || len(out.Pix) != 4*32*24 {
+		t.Fatalf("resize() = %v with %d bytes, want a compact frame at the origin", out.Rect, len(out.Pix))
+	}
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			if got, want := out.RGBAAt(x, y), src.RGBAAt(x+8, y+4); got != want {
+				t.Fatalf("resize() at (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if full := r.resize(out); full != out {
+		t.Errorf("resize() of a compact frame at size copied it")
+	}
+}
+
+func TestResizeCopyReleasesInputOnce(t *testing.T) {
+	pool := util.NewFramePool()
+	src := pool.Get(image.Rect(0, 0, 64, 48))
+	sub := src.SubImage(image.Rect(8, 4, 40, 28)).(*image.RGBA)
+	r := &Resizer{Size: image.Point{X: 32, Y: 24}, Pool: pool}
+
+	// The crop already has the output size, so it is copied; the input must
+	// stay live until Process releases it.
+	out := r.resize(sub)
+	if out == sub {
+		t.Fatalf("resize() returned the cropped input")
+	}
+	if other := pool.Get(src.Rect); &other.Pix[0] == &src.Pix[0] {
+		t.Fatalf("resize() released its input")
+	}
+	pool.Put(out)
+
+	inc := make(chan *image.RGBA, 1)
+	inc <- sub
+	close(inc)
+	outc, _ := r.Process(context.Background(), inc, make(chan error, 1))
+	for range outc {
+	}
+
+	first := pool.Get(src.Rect)
+	second := pool.Get(src.Rect)
+	if &first.Pix[0] != &src.Pix[0] {
+		t.Errorf("Process() did not release its input")
+	}
+	if &second.Pix[0] == &src.Pix[0] {
+		t.Errorf("Process() released its input twice")
+	}
+}
@@ -2,25 +2,45 @@ package process
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"image"
 
-	"github.com/nfnt/resize"
+	"timelapse-queue/util"
 )
 
 type Resizer struct {
 	Size image.Point
+
+	// Filter is the resampling kernel; nil uses the default.
+	Filter *Filter
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
 }
 
-func (r *Resizer) resize(in *image.RGBA) (*image.RGBA, error) {
-	// TODO check performance?
-	small := resize.Resize(uint(r.Size.X), uint(r.Size.Y), in, resize.Bicubic)
-	//small := resize.Resize(uint(r.Size.X), uint(r.Size.Y), in, resize.NearestNeighbor)
-	img, ok := small.(*image.RGBA)
-	if !ok {
-		return nil, fmt.Errorf("Inexpected image format after resize")
+// resize returns in scaled to r.Size. The input is left to the caller to
+// release, even when a new frame is returned.
+func (r *Resizer) resize(in *image.RGBA) *image.RGBA {
+	if in.Rect.Size() == r.Size {
+		if in.Rect.Min == (image.Point{}) && len(in.Pix) == 4*r.Size.X*r.Size.Y {
+			return in
+		}
+		// Cropped sub-images are copied, as later stages expect compact
+		// frames at the origin.
+		out := r.Pool.Get(image.Rectangle{Max: r.Size})
+		for y := 0; y < r.Size.Y; y++ {
+			copy(out.Pix[y*out.Stride:(y+1)*out.Stride], in.Pix[y*in.Stride:])
+		}
+		return out
 	}
-	return img, nil
+	f := r.Filter
+	if f == nil {
+		f = GetFilterByName(DefaultFilter)
+	}
+	out := r.Pool.Get(image.Rectangle{Max: r.Size})
+	resample(out, in, f, r.Pool)
+	return out
 }
 
 func (r *Resizer) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
@@ -28,10 +48,9 @@ func (r *Resizer) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan
 	go func() {
 		defer close(outc)
 		for in := range inc {
-			out, err := r.resize(in)
-			if err != nil {
-				errc <- err
-				return
+			out := r.resize(in)
+			if out != in {
+				r.Pool.Put(in)
 			}
 			select {
 			case <-ctx.Done():
@@ -42,3 +61,33 @@ func (r *Resizer) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan
 	}()
 	return outc, errc
 }
+
+type resizeParams struct {
+	// Filter names the resampling filter, defaulting to the job's.
+	Filter string
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name:        "resize",
+		Resize:      true,
+		JobGeometry: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			p := resizeParams{Filter: env.Filter}
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Filter == "" {
+				p.Filter = DefaultFilter
+			}
+			f := GetFilterByName(p.Filter)
+			if f == nil {
+				return nil, fmt.Errorf("invalid resampling filter %v", p.Filter)
+			}
+			if env.Output.X <= 0 || env.Output.Y <= 0 {
+				return nil, fmt.Errorf("invalid output size %v", env.Output)
+			}
+			return &Resizer{Size: env.Output, Filter: f, Pool: env.Pool}, nil
+		},
+	})
+}
@@ -2,14 +2,21 @@ package process
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"image"
 	"math"
 
+	"timelapse-queue/util"
+
 	"github.com/BurntSushi/graphics-go/graphics"
 )
 
 type Rotate struct {
 	Degrees int
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
 }
 
 func toRadians(deg int) float64 {
@@ -36,8 +43,13 @@ func SizeAfterRotate(sz image.Rectangle, degrees int) image.Rectangle {
 }
 
 func (r *Rotate) rotate(in *image.RGBA) *image.RGBA {
-	dst := image.NewRGBA(SizeAfterRotate(in.Bounds(), r.Degrees))
-	graphics.Rotate(dst, in, &graphics.RotateOptions{toRadians(r.Degrees)})
+	dst := r.Pool.Get(SizeAfterRotate(in.Bounds(), r.Degrees))
+	// Pooled frames may hold stale data, and corners outside the source are
+	// not drawn.
+	for i := range dst.Pix {
+		dst.Pix[i] = 0
+	}
+	graphics.Rotate(dst, in, &graphics.RotateOptions{Angle: toRadians(r.Degrees)})
 	return dst
 }
 
@@ -46,7 +58,11 @@ func (r *Rotate) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan
 	go func() {
 		defer close(outc)
 		for img := range inc {
-			out := r.rotate(img)
+			out := img
+			if r.Degrees != 0 {
+				out = r.rotate(img)
+				r.Pool.Put(img)
+			}
 			select {
 			case <-ctx.Done():
 				return
@@ -56,3 +72,21 @@ func (r *Rotate) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan
 	}()
 	return outc, errc
 }
+
+func init() {
+	// Rotation is part of the job geometry, set by the job rather than params.
+	RegisterStage(&StageType{
+		Name:        "rotate",
+		Geometric:   true,
+		JobGeometry: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			if len(params) > 0 {
+				return nil, fmt.Errorf("rotation is set by the job")
+			}
+			if env.Rotate < -180 || env.Rotate > 180 {
+				return nil, fmt.Errorf("rotation must be between -180 and 180 degrees")
+			}
+			return &Rotate{Degrees: env.Rotate, Pool: env.Pool}, nil
+		},
+	})
+}
@@ -0,0 +1,155 @@
+package process
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	minSpeed = 0.05
+	maxSpeed = 100
+)
+
+// SpeedKeyframe sets the playback speed at a source frame, as a multiple of
+// the job's frame skip. Speeds below 1 slow down playback by repeating frames.
+type SpeedKeyframe struct {
+	Frame int
+	Speed float64
+}
+
+// ValidateSpeed checks keyframes are in frame order with speeds in range.
+func ValidateSpeed(keys []SpeedKeyframe) error {
+	for i, k := range keys {
+		if err := checkRange("speed", k.Speed, minSpeed, maxSpeed); err != nil {
+			return err
+		}
+		if i > 0 && k.Frame <= keys[i-1].Frame {
+			return fmt.Errorf("speed keyframes must be in increasing frame order")
+		}
+	}
+	return nil
+}
+
+// SpeedAt returns the speed at frame, linearly interpolated between keyframes.
+func SpeedAt(keys []SpeedKeyframe, frame float64) float64 {
+	if len(keys) == 0 {
+		return 1
+	}
+	if frame <= float64(keys[0].Frame) {
+		return keys[0].Speed
+	}
+	for i := 1; i < len(keys); i++ {
+		if frame > float64(keys[i].Frame) {
+			continue
+		}
+		a, b := keys[i-1], keys[i]
+		t := (frame - float64(a.Frame)) / float64(b.Frame-a.Frame)
+		return lerp(a.Speed, b.Speed, t)
+	}
+	return keys[len(keys)-1].Speed
+}
+
+// SpeedSchedule returns the source frames read for each output frame over
+// start to end, advancing skip frames at speed 1.
+func SpeedSchedule(keys []SpeedKeyframe, start, end, skip int) []int {
+	var frames []int
+	for pos := float64(start); ; {
+		idx := int(math.Round(pos))
+		if idx > end {
+			break
+		}
+		frames = append(frames, idx)
+		pos += float64(skip) * SpeedAt(keys, pos)
+	}
+	return frames
+}
+
+// realTimeSteps calls fn for each output frame of a real-time schedule, with
+// its capture time t and the index j into times of the frame captured nearest.
+func realTimeSteps(times []float64, start int, interval float64, keys []SpeedKeyframe, fn func(t float64, j int)) {
+	if len(times) == 0 || interval <= 0 {
+		return
+	}
+	last := times[len(times)-1]
+	j := 0
+	for t := times[0]; t <= last; {
+		// Select the frame captured nearest to t.
+		for j+1 < len(times) && math.Abs(times[j+1]-t) <= math.Abs(times[j]-t) {
+			j++
+		}
+		fn(t, j)
+		t += interval * SpeedAt(keys, float64(start+j))
+	}
+}
+
+// RealTimeSchedule returns the source frames read for each output frame so
+// that each advances interval seconds of capture time, times the speed. times
+// are the capture times in seconds of the frames from start on, increasing.
+// Frames are repeated or dropped where the capture interval varies.
+func RealTimeSchedule(times []float64, start int, interval float64, keys []SpeedKeyframe) []int {
+	var frames []int
+	realTimeSteps(times, start, interval, keys, func(_ float64, j int) {
+		frames = append(frames, start+j)
+	})
+	return frames
+}
+
+// BlendFrame is an output frame blended from source frames A and B, with
+// weight W given to B.
+type BlendFrame struct {
+	A, B int
+	W    float64
+}
+
+// RealTimeBlendSchedule is RealTimeSchedule, but blends the frames captured
+// either side of each output frame's time, weighted by time, rather than
+// selecting the nearest. Repeated frames then fade into the next instead of
+// stuttering.
+func RealTimeBlendSchedule(times []float64, start int, interval float64, keys []SpeedKeyframe) []BlendFrame {
+	var frames []BlendFrame
+	realTimeSteps(times, start, interval, keys, func(t float64, j int) {
+		// The last frame captured at or before t, and the next one.
+		a := j
+		for a > 0 && times[a] > t {
+			a--
+		}
+		f := BlendFrame{A: start + a, B: start + a}
+		if b := a + 1; b < len(times) && t > times[a] {
+			f.B, f.W = start+b, (t-times[a])/(times[b]-times[a])
+		}
+		frames = append(frames, f)
+	})
+	return frames
+}
+
+// BlendReads returns the source frames read for a blend schedule, in order.
+func BlendReads(frames []BlendFrame) []int {
+	var reads []int
+	add := func(idx int) {
+		if n := len(reads); n == 0 || reads[n-1] < idx {
+			reads = append(reads, idx)
+		}
+	}
+	for _, f := range frames {
+		add(f.A)
+		if f.W > 0 {
+			add(f.B)
+		}
+	}
+	return reads
+}
+
+// MedianInterval returns the median interval between increasing capture
+// times, in seconds.
+func MedianInterval(times []float64) float64 {
+	if len(times) < 2 {
+		return 0
+	}
+	d := make([]float64, len(times)-1)
+	for i := range d {
+		d[i] = times[i+1] - times[i]
+	}
+	sort.Float64s(d)
+	return d[len(d)/2]
+}
@@ -0,0 +1,105 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpeedSchedule(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []SpeedKeyframe
+		skip int
+		want []int
+	}{
+		{
+			name: "constant",
+			skip: 2,
+			want: []int{10, 12, 14, 16, 18, 20},
+		},
+		{
+			name: "slow motion repeats frames",
+			keys: []SpeedKeyframe{{Frame: 0, Speed: 0.5}},
+			skip: 1,
+			want: []int{10, 11, 11, 12, 12, 13, 13, 14, 14, 15, 15, 16, 16, 17, 17, 18, 18, 19, 19, 20, 20},
+		},
+		{
+			name: "ramp",
+			keys: []SpeedKeyframe{{Frame: 10, Speed: 1}, {Frame: 20, Speed: 4}},
+			skip: 1,
+			want: []int{10, 11, 12, 14, 16, 19},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := SpeedSchedule(tc.keys, 10, 20, tc.skip); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("SpeedSchedule() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateSpeed(t *testing.T) {
+	if err := ValidateSpeed([]SpeedKeyframe{{Frame: 0, Speed: 1}, {Frame: 10, Speed: 8}}); err != nil {
+		t.Errorf("ValidateSpeed() error = %v", err)
+	}
+	if err := ValidateSpeed([]SpeedKeyframe{{Frame: 10, Speed: 1}, {Frame: 10, Speed: 2}}); err == nil {
+		t.Errorf("ValidateSpeed() with repeated frame succeeded")
+	}
+	if err := ValidateSpeed([]SpeedKeyframe{{Frame: 0, Speed: 0}}); err == nil {
+		t.Errorf("ValidateSpeed() with zero speed succeeded")
+	}
+}
+
+func TestRealTimeSchedule(t *testing.T) {
+	// Two second intervals, then ten seconds.
+	times := []float64{0, 2, 4, 6, 8, 10, 20, 30, 40}
+	if got := MedianInterval(times); got != 2 {
+		t.Errorf("MedianInterval() = %v, want 2", got)
+	}
+	// At four seconds per frame, the start is thinned and the night repeated.
+	want := []int{5, 7, 9, 10, 11, 11, 11, 12, 12, 13, 13}
+	if got := RealTimeSchedule(times, 5, 4, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("RealTimeSchedule() = %v, want %v", got, want)
+	}
+	// Doubling the speed halves the output.
+	want = []int{5, 9, 11, 11, 12, 13}
+	if got := RealTimeSchedule(times, 5, 4, []SpeedKeyframe{{Frame: 0, Speed: 2}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("RealTimeSchedule() at speed 2 = %v, want %v", got, want)
+	}
+}
+
+func TestRealTimeBlendSchedule(t *testing.T) {
+	// Two second intervals, then ten seconds.
+	times := []float64{0, 2, 4, 6, 8, 10, 20, 30, 40}
+	// At four seconds per frame, the night fades between captures rather than
+	// repeating them.
+	want := []BlendFrame{
+		{A: 5, B: 5}, {A: 7, B: 7}, {A: 9, B: 9},
+		{A: 10, B: 11, W: 0.2}, {A: 10, B: 11, W: 0.6},
+		{A: 11, B: 11},
+		{A: 11, B: 12, W: 0.4}, {A: 11, B: 12, W: 0.8},
+		{A: 12, B: 13, W: 0.2}, {A: 12, B: 13, W: 0.6},
+		{A: 13, B: 13},
+	}
+	got := RealTimeBlendSchedule(times, 5, 4, nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RealTimeBlendSchedule() = %v, want %v", got, want)
+	}
+	// The same output frames as selecting the nearest.
+	if n := len(RealTimeSchedule(times, 5, 4, nil)); len(got) != n {
+		t.Errorf("RealTimeBlendSchedule() has %d frames, RealTimeSchedule() %d", len(got), n)
+	}
+
+	// Captures within the same second are not blended with each other.
+	got = RealTimeBlendSchedule([]float64{0, 0, 1, 1}, 0, 0.5, nil)
+	want = []BlendFrame{{A: 1, B: 1}, {A: 1, B: 2, W: 0.5}, {A: 3, B: 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RealTimeBlendSchedule() of repeated times = %v, want %v", got, want)
+	}
+
+	wantReads := []int{5, 7, 9, 10, 11, 12, 13}
+	if got := BlendReads(RealTimeBlendSchedule(times, 5, 4, nil)); !reflect.DeepEqual(got, wantReads) {
+		t.Errorf("BlendReads() = %v, want %v", got, wantReads)
+	}
+}
@@ -2,7 +2,12 @@ package process
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"image"
+	"image/draw"
+
+	"timelapse-queue/util"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,10 +20,14 @@ type Stacker struct {
 
 	// The underlying merge processor.
 	Merger Merger
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
 }
 
 type Merger interface {
-	Blend(img1, img2 *image.RGBA) *image.RGBA
+	// Blend combines two frames into a new frame obtained from the pool.
+	Blend(img1, img2 *image.RGBA, pool *util.FramePool) *image.RGBA
 }
 
 func GetMergerByName(mode string) Merger {
@@ -33,8 +42,8 @@ func GetMergerByName(mode string) Merger {
 
 type Lighten struct{}
 
-func (l *Lighten) Blend(img1, img2 *image.RGBA) *image.RGBA {
-	m := image.NewRGBA(img1.Rect)
+func (l *Lighten) Blend(img1, img2 *image.RGBA, pool *util.FramePool) *image.RGBA {
+	m := pool.Get(img1.Rect)
 	sz := len(img1.Pix)
 	for i := 0; i < sz; i++ {
 		// Maximum pixel value
@@ -49,8 +58,8 @@ func (l *Lighten) Blend(img1, img2 *image.RGBA) *image.RGBA {
 
 type Darken struct{}
 
-func (l *Darken) Blend(img1, img2 *image.RGBA) *image.RGBA {
-	m := image.NewRGBA(img1.Rect)
+func (l *Darken) Blend(img1, img2 *image.RGBA, pool *util.FramePool) *image.RGBA {
+	m := pool.Get(img1.Rect)
 	sz := len(img1.Pix)
 	for i := 0; i < sz; i++ {
 		// Minimum pixel value
@@ -81,37 +90,45 @@ func (s *Stacker) applySkipToWindow(in []int) []int {
 	return out
 }
 
+// emit sends a copy of img downstream, since the original remains in use by
+// the stacker. Returns false if the context is canceled.
+func (s *Stacker) emit(ctx context.Context, outc chan<- *image.RGBA, img *image.RGBA) bool {
+	out := s.Pool.Get(img.Rect)
+	draw.Draw(out, out.Rect, img, img.Rect.Min, draw.Src)
+	select {
+	case <-ctx.Done():
+		s.Pool.Put(out)
+		return false
+	case outc <- out:
+		return true
+	}
+}
+
 func (s *Stacker) overlapWindow(ctx context.Context, inc <-chan *image.RGBA, outc chan<- *image.RGBA) {
-	buf := &Buffer{}
+	buf := &Buffer{Pool: s.Pool}
 	frame := 0
-	window := []int{}
+	window := &slidingWindow{size: s.Overlap}
 
 	for img := range inc {
 		buf.Add(frame, img)
 
-		window = append(window, frame)
-		if len(window) > s.Overlap {
-			buf.RemoveOld(window[0])
-			window = window[1:]
+		if old, ok := window.push(frame); ok {
+			buf.RemoveOld(old)
 		}
 
-		select {
-		case <-ctx.Done():
+		if !s.emit(ctx, outc, buf.Generate(s.applySkipToWindow(window.frames), s.Merger)) {
 			return
-		case outc <- buf.Generate(s.applySkipToWindow(window), s.Merger):
 		}
 		frame += 1
 	}
 
-	for len(window) > 1 {
-		buf.RemoveOld(window[0])
-		window = window[1:]
-		select {
-		case <-ctx.Done():
+	for window.len() > 1 {
+		buf.RemoveOld(window.pop())
+		if !s.emit(ctx, outc, buf.Generate(window.frames, s.Merger)) {
 			return
-		case outc <- buf.Generate(window, s.Merger):
 		}
 	}
+	buf.RemoveOld(frame)
 }
 
 func (s *Stacker) overlapAll(ctx context.Context, inc <-chan *image.RGBA, outc chan<- *image.RGBA) {
@@ -120,14 +137,16 @@ func (s *Stacker) overlapAll(ctx context.Context, inc <-chan *image.RGBA, outc c
 		if hist == nil {
 			hist = img
 		} else {
-			hist = s.Merger.Blend(img, hist)
+			next := s.Merger.Blend(img, hist, s.Pool)
+			s.Pool.Put(img)
+			s.Pool.Put(hist)
+			hist = next
 		}
-		select {
-		case <-ctx.Done():
+		if !s.emit(ctx, outc, hist) {
 			return
-		case outc <- hist:
 		}
 	}
+	s.Pool.Put(hist)
 }
 
 func (s *Stacker) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
@@ -143,3 +162,47 @@ func (s *Stacker) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan
 	}()
 	return outc, errc
 }
+
+type stackParams struct {
+	// Window is the number of frames stacked, 0 stacks all frames.
+	Window int
+	// Skip is the frame skip within the window, 0 disables.
+	Skip int
+	Mode string
+}
+
+func init() {
+	RegisterStage(&StageType{
+		Name:       "stack",
+		InMetadata: true,
+		New: func(params json.RawMessage, env *StageEnv) (Process, error) {
+			var p stackParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			smax := env.Frames / env.Skip
+			if p.Window < 0 || env.Frames > 0 && p.Window > smax {
+				return nil, fmt.Errorf("window out of range 0..%d", smax)
+			}
+			if p.Skip < 0 || p.Skip > p.Window {
+				return nil, fmt.Errorf("skip count out of range")
+			}
+			m := GetMergerByName(p.Mode)
+			if m == nil {
+				return nil, fmt.Errorf("invalid stack mode %v", p.Mode)
+			}
+			return &Stacker{
+				Overlap: p.Window,
+				Skip:    p.Skip,
+				Merger:  m,
+				Pool:    env.Pool,
+			}, nil
+		},
+		// Stacking with a window adds trailing frames as the window empties.
+		Frames: func(params json.RawMessage, n int) int {
+			var p stackParams
+			decodeParams(params, &p)
+			return n + p.Window
+		},
+	})
+}
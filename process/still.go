@@ -0,0 +1,137 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"timelapse-queue/util"
+)
+
+// StillModes lists the ways a sequence can be reduced to a single image.
+//
+// startrail keeps the brightest value of each pixel over all frames. keogram
+// lays the center column of each frame side by side, so the output is one
+// column per frame wide. slitscan takes each column of the output from a
+// successive frame, sweeping through the sequence from left to right.
+var StillModes = []string{"startrail", "keogram", "slitscan"}
+
+func ValidStillMode(mode string) bool {
+	for _, m := range StillModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Still composites a stream of frames into a single image.
+type Still struct {
+	Mode string
+	// Frames is the number of frames in the stream, which sizes the keogram and
+	// spreads the slit-scan across the output.
+	Frames int
+
+	// Pool provides the output image; input frames are released to it.
+	Pool *util.FramePool
+}
+
+// Composite consumes all frames from inc and returns the composite. Upstream
+// errors on errc abort the composite.
+func (s *Still) Composite(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (*image.RGBA, error) {
+	if !ValidStillMode(s.Mode) {
+		return nil, fmt.Errorf("invalid still mode %v", s.Mode)
+	}
+	if s.Frames < 1 && s.Mode != "startrail" {
+		return nil, fmt.Errorf("%v requires the frame count", s.Mode)
+	}
+
+	var out *image.RGBA
+	var size image.Point
+	n := 0
+	for img := range inc {
+		if ctx.Err() != nil {
+			// Drain the stream so upstream stages can exit.
+			s.Pool.Put(img)
+			continue
+		}
+		if out == nil {
+			size = img.Rect.Size()
+		} else if img.Rect.Size() != size {
+			s.Pool.Put(img)
+			s.Pool.Put(out)
+			return nil, fmt.Errorf("frame %d size %v differs from first frame %v", n, img.Rect.Size(), size)
+		}
+		switch s.Mode {
+		case "startrail":
+			if out == nil {
+				out = s.Pool.Get(image.Rectangle{Max: img.Rect.Size()})
+				copyFrame(out, img)
+			} else {
+				lightenInto(out, img)
+			}
+		case "keogram":
+			if out == nil {
+				out = s.Pool.Get(image.Rect(0, 0, s.Frames, img.Rect.Dy()))
+			}
+			if n < s.Frames {
+				copyColumn(out, n, img, img.Rect.Dx()/2)
+			}
+		case "slitscan":
+			if out == nil {
+				// Columns past the end of a short sequence stay black.
+				out = s.Pool.Get(image.Rectangle{Max: size})
+				for i := range out.Pix {
+					out.Pix[i] = 0
+				}
+			}
+			w := out.Rect.Dx()
+			// Columns whose source frame x*Frames/w is n.
+			for x := (n*w + s.Frames - 1) / s.Frames; x < w && x*s.Frames/w == n; x++ {
+				copyColumn(out, x, img, x)
+			}
+		}
+		s.Pool.Put(img)
+		n++
+	}
+	if err := <-errc; err != nil {
+		s.Pool.Put(out)
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		s.Pool.Put(out)
+		return nil, err
+	}
+	if out == nil {
+		return nil, fmt.Errorf("no frames to composite")
+	}
+	if s.Mode == "keogram" && n < s.Frames {
+		// Fewer frames than expected, trim the unfilled columns.
+		out = out.SubImage(image.Rect(0, 0, n, out.Rect.Dy())).(*image.RGBA)
+	}
+	return out, nil
+}
+
+// lightenInto keeps the maximum of each value of dst and src, which must have
+// the same size.
+func lightenInto(dst, src *image.RGBA) {
+	w, h := src.Rect.Dx()*4, src.Rect.Dy()
+	for y := 0; y < h; y++ {
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+w]
+		so := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+		for i, v := range src.Pix[so : so+w] {
+			if v > d[i] {
+				d[i] = v
+			}
+		}
+	}
+}
+
+// copyColumn copies column sx of src to column dx of dst.
+func copyColumn(dst *image.RGBA, dx int, src *image.RGBA, sx int) {
+	for y := 0; y < src.Rect.Dy(); y++ {
+		so := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+y)
+		do := dst.PixOffset(dst.Rect.Min.X+dx, dst.Rect.Min.Y+y)
+		copy(dst.Pix[do:do+4], src.Pix[so:so+4])
+	}
+}
@@ -0,0 +1,93 @@
+package process
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// columnFrames returns n 4x2 frames, each with column x of frame i set to
+// 10*i + x.
+func columnFrames(n int) []*image.RGBA {
+	var frames []*image.RGBA
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 4; x++ {
+				o := img.PixOffset(x, y)
+				img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = uint8(10*i+x), 0, 0, 0xFF
+			}
+		}
+		frames = append(frames, img)
+	}
+	return frames
+}
+
+func TestStill(t *testing.T) {
+	tests := []struct {
+		name   string
+		still  Still
+		frames int
+		// want is the red value of each column of the first row.
+		want []uint8
+	}{
+		{
+			name:   "startrail",
+			still:  Still{Mode: "startrail"},
+			frames: 3,
+			want:   []uint8{20, 21, 22, 23},
+		},
+		{
+			name:   "keogram",
+			still:  Still{Mode: "keogram", Frames: 3},
+			frames: 3,
+			want:   []uint8{2, 12, 22},
+		},
+		{
+			name:   "short keogram",
+			still:  Still{Mode: "keogram", Frames: 5},
+			frames: 2,
+			want:   []uint8{2, 12},
+		},
+		{
+			name:   "slitscan",
+			still:  Still{Mode: "slitscan", Frames: 2},
+			frames: 2,
+			want:   []uint8{0, 1, 12, 13},
+		},
+		{
+			name:   "slitscan more frames than columns",
+			still:  Still{Mode: "slitscan", Frames: 8},
+			frames: 8,
+			want:   []uint8{0, 21, 42, 63},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inc := make(chan *image.RGBA, tc.frames)
+			for _, f := range columnFrames(tc.frames) {
+				inc <- f
+			}
+			close(inc)
+			errc := make(chan error)
+			close(errc)
+
+			out, err := tc.still.Composite(context.Background(), inc, errc)
+			if err != nil {
+				t.Fatalf("Composite() error = %v", err)
+			}
+			if out.Rect.Dy() != 2 {
+				t.Errorf("Composite() height = %d, want 2", out.Rect.Dy())
+			}
+			var got []uint8
+			for x := 0; x < out.Rect.Dx(); x++ {
+				got = append(got, out.RGBAAt(x, 0).R)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Composite() columns mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package process
+
+import (
+	"context"
+	"image"
+
+	"timelapse-queue/util"
+)
+
+// TimeBlend produces the frames of a blend schedule, from a stream of the
+// source frames given by BlendReads.
+type TimeBlend struct {
+	Frames []BlendFrame
+
+	// Pool provides output frames; input frames are released to it.
+	Pool *util.FramePool
+}
+
+func (b *TimeBlend) Process(ctx context.Context, inc <-chan *image.RGBA, errc chan error) (<-chan *image.RGBA, chan error) {
+	outc := make(chan *image.RGBA)
+	go func() {
+		defer close(outc)
+		reads := BlendReads(b.Frames)
+		// Source frames still needed, by index.
+		held := make(map[int]*image.RGBA)
+		defer func() {
+			for _, img := range held {
+				b.Pool.Put(img)
+			}
+		}()
+		r := 0
+		// read receives source frames until idx is held, false if the input
+		// ended first, e.g. on a read error.
+		read := func(idx int) bool {
+			for held[idx] == nil {
+				img, ok := <-inc
+				if !ok {
+					return false
+				}
+				if r >= len(reads) {
+					b.Pool.Put(img)
+					continue
+				}
+				held[reads[r]] = img
+				r++
+			}
+			return true
+		}
+
+		for k, f := range b.Frames {
+			if !read(f.A) || (f.W > 0 && !read(f.B)) {
+				return
+			}
+			var out *image.RGBA
+			if f.W > 0 {
+				out = mixFrames(b.Pool, held[f.A], held[f.B], uint32(f.W*(1<<16)+0.5))
+			} else {
+				// Held frames may be used again, so output a copy.
+				src := held[f.A]
+				out = b.Pool.Get(image.Rectangle{Max: src.Rect.Size()})
+				copyFrame(out, src)
+			}
+			// Release frames before those the next output frame blends.
+			if k+1 < len(b.Frames) {
+				for idx, img := range held {
+					if idx < b.Frames[k+1].A {
+						b.Pool.Put(img)
+						delete(held, idx)
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				b.Pool.Put(out)
+				return
+			case outc <- out:
+			}
+		}
+		for img := range inc {
+			b.Pool.Put(img)
+		}
+	}()
+	return outc, errc
+}
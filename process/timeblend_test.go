@@ -0,0 +1,60 @@
+package process
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"timelapse-queue/util"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTimeBlend(t *testing.T) {
+	frames := []BlendFrame{
+		{A: 10, B: 10},
+		{A: 10, B: 11, W: 0.5},
+		{A: 11, B: 11},
+		{A: 11, B: 13, W: 0.25},
+		{A: 13, B: 13},
+	}
+	tests := []struct {
+		name  string
+		input []uint8
+		want  []uint8
+	}{
+		{
+			name:  "all frames",
+			input: []uint8{0, 100, 200},
+			want:  []uint8{0, 50, 100, 125, 200},
+		},
+		{
+			name:  "input ends early",
+			input: []uint8{0, 100},
+			want:  []uint8{0, 50, 100},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &TimeBlend{Frames: frames, Pool: util.NewFramePool()}
+			inc := make(chan *image.RGBA)
+			go func() {
+				defer close(inc)
+				for _, v := range test.input {
+					inc <- solidFrame(v)
+				}
+			}()
+			outc, _ := b.Process(context.Background(), inc, make(chan error, 1))
+			got := []uint8{}
+			for img := range outc {
+				if img.Rect.Size() != image.Pt(4, 3) {
+					t.Errorf("frame size = %v, want 4x3", img.Rect.Size())
+				}
+				got = append(got, img.Pix[0])
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("TimeBlend output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package process
+
+// slidingWindow tracks the indices of the most recent frames of a stream, up
+// to a fixed size. Stages buffer the frame images themselves.
+type slidingWindow struct {
+	size   int
+	frames []int
+}
+
+// push adds a frame to the window, returning the oldest frame if it was
+// evicted to make room.
+func (w *slidingWindow) push(frame int) (int, bool) {
+	w.frames = append(w.frames, frame)
+	if len(w.frames) > w.size {
+		return w.pop(), true
+	}
+	return 0, false
+}
+
+// pop removes and returns the oldest frame in the window.
+func (w *slidingWindow) pop() int {
+	f := w.frames[0]
+	w.frames = w.frames[1:]
+	return f
+}
+
+func (w *slidingWindow) len() int {
+	return len(w.frames)
+}
+
+func (w *slidingWindow) first() int {
+	return w.frames[0]
+}
@@ -0,0 +1,19 @@
+package util
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(os.TempDir())
+	if err != nil {
+		t.Fatalf("FreeSpace() error = %v", err)
+	}
+	if free == 0 {
+		t.Errorf("FreeSpace() = 0, want free space")
+	}
+	if _, err := FreeSpace("/does/not/exist"); err == nil {
+		t.Errorf("FreeSpace() of missing path succeeded")
+	}
+}
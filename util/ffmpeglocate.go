@@ -29,3 +29,19 @@ func LocateFFmpegOrDie() string {
 	}
 	return p
 }
+
+// LocateFFprobe finds the location of the ffprobe binary, looking in common
+// locations.
+func LocateFFprobe() (string, error) {
+	// Check environment.
+	if p := os.Getenv("FFPROBE"); p != "" {
+		return p, nil
+	}
+
+	// Check PATH.
+	p, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return "", err
+	}
+	return p, nil
+}
@@ -0,0 +1,151 @@
+package util
+
+import (
+	"image"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Maximum number of idle buffers retained for each frame size.
+	maxFreeFrames = 4
+)
+
+var (
+	framePoolHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "timelapse_frame_pool_hits_total",
+		Help: "Number of frame buffers served from the pool.",
+	})
+	framePoolMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "timelapse_frame_pool_misses_total",
+		Help: "Number of frame buffers allocated because the pool had none free.",
+	})
+	frameLiveBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "timelapse_frame_live_bytes",
+		Help: "Bytes of frame buffers currently held by the processing pipeline.",
+	})
+)
+
+// FramePool recycles RGBA frame buffers between pipeline stages.
+//
+// A frame obtained from Get (or registered with Adopt) should be returned with
+// Put once it has been consumed. Sub-images (e.g. from cropping) share their
+// parent's buffer, so putting a sub-image releases the parent.
+//
+// Decoded source frames are not drawn from the pool: libjpeg allocates its own
+// destination, which Adopt registers so the buffer can serve later Gets of the
+// same size. Beyond maxFreeFrames idle buffers they are left to the GC, so a
+// full resolution allocation per decoded frame remains.
+//
+// A nil *FramePool is valid and simply allocates fresh frames.
+type FramePool struct {
+	mu sync.Mutex
+	// Idle buffers, by length.
+	free map[int][][]uint8
+	// Buffers currently in use, keyed by the address of their final byte so
+	// that sub-images resolve to the same buffer as their parent.
+	live      map[*uint8][]uint8
+	liveBytes int
+}
+
+func NewFramePool() *FramePool {
+	return &FramePool{
+		free: make(map[int][][]uint8),
+		live: make(map[*uint8][]uint8),
+	}
+}
+
+func bufferKey(pix []uint8) *uint8 {
+	if cap(pix) == 0 {
+		return nil
+	}
+	full := pix[:cap(pix)]
+	return &full[len(full)-1]
+}
+
+func (p *FramePool) track(pix []uint8) {
+	p.live[bufferKey(pix)] = pix
+	p.liveBytes += len(pix)
+	frameLiveBytes.Add(float64(len(pix)))
+}
+
+// Get returns a frame covering r. The pixel contents are undefined; callers
+// are expected to overwrite the entire frame.
+func (p *FramePool) Get(r image.Rectangle) *image.RGBA {
+	if p == nil {
+		return image.NewRGBA(r)
+	}
+	n := 4 * r.Dx() * r.Dy()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var pix []uint8
+	if l := p.free[n]; len(l) > 0 {
+		pix = l[len(l)-1]
+		p.free[n] = l[:len(l)-1]
+		framePoolHits.Inc()
+	} else {
+		pix = make([]uint8, n)
+		framePoolMisses.Inc()
+	}
+	p.track(pix)
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// Adopt registers a frame allocated elsewhere (e.g. by the JPEG decoder) so
+// that its buffer is recycled once put.
+func (p *FramePool) Adopt(img *image.RGBA) {
+	if p == nil || img == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.live[bufferKey(img.Pix)]; ok {
+		return
+	}
+	p.track(img.Pix[:cap(img.Pix)])
+}
+
+// Put releases a frame back to the pool. The frame (and any sub-images sharing
+// its buffer) must no longer be used. Frames unknown to the pool are ignored.
+func (p *FramePool) Put(img *image.RGBA) {
+	if p == nil || img == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := bufferKey(img.Pix)
+	pix, ok := p.live[key]
+	if !ok {
+		return
+	}
+	delete(p.live, key)
+	p.liveBytes -= len(pix)
+	frameLiveBytes.Sub(float64(len(pix)))
+
+	if l := p.free[len(pix)]; len(l) < maxFreeFrames {
+		p.free[len(pix)] = append(l, pix)
+	}
+}
+
+// Close drops all buffers held by the pool, including any that were never
+// put back (e.g. frames abandoned in flight by a canceled job).
+func (p *FramePool) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	frameLiveBytes.Sub(float64(p.liveBytes))
+	p.liveBytes = 0
+	p.free = make(map[int][][]uint8)
+	p.live = make(map[*uint8][]uint8)
+}
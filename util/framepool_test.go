@@ -0,0 +1,37 @@
+package util
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFramePoolSubImage(t *testing.T) {
+	p := NewFramePool()
+	img := p.Get(image.Rect(0, 0, 8, 8))
+	sub := img.SubImage(image.Rect(2, 2, 6, 6)).(*image.RGBA)
+
+	// Releasing the sub-image should release the parent buffer.
+	p.Put(sub)
+	if len(p.live) != 0 {
+		t.Fatalf("got %d live buffers after put, want 0", len(p.live))
+	}
+
+	got := p.Get(image.Rect(0, 0, 8, 8))
+	if &got.Pix[0] != &img.Pix[0] {
+		t.Errorf("expected released buffer to be reused")
+	}
+}
+
+func TestFramePoolIgnoresUnknown(t *testing.T) {
+	p := NewFramePool()
+	p.Put(image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if len(p.free) != 0 {
+		t.Errorf("unknown frame should not be pooled")
+	}
+
+	var nilPool *FramePool
+	if img := nilPool.Get(image.Rect(0, 0, 2, 2)); len(img.Pix) != 16 {
+		t.Errorf("nil pool returned %d bytes, want 16", len(img.Pix))
+	}
+	nilPool.Put(nil)
+}
@@ -73,13 +73,13 @@ class Queue extends PolymerElement {
               </div>
               <div class="item-details">
                 <div hidden$="[[item.Config.RenameOnly]]">
-                    <div class="jobname">[[item.Config.OutputName]].mp4</div>
+                    <div class="jobname">[[item.OutputFile]]<span hidden$="[[!item.Preview]]"> (PREVIEW)</span></div>
                     <div>[[item.Config.OutputProfileName]]</div>
                     <div>[[item.TimelapseName]]</div>
                     <div>[[item.ExpectedFrames]] images</div>
                 </div>
                 <div hidden$="[[!item.Config.RenameOnly]]">
-                    <div class="jobname">[[item.Config.OutputName]]000000.jpg (RENAME)</div>
+                    <div class="jobname">[[item.OutputFile]]000000.jpg (RENAME)</div>
                     <div>[[item.TimelapseName]]</div>
                     <div>[[item.ExpectedFrames]] images</div>
                 </div>
@@ -90,6 +90,7 @@ class Queue extends PolymerElement {
                 <div>
                     <div>[[item.State]]</div>
                     <div hidden$="[[!item.ElapsedString]]">[[item.ElapsedString]]</div>
+                    <div hidden$="[[!item.Error]]">[[item.Error]]</div>
                 </div>
                 <div hidden$="[[!isState_(item, 'active')]]">
                     <paper-button data-jobid$="[[item.ID]]" data-url="/queue-cancel" data-opname="cancel" on-tap="onOp_" raised>Cancel</paper-button>
@@ -98,8 +99,26 @@ class Queue extends PolymerElement {
                     <paper-button class="remove-button" data-jobid$="[[item.ID]]" data-url="/queue-remove" data-opname="remove" on-tap="onOp_" raised>Remove</paper-button>
                 </div>
                 <div hidden$="[[!item.LogPath]]">
-                  <a href="/log?path=[[item.ImagePath]]&name=[[item.LogPath]]" target="_blank">Log</a>
+                  <a href="/log?path=[[item.ImagePath]]&loc=[[item.Config.OutputLocation]]&dir=[[item.OutputDir]]&name=[[item.LogPath]]" target="_blank">Log</a>
                 </div>
+                <template is="dom-if" if="[[isPreviewDone_(item)]]">
+                  <div>
+                    <video controls preload="metadata" src="/queue-preview?id=[[item.ID]]"></video>
+                  </div>
+                  <div>
+                    <paper-button data-jobid$="[[item.ID]]" data-url="/queue-promote" data-opname="promote" on-tap="onOp_" raised>Render Full Job</paper-button>
+                  </div>
+                </template>
+                <template is="dom-if" if="[[isOutputDone_(item, '.mp4')]]">
+                  <div>
+                    <video controls preload="metadata" src="/video?path=[[item.ImagePath]]&loc=[[item.Config.OutputLocation]]&dir=[[item.OutputDir]]&name=[[item.OutputFile]]"></video>
+                  </div>
+                </template>
+                <template is="dom-if" if="[[isOutputDone_(item, '.mp4', '.gif', '.webp')]]">
+                  <div>
+                    <a href="/video?path=[[item.ImagePath]]&loc=[[item.Config.OutputLocation]]&dir=[[item.OutputDir]]&name=[[item.OutputFile]]" target="_blank">Output</a>
+                  </div>
+                </template>
               </div>
             </div>
           </template>
@@ -130,6 +149,18 @@ class Queue extends PolymerElement {
     return !!j && states.some(s => j.State === s);
   }
 
+  isOutputDone_(j, ...exts) {
+    if (!j || j.Preview || j.State !== 'done' || !j.OutputFile) {
+      return false;
+    }
+    const name = j.OutputFile.toLowerCase();
+    return exts.some(ext => name.endsWith(ext));
+  }
+
+  isPreviewDone_(j) {
+    return !!j && j.Preview && j.State === 'done';
+  }
+
   onOp_(e) {
     const jobid = e.target.dataset.jobid;
     const url = e.target.dataset.url;
@@ -72,6 +72,9 @@ class Setup extends PolymerElement {
         #container img {
           max-width: 100%;
         }
+        .preview img {
+          max-width: 49%;
+        }
         .cropcontainer {
           max-width: 900px;
         }
@@ -111,6 +114,13 @@ class Setup extends PolymerElement {
           handle-as="json"
           on-response="onTimelapseAjax_"
           ></iron-ajax>
+      <iron-ajax
+          id="estimateajax"
+          url="/estimate"
+          handle-as="json"
+          last-response="{{estimate_}}"
+          on-error="onEstimateError_"
+          ></iron-ajax>
       <iron-ajax
           id="profilesajax"
           url="/profiles"
@@ -143,6 +153,38 @@ class Setup extends PolymerElement {
           </paper-checkbox>
         </p>
 
+        <p hidden$="[[renameOnly_]]">
+          <div class="helptext">
+            <div>Instead of a video, the processed frames can be exported as numbered images for grading in other tools.</div>
+            <div>The sequence can also be composited into a single full resolution image.</div>
+            <div>Star trails keep the brightest value of each pixel over all frames.</div>
+            <div>A keogram lays the center column of each frame side by side.</div>
+            <div>A slit-scan takes each column from a successive frame, left to right.</div>
+          </div>
+          <paper-dropdown-menu label="Output Type" no-animations>
+            <paper-listbox attr-for-selected="value" selected="{{outputType_}}" slot="dropdown-content">
+              <paper-item value="">Video</paper-item>
+              <paper-item value="sequence">Processed image sequence</paper-item>
+              <paper-item value="startrail">Star trail composite</paper-item>
+              <paper-item value="keogram">Keogram</paper-item>
+              <paper-item value="slitscan">Slit-scan</paper-item>
+            </paper-listbox>
+          </paper-dropdown-menu>
+          <paper-dropdown-menu label="Image Format" no-animations hidden$="[[!outputType_]]">
+            <paper-listbox attr-for-selected="value" selected="{{imageFormat_}}" slot="dropdown-content">
+              <paper-item value="jpeg">JPEG</paper-item>
+              <paper-item value="png">PNG</paper-item>
+              <paper-item value="tiff">TIFF</paper-item>
+              <paper-item value="tiff16">16-bit TIFF</paper-item>
+            </paper-listbox>
+          </paper-dropdown-menu>
+          <template is="dom-if" if="[[isEqual_(outputType_, 'sequence')]]">
+            <paper-checkbox checked="{{exportExif_}}">
+              Copy EXIF from source frames (JPEG only)
+            </paper-checkbox>
+          </template>
+        </p>
+
         <p class="medium-input">
           <paper-input
                   id="output-filename"
@@ -150,16 +192,43 @@ class Setup extends PolymerElement {
                   value="{{filename_}}"
                   always-float-label
                   auto-validate
-                  pattern="[a-zA-Z0-9-_ ]+"
+                  pattern="[a-zA-Z0-9-_ {}]+"
                   error-message="Not a valid filename"
                   autofocus
                   >
-            <span slot="suffix" hidden$="[[renameOnly_]]">.mp4</span>
-            <span slot="suffix" hidden$="[[!renameOnly_]]">000000.jpg</span>
+            <span slot="suffix">[[outputSuffix_(renameOnly_, outputType_, imageFormat_, animated_)]]</span>
           </paper-input>
+          <div class="helptext">
+            May contain {date}, {name}, {profile} and {codec}.
+          </div>
         </p>
 
-        <div hidden$="[[renameOnly_]]">
+        <p>
+          <paper-dropdown-menu label="Output Location" no-animations>
+            <paper-listbox attr-for-selected="value" selected="{{outputLocation_}}" slot="dropdown-content">
+              <paper-item value="">Server default</paper-item>
+              <paper-item value="source">Next to the images</paper-item>
+              <paper-item value="browse">Folder in the browse root</paper-item>
+              <paper-item value="output">Folder in the output root</paper-item>
+            </paper-listbox>
+          </paper-dropdown-menu>
+          <paper-dropdown-menu label="Existing Output" no-animations>
+            <paper-listbox attr-for-selected="value" selected="{{overwrite_}}" slot="dropdown-content">
+              <paper-item value="fail">Fail</paper-item>
+              <paper-item value="overwrite">Overwrite</paper-item>
+              <paper-item value="version">Add version suffix</paper-item>
+            </paper-listbox>
+          </paper-dropdown-menu>
+          <template is="dom-if" if="[[isFolderLocation_(outputLocation_)]]">
+            <paper-input
+                    label="Output Folder"
+                    value="{{outputDir_}}"
+                    always-float-label
+                    ></paper-input>
+          </template>
+        </p>
+
+        <div hidden$="[[or_(renameOnly_, isStill_(outputType_))]]">
           <p>
             <div class="helptext">
              <div>Select the resolution of the output file.</div>
@@ -187,16 +256,116 @@ class Setup extends PolymerElement {
               </paper-listbox>
             </paper-dropdown-menu>
           </p>
+
+          <p hidden$="[[outputType_]]">
+            <div class="helptext">
+             <div>A looping animated GIF or WebP can be produced for quick sharing instead of an MP4.</div>
+             <div>Use a small output resolution and frame rate to keep the file size down.</div>
+            </div>
+            <paper-dropdown-menu label="Video Format" no-animations>
+              <paper-listbox attr-for-selected="value" selected="{{animated_}}" slot="dropdown-content">
+                <paper-item value="">MP4</paper-item>
+                <paper-item value="gif">Animated GIF</paper-item>
+                <paper-item value="webp">Animated WebP</paper-item>
+              </paper-listbox>
+            </paper-dropdown-menu>
+            <paper-input
+                  class="short-input"
+                  label="Animation Frame Rate"
+                  type="number"
+                  min="1"
+                  max="30"
+                  value="{{animatedFPS_}}"
+                  hidden$="[[!animated_]]"
+                  always-float-label></paper-input>
+          </p>
+
+          <p>
+            <div class="helptext">
+             <div>Interpolation synthesizes frames between each source frame to lengthen short sequences.</div>
+             <div>Crossfade is fast; motion interpolation is smoother but much slower.</div>
+            </div>
+            <paper-dropdown-menu label="Interpolation" no-animations>
+              <paper-listbox attr-for-selected="value" selected="{{interpolate_}}" slot="dropdown-content">
+                <paper-item value="">None</paper-item>
+                <paper-item value="crossfade">Crossfade</paper-item>
+                <paper-item value="minterpolate">Motion interpolation</paper-item>
+              </paper-listbox>
+            </paper-dropdown-menu>
+            <paper-input
+                  class="short-input"
+                  label="Interpolation Factor"
+                  type="number"
+                  min="2"
+                  max="8"
+                  value="{{interpolateFactor_}}"
+                  hidden$="[[!interpolate_]]"
+                  always-float-label></paper-input>
+          </p>
+
+          <p>
+            <div class="helptext">
+             <div>The filter used to scale frames to the output resolution.</div>
+             <div>Lanczos is sharpest, nearest neighbor is fastest.</div>
+            </div>
+            <paper-dropdown-menu label="Resampling" no-animations>
+              <paper-listbox attr-for-selected="value" selected="{{resample_}}" slot="dropdown-content">
+                <paper-item value="">Profile default</paper-item>
+                <paper-item value="nearest">Nearest neighbor</paper-item>
+                <paper-item value="bilinear">Bilinear</paper-item>
+                <paper-item value="bicubic">Bicubic</paper-item>
+                <paper-item value="lanczos3">Lanczos</paper-item>
+              </paper-listbox>
+            </paper-dropdown-menu>
+          </p>
         </div>
 
+        <p hidden$="[[!supportsAudio_(renameOnly_, outputType_, animated_, preview_)]]">
+          <div class="helptext">
+            <div>Optionally add an audio track to MP4 output, relative to the browse root.</div>
+          </div>
+          <paper-input
+                class="medium-input"
+                label="Audio File"
+                value="{{audioPath_}}"
+                always-float-label></paper-input>
+          <div class="inputrow" hidden$="[[!audioPath_]]">
+            <paper-input
+                  class="short-input"
+                  label="Trim Start (s)"
+                  type="number"
+                  min="0"
+                  step="0.1"
+                  value="{{audioStart_}}"
+                  always-float-label></paper-input>
+            <paper-input
+                  class="short-input"
+                  label="Fade In (s)"
+                  type="number"
+                  min="0"
+                  step="0.1"
+                  value="{{audioFadeIn_}}"
+                  always-float-label></paper-input>
+            <paper-input
+                  class="short-input"
+                  label="Fade Out (s)"
+                  type="number"
+                  min="0"
+                  step="0.1"
+                  value="{{audioFadeOut_}}"
+                  always-float-label></paper-input>
+            <paper-checkbox checked="{{audioLoop_}}">Loop if shorter than the video</paper-checkbox>
+          </div>
+        </p>
+
         <p>
           <div>Output File</div>
           <div class="helptext infobox">
-            <div hidden$="[[renameOnly_]]">MP4 [[profile_.Width]]x[[profile_.Height]] [[fps_]] fps</div>
-            <div hidden$="[[!renameOnly_]]">Image Sequence</div>
+            <div>[[outputDescription_(renameOnly_, outputType_, imageFormat_, animated_, animatedFPS_, profile_, fps_, crop)]]</div>
             <div hidden$="[[!filename_]]">
-              <span>[[timelapse.OutputPath]][[filename_]]</span><span hidden$="[[renameOnly_]]">.mp4</span><span hidden$="[[!renameOnly_]]">000000.jpg</span>
+              <span>[[timelapse.OutputPath]][[filename_]]</span><span>[[outputSuffix_(renameOnly_, outputType_, imageFormat_, animated_)]]</span>
             </div>
+            <div hidden$="[[!estimate_.Bytes]]">[[formatEstimate_(estimate_)]]</div>
           </div>
         </p>
 
@@ -252,6 +421,50 @@ class Setup extends PolymerElement {
                 </div>
               </iron-collapse>
             </div>
+            <div>
+              <paper-checkbox checked="{{speedEnabled_}}">
+                Ramp Speed
+              </paper-checkbox>
+              <iron-collapse opened="[[speedEnabled_]]">
+                <div class="helptext">
+                  <div>Keyframes of playback speed by source frame, as "frame:speed" pairs separated by commas.</div>
+                  <div>For example, "0:1, 500:4, 800:0.5" speeds up fourfold by frame 500, then slows to half speed.</div>
+                </div>
+                <paper-input
+                      class="medium-input"
+                      label="Speed Curve"
+                      value="{{speedCurve_}}"
+                      auto-validate
+                      pattern="\\s*\\d+\\s*:\\s*[0-9.]+\\s*(,\\s*\\d+\\s*:\\s*[0-9.]+\\s*)*"
+                      error-message="Expected frame:speed pairs"
+                      always-float-label></paper-input>
+              </iron-collapse>
+            </div>
+            <div>
+              <paper-checkbox checked="{{normalizeEnabled_}}">
+                Normalize Capture Interval
+              </paper-checkbox>
+              <iron-collapse opened="[[normalizeEnabled_]]">
+                <div class="helptext">
+                  <div>Select frames by capture time, so the timelapse keeps a steady pace when the camera interval changed during the shoot.</div>
+                  <div>Leave the interval empty to use the most common interval between captures.</div>
+                </div>
+                <paper-input
+                      class="short-input"
+                      label="Interval (seconds)"
+                      type="number"
+                      min="0"
+                      step="any"
+                      value="{{normalizeInterval_}}"
+                      always-float-label></paper-input>
+                <paper-checkbox checked="{{normalizeBlend_}}">
+                  Blend Frames
+                </paper-checkbox>
+                <div class="helptext">
+                  <div>Fade between the frames captured either side of each output frame, rather than repeating or dropping frames.</div>
+                </div>
+              </iron-collapse>
+            </div>
           </p>
         </div>
 
@@ -383,6 +596,261 @@ class Setup extends PolymerElement {
           </p>
         </div>
 
+        <div hidden$="[[renameOnly_]]">
+          <p>
+            <div>
+                    <paper-checkbox checked="{{motionBlur_}}">
+                      Motion Blur
+                    </paper-checkbox>
+            </div>
+            <div>
+                    <iron-collapse opened="[[motionBlur_]]">
+                    <div class="stack-options">
+                          <div class="stack-option">
+                            <div class="helptext">
+                              <div>Blends neighbouring frames into each output frame for smoother motion.</div>
+                              <div>When skipping, the skipped frames are blended instead of discarded.</div>
+                              <div>Leave at 0 to blend exactly the skipped frames.</div>
+                            </div>
+                            <paper-input
+                                  class="short-input"
+                                  label="Frames to Blend"
+                                  type="number"
+                                  min="0"
+                                  max="[[timelapse.Count]]"
+                                  value="{{motionBlurWindow_}}"
+                                  always-float-label></paper-input>
+                         </div>
+                         <div class="stack-option">
+                            <paper-dropdown-menu label="Weighting" no-animations>
+                              <paper-listbox attr-for-selected="value" selected="{{motionBlurWeighting_}}" slot="dropdown-content">
+                                <paper-item value="box">Equal</paper-item>
+                                <paper-item value="triangle">Center Weighted</paper-item>
+                              </paper-listbox>
+                            </paper-dropdown-menu>
+                         </div>
+                    </div>
+                    </iron-collapse>
+            </div>
+          </p>
+        </div>
+
+        <div hidden$="[[renameOnly_]]">
+          <p>
+            <div>Lens Correction</div>
+            <div class="helptext">
+              <div>Corrects barrel distortion and dark corners from wide angle lenses, before cropping.</div>
+              <div>Choose a preset, or Custom to enter distortion coefficients directly.</div>
+            </div>
+            <div class="inputrow">
+              <paper-dropdown-menu label="Lens" no-animations>
+                <paper-listbox attr-for-selected="value" selected="{{lens_}}" slot="dropdown-content">
+                  <paper-item value="">None</paper-item>
+                  <paper-item value="gopro-wide">GoPro Wide</paper-item>
+                  <paper-item value="gopro-medium">GoPro Medium</paper-item>
+                  <paper-item value="gopro-narrow">GoPro Narrow</paper-item>
+                  <paper-item value="fisheye">Fisheye</paper-item>
+                  <paper-item value="custom">Custom</paper-item>
+                </paper-listbox>
+              </paper-dropdown-menu>
+              <template is="dom-if" if="[[isEqual_(lens_, 'custom')]]">
+                <paper-input class="short-input" label="K1" type="number" step="0.01" min="-1" max="1"
+                      value="{{lensK1_}}" always-float-label></paper-input>
+                <paper-input class="short-input" label="K2" type="number" step="0.01" min="-1" max="1"
+                      value="{{lensK2_}}" always-float-label></paper-input>
+                <paper-input class="short-input" label="Vignette" type="number" step="0.05" min="0" max="1"
+                      value="{{vignette_}}" always-float-label></paper-input>
+              </template>
+            </div>
+          </p>
+        </div>
+
+        <div hidden$="[[renameOnly_]]">
+          <p>
+            <div>Noise Reduction</div>
+            <div class="helptext">
+              <div>Combines each frame with its neighbours to reduce noise in high ISO night sequences.</div>
+              <div>Pixels which change more than the motion threshold between frames are left alone to avoid ghosting.</div>
+            </div>
+            <div class="inputrow">
+              <paper-dropdown-menu label="Denoise" no-animations>
+                <paper-listbox attr-for-selected="value" selected="{{denoise_}}" slot="dropdown-content">
+                  <paper-item value="">Off</paper-item>
+                  <paper-item value="mean">Mean</paper-item>
+                  <paper-item value="median">Median</paper-item>
+                </paper-listbox>
+              </paper-dropdown-menu>
+              <paper-input
+                    class="short-input"
+                    label="Radius (frames)"
+                    type="number"
+                    min="1"
+                    max="7"
+                    value="{{denoiseRadius_}}"
+                    disabled="[[!denoise_]]"
+                    always-float-label></paper-input>
+              <paper-input
+                    class="short-input"
+                    label="Motion Threshold"
+                    type="number"
+                    min="0"
+                    max="255"
+                    value="{{denoiseThreshold_}}"
+                    disabled="[[!denoise_]]"
+                    always-float-label></paper-input>
+            </div>
+          </p>
+        </div>
+
+        <div hidden$="[[renameOnly_]]">
+          <p>
+            <div>Color Grading</div>
+            <div class="helptext">
+              <div>Tonal adjustments applied to every frame.</div>
+            </div>
+            <div class="helptext inputrow">
+              <template is="dom-repeat" items="[[adjustFields_]]">
+                <paper-input
+                      type="number"
+                      step="0.05"
+                      min="[[item.min]]"
+                      max="[[item.max]]"
+                      value="[[getAdjust_(adjust_, item.name)]]"
+                      data-param$="[[item.name]]"
+                      on-value-changed="onUpdateAdjust_"
+                      label="[[item.label]]"
+                  ></paper-input>
+              </template>
+            </div>
+            <div class="helptext">
+              <div>Optionally apply a .cube 3D LUT, relative to the browse root.</div>
+            </div>
+            <paper-input
+                  class="medium-input"
+                  label="LUT File"
+                  value="{{lutPath_}}"
+                  always-float-label></paper-input>
+            <div hidden$="[[!lutPath_]]">
+              <paper-input
+                    class="short-input"
+                    label="Strength"
+                    type="number"
+                    min="0"
+                    max="1"
+                    step="0.05"
+                    value="{{lutStrength_}}"
+                    always-float-label></paper-input>
+              <paper-dropdown-menu label="Interpolation" no-animations>
+                <paper-listbox attr-for-selected="value" selected="{{lutInterpolation_}}" slot="dropdown-content">
+                  <paper-item value="trilinear">Trilinear</paper-item>
+                  <paper-item value="tetrahedral">Tetrahedral</paper-item>
+                </paper-listbox>
+              </paper-dropdown-menu>
+            </div>
+            <div class="inputrow">
+              <paper-input
+                    class="short-input"
+                    label="Preview Frame"
+                    type="number"
+                    min="0"
+                    max="[[getLastFrame_(timelapse)]]"
+                    value="{{previewFrame_}}"
+                    always-float-label></paper-input>
+              <paper-button on-tap="onPreview_">
+                     <iron-icon icon="visibility"></iron-icon>
+                    Preview Before / After
+              </paper-button>
+            </div>
+            <div class="preview" hidden$="[[!previewAfter_]]">
+              <img src="[[previewBefore_]]">
+              <img src="[[previewAfter_]]">
+            </div>
+          </p>
+        </div>
+
+        <div hidden$="[[renameOnly_]]">
+          <p>
+            <div>Overlay</div>
+            <div class="helptext">
+              <div>Text burned into every frame. Supports [[overlayFieldsHelp_]].</div>
+              <div>The time is read from the image EXIF data, falling back to the file modification time.</div>
+            </div>
+            <paper-input
+                  class="medium-input"
+                  label="Text"
+                  value="{{overlayText_}}"
+                  always-float-label></paper-input>
+            <div class="inputrow" hidden$="[[!overlayText_]]">
+              <paper-input
+                    class="short-input"
+                    label="Font"
+                    value="{{overlayFont_}}"
+                    always-float-label></paper-input>
+              <paper-input
+                    class="short-input"
+                    label="Size (px)"
+                    type="number"
+                    min="0"
+                    value="{{overlaySize_}}"
+                    always-float-label></paper-input>
+              <paper-dropdown-menu label="Position" no-animations>
+                <paper-listbox attr-for-selected="value" selected="{{overlayPosition_}}" slot="dropdown-content">
+                  <paper-item value="top-left">Top Left</paper-item>
+                  <paper-item value="top-right">Top Right</paper-item>
+                  <paper-item value="bottom-left">Bottom Left</paper-item>
+                  <paper-item value="bottom-right">Bottom Right</paper-item>
+                  <paper-item value="center">Center</paper-item>
+                </paper-listbox>
+              </paper-dropdown-menu>
+              <paper-input
+                    class="short-input"
+                    label="Color"
+                    value="{{overlayColor_}}"
+                    always-float-label></paper-input>
+              <paper-input
+                    class="short-input"
+                    label="Background"
+                    value="{{overlayBackground_}}"
+                    always-float-label></paper-input>
+            </div>
+            <div class="helptext">
+              <div>Optionally draw a PNG watermark, relative to the browse root.</div>
+            </div>
+            <paper-input
+                  class="medium-input"
+                  label="Watermark File"
+                  value="{{watermarkPath_}}"
+                  always-float-label></paper-input>
+            <div class="inputrow" hidden$="[[!watermarkPath_]]">
+              <paper-input
+                    class="short-input"
+                    label="Opacity"
+                    type="number"
+                    min="0"
+                    max="1"
+                    step="0.05"
+                    value="{{watermarkOpacity_}}"
+                    always-float-label></paper-input>
+              <paper-input
+                    class="short-input"
+                    label="Width (px)"
+                    type="number"
+                    min="0"
+                    value="{{watermarkWidth_}}"
+                    always-float-label></paper-input>
+              <paper-dropdown-menu label="Position" no-animations>
+                <paper-listbox attr-for-selected="value" selected="{{watermarkPosition_}}" slot="dropdown-content">
+                  <paper-item value="top-left">Top Left</paper-item>
+                  <paper-item value="top-right">Top Right</paper-item>
+                  <paper-item value="bottom-left">Bottom Left</paper-item>
+                  <paper-item value="bottom-right">Bottom Right</paper-item>
+                  <paper-item value="center">Center</paper-item>
+                </paper-listbox>
+              </paper-dropdown-menu>
+            </div>
+          </p>
+        </div>
+
         <p>
           <div>Advanced Options</div>
           <div class="helptext">
@@ -400,6 +868,24 @@ class Setup extends PolymerElement {
           </div>
         </p>
 
+        <p hidden$="[[or_(renameOnly_, outputType_)]]">
+          <div class="helptext">
+            <div>A preview render is a quick 480p MP4 of the full job, to check settings before a long render.</div>
+            <div>Reading only every Nth frame makes it faster still. Once done it can be promoted to the full job from the queue.</div>
+          </div>
+          <paper-checkbox checked="{{preview_}}">
+            Preview Render
+          </paper-checkbox>
+          <paper-input
+                class="short-input"
+                label="Every Nth Frame"
+                type="number"
+                min="1"
+                value="{{previewEvery_}}"
+                hidden$="[[!preview_]]"
+                always-float-label></paper-input>
+        </p>
+
         <div class="startbutton">
             <div class="error" hidden$="[[!error_]]">
               <iron-icon icon="error"></iron-icon>
@@ -440,10 +926,100 @@ class Setup extends PolymerElement {
     });
   }
  
+  isEqual_(a, b) {
+    return a === b;
+  }
+
+  isStill_(outputType) {
+    return !!outputType && outputType !== 'sequence';
+  }
+
+  outputSuffix_(renameOnly, outputType, imageFormat, animated) {
+    if (renameOnly) {
+      return '000000.jpg';
+    }
+    const ext = {'jpeg': '.jpg', 'png': '.png', 'tiff': '.tif', 'tiff16': '.tif'}[imageFormat];
+    if (outputType === 'sequence') {
+      return '/000000' + ext;
+    }
+    if (outputType) {
+      return ext;
+    }
+    if (animated) {
+      return '.' + animated;
+    }
+    return '.mp4';
+  }
+
+  outputDescription_(renameOnly, outputType, imageFormat, animated, animatedFPS, profile, fps, crop) {
+    if (renameOnly) {
+      return 'Image Sequence';
+    }
+    if (this.isStill_(outputType)) {
+      return imageFormat + ' image ' + (crop ? crop.width + 'x' + crop.height : '');
+    }
+    const size = profile ? profile.Width + 'x' + profile.Height : '';
+    if (outputType === 'sequence') {
+      return 'Processed ' + imageFormat + ' image sequence ' + size;
+    }
+    if (animated) {
+      return 'Animated ' + animated + ' ' + size + ' ' + animatedFPS + ' fps';
+    }
+    return 'MP4 ' + size + ' ' + fps + ' fps';
+  }
+
+  formatEstimate_(estimate) {
+    if (!estimate) {
+      return '';
+    }
+    let size = estimate.Bytes / (1024 * 1024);
+    let unit = 'MB';
+    if (size >= 1024) {
+      size /= 1024;
+      unit = 'GB';
+    }
+    let s = 'Estimated size ~' + size.toFixed(1) + ' ' + unit;
+    if (estimate.Duration) {
+      s += ', ' + estimate.Frames + ' frames, ' + estimate.Duration.toFixed(1) + 's';
+    }
+    return s;
+  }
+
+  updateEstimate_() {
+    if (!this.enableObservers_) {
+      return;
+    }
+    // Settings change rapidly while dragging sliders and the crop box.
+    clearTimeout(this.estimateTimer_);
+    this.estimateTimer_ = setTimeout(() => {
+      this.$.estimateajax.params = {'request': JSON.stringify(this.buildConfig_())};
+      this.$.estimateajax.generateRequest();
+    }, 500);
+  }
+
+  onEstimateError_(e) {
+    this.estimate_ = null;
+  }
+
   or_(a, b) {
           return a || b;
   }
 
+  supportsAudio_(renameOnly, outputType, animated, preview) {
+    return !renameOnly && !outputType && (!animated || preview);
+  }
+
+  parseSpeedCurve_(curve) {
+    return (curve || '').split(',')
+        .map(k => k.split(':'))
+        .filter(k => k.length === 2)
+        .map(k => ({'Frame': parseInt(k[0], 10), 'Speed': parseFloat(k[1])}));
+  }
+
+  isFolderLocation_(loc) {
+    return loc === 'browse' || loc === 'output';
+  }
+
   min_(a, b) {
     if (!a || !b) {
       return 0;
@@ -467,8 +1043,7 @@ class Setup extends PolymerElement {
       this.initCropboxIfReady_();
   }     
   
-  onConvert_(e) {
-    this.$.convertajax.headers={'content-type': 'application/x-www-form-urlencoded'};
+  buildConfig_() {
     const config = {
       'Path': this.path,
       'X': this.crop.x,
@@ -477,15 +1052,65 @@ class Setup extends PolymerElement {
       'Height': this.crop.height,
       'Rotate': this.crop.rotate,
       'OutputName': this.filename_,
+      'OutputLocation': this.outputLocation_,
+      'OutputDir': this.isFolderLocation_(this.outputLocation_) ? this.outputDir_ : '',
+      'Overwrite': this.overwrite_,
+      'AudioPath': this.supportsAudio_(this.renameOnly_, this.outputType_, this.animated_, this.preview_) ?
+          this.audioPath_ : '',
+      'AudioStart': parseFloat(this.audioStart_) || 0,
+      'AudioFadeIn': parseFloat(this.audioFadeIn_) || 0,
+      'AudioFadeOut': parseFloat(this.audioFadeOut_) || 0,
+      'AudioLoop': this.audioLoop_,
       'FrameRate': parseInt(this.fps_, 10),
       'StartFrame': this.startFrame_,
       'EndFrame': this.endFrame_,
       'Skip': this.skipEnabled_ ? parseInt(this.skip_, 10) : 0,
+      'Speed': this.speedEnabled_ ? this.parseSpeedCurve_(this.speedCurve_) : [],
+      'Normalize': this.normalizeEnabled_,
+      'NormalizeInterval': this.normalizeEnabled_ ? parseFloat(this.normalizeInterval_) || 0 : 0,
+      'NormalizeBlend': this.normalizeEnabled_ && this.normalizeBlend_,
       'Stack': this.stack_,
       'StackWindow': this.stackAll_ ? 0 : parseInt(this.stackWindow_, 10),
       'StackSkipCount': this.stackSkip_ ? parseInt(this.stackSkipCount_, 10) : 0,
       'StackMode': this.stackMode_,
       'OutputProfileName': this.profile_.Name,
+      'Resample': this.resample_,
+      'Interpolate': this.isStill_(this.outputType_) ? '' : this.interpolate_,
+      'InterpolateFactor': this.interpolate_ && !this.isStill_(this.outputType_) ?
+          parseInt(this.interpolateFactor_, 10) : 0,
+      'Adjust': [Object.assign({'Frame': this.startFrame_}, this.adjust_)],
+      'LUTPath': this.lutPath_,
+      'LUTStrength': parseFloat(this.lutStrength_),
+      'LUTInterpolation': this.lutInterpolation_,
+      'OverlayText': this.overlayText_,
+      'OverlayFont': this.overlayFont_,
+      'OverlaySize': parseFloat(this.overlaySize_) || 0,
+      'OverlayPosition': this.overlayPosition_,
+      'OverlayColor': this.overlayColor_,
+      'OverlayBackground': this.overlayBackground_,
+      'WatermarkPath': this.watermarkPath_,
+      'WatermarkOpacity': parseFloat(this.watermarkOpacity_),
+      'WatermarkWidth': parseInt(this.watermarkWidth_, 10) || 0,
+      'WatermarkPosition': this.watermarkPosition_,
+      'Lens': this.lens_ === 'custom' ? '' : this.lens_,
+      'LensK1': this.lens_ === 'custom' ? parseFloat(this.lensK1_) : 0,
+      'LensK2': this.lens_ === 'custom' ? parseFloat(this.lensK2_) : 0,
+      'Vignette': this.lens_ === 'custom' ? parseFloat(this.vignette_) : 0,
+      'Denoise': this.denoise_,
+      'DenoiseRadius': this.denoise_ ? parseInt(this.denoiseRadius_, 10) : 0,
+      'DenoiseThreshold': this.denoise_ ? parseInt(this.denoiseThreshold_, 10) : 0,
+      'MotionBlur': this.motionBlur_,
+      'MotionBlurWindow': parseInt(this.motionBlurWindow_, 10),
+      'MotionBlurWeighting': this.motionBlurWeighting_,
+      'Animated': this.renameOnly_ || this.outputType_ ? '' : this.animated_,
+      'AnimatedFPS': this.animated_ ? parseInt(this.animatedFPS_, 10) : 0,
+      'Still': !this.renameOnly_ && this.isStill_(this.outputType_) ? this.outputType_ : '',
+      'StillFormat': this.isStill_(this.outputType_) ? this.imageFormat_ : '',
+      'Export': !this.renameOnly_ && this.outputType_ === 'sequence',
+      'ExportFormat': this.outputType_ === 'sequence' ? this.imageFormat_ : '',
+      'ExportExif': this.outputType_ === 'sequence' && this.exportExif_,
+      'Preview': this.preview_ && !this.renameOnly_ && !this.outputType_,
+      'PreviewEvery': this.preview_ ? parseInt(this.previewEvery_, 10) || 0 : 0,
       'RenameOnly': this.renameOnly_,
     };
     if (this.$.profilecpu.checked) {
@@ -494,6 +1119,41 @@ class Setup extends PolymerElement {
     if (this.$.profilemem.checked) {
       config['ProfileMem'] = true;
     }
+    return config;
+  }
+
+  previewURL_(before) {
+    const params = new URLSearchParams({
+      'request': JSON.stringify(this.buildConfig_()),
+      'index': this.previewFrame_ || this.startFrame_ || 0,
+    });
+    if (before) {
+      params.set('before', '1');
+    }
+    return '/preview?' + params.toString();
+  }
+
+  onPreview_(e) {
+    this.previewBefore_ = this.previewURL_(true);
+    this.previewAfter_ = this.previewURL_(false);
+  }
+
+  getAdjust_(adjust, name) {
+    return adjust[name] || 0;
+  }
+
+  onUpdateAdjust_(e) {
+    const param = e.target.dataset.param;
+    const v = parseFloat(e.detail.value);
+    if (!param || isNaN(v)) {
+      return;
+    }
+    this.adjust_ = Object.assign({}, this.adjust_, {[param]: v});
+  }
+
+  onConvert_(e) {
+    this.$.convertajax.headers={'content-type': 'application/x-www-form-urlencoded'};
+    const config = this.buildConfig_();
 
     this.$.convertajax.body = {
         'request': JSON.stringify(config),
@@ -512,9 +1172,15 @@ class Setup extends PolymerElement {
     this.startFrame_ = 0;
     this.endFrame_ = 0;
     this.skipEnabled_ = false;
+    this.speedEnabled_ = false;
     this.stack_ = false;
     this.stackSkip_ = false;
+    this.motionBlur_ = false;
+    this.adjust_ = {};
+    this.previewBefore_ = "";
+    this.previewAfter_ = "";
     this.renameOnly_ = false;
+    this.preview_ = false;
     this.rotate = 0;
     this.cropper.destroy();
   }
@@ -612,6 +1278,14 @@ class Setup extends PolymerElement {
     }
   }
 
+  static get observers() {
+    return [
+      'updateEstimate_(crop, profile_, fps_, startFrame_, endFrame_, skip_, skipEnabled_, ' +
+          'stack_, stackWindow_, interpolate_, interpolateFactor_, animated_, animatedFPS_, ' +
+          'outputType_, imageFormat_, renameOnly_, speedEnabled_, speedCurve_, audioPath_)',
+    ];
+  }
+
   static get properties() {
     return {
       path: {
@@ -646,6 +1320,175 @@ class Setup extends PolymerElement {
         type: String,
         value: "60",
       },
+      adjust_: {
+        type: Object,
+        value: () => ({}),
+      },
+      adjustFields_: {
+        type: Array,
+        value: () => [
+          {name: 'Exposure', label: 'Exposure (EV)', min: -5, max: 5},
+          {name: 'Contrast', label: 'Contrast', min: -1, max: 1},
+          {name: 'Highlights', label: 'Highlights', min: -1, max: 1},
+          {name: 'Shadows', label: 'Shadows', min: -1, max: 1},
+          {name: 'Temperature', label: 'Temperature', min: -1, max: 1},
+          {name: 'Tint', label: 'Tint', min: -1, max: 1},
+          {name: 'Saturation', label: 'Saturation', min: -1, max: 1},
+          {name: 'Gamma', label: 'Gamma', min: 0, max: 5},
+        ],
+      },
+      previewFrame_: {
+        type: Number,
+        value: 0,
+      },
+      previewBefore_: {
+        type: String,
+        value: "",
+      },
+      previewAfter_: {
+        type: String,
+        value: "",
+      },
+      lutPath_: {
+        type: String,
+        value: "",
+      },
+      lutStrength_: {
+        type: Number,
+        value: 1,
+      },
+      lutInterpolation_: {
+        type: String,
+        value: "trilinear",
+      },
+      overlayText_: {
+        type: String,
+        value: "",
+      },
+      overlayFieldsHelp_: {
+        type: String,
+        value: '{{.Time.Format "Jan 2 2006 15:04"}}, {{.Frame}}, {{.Index}} and {{.Name}}',
+      },
+      overlayFont_: {
+        type: String,
+        value: "regular",
+      },
+      overlaySize_: {
+        type: Number,
+        value: 0,
+      },
+      overlayPosition_: {
+        type: String,
+        value: "bottom-right",
+      },
+      overlayColor_: {
+        type: String,
+        value: "#ffffff",
+      },
+      overlayBackground_: {
+        type: String,
+        value: "#00000080",
+      },
+      watermarkPath_: {
+        type: String,
+        value: "",
+      },
+      watermarkOpacity_: {
+        type: Number,
+        value: 1,
+      },
+      watermarkWidth_: {
+        type: Number,
+        value: 0,
+      },
+      watermarkPosition_: {
+        type: String,
+        value: "top-right",
+      },
+      lens_: {
+        type: String,
+        value: "",
+      },
+      lensK1_: {
+        type: Number,
+        value: 0,
+      },
+      lensK2_: {
+        type: Number,
+        value: 0,
+      },
+      vignette_: {
+        type: Number,
+        value: 0,
+      },
+      denoise_: {
+        type: String,
+        value: "",
+      },
+      denoiseRadius_: {
+        type: Number,
+        value: 2,
+      },
+      denoiseThreshold_: {
+        type: Number,
+        value: 24,
+      },
+      motionBlur_: {
+        type: Boolean,
+        value: false,
+      },
+      motionBlurWindow_: {
+        type: Number,
+        value: 0,
+      },
+      motionBlurWeighting_: {
+        type: String,
+        value: "box",
+      },
+      interpolate_: {
+        type: String,
+        value: "",
+      },
+      outputType_: {
+        type: String,
+        value: "",
+      },
+      animated_: {
+        type: String,
+        value: "",
+      },
+      animatedFPS_: {
+        type: Number,
+        value: 15,
+      },
+      estimate_: {
+        type: Object,
+        value: null,
+      },
+      imageFormat_: {
+        type: String,
+        value: "jpeg",
+      },
+      exportExif_: {
+        type: Boolean,
+        value: false,
+      },
+      preview_: {
+        type: Boolean,
+        value: false,
+      },
+      previewEvery_: {
+        type: Number,
+        value: 1,
+      },
+      interpolateFactor_: {
+        type: Number,
+        value: 2,
+      },
+      resample_: {
+        type: String,
+        value: "",
+      },
       skip_: {
         type: Number,
         value: 2,
@@ -654,6 +1497,26 @@ class Setup extends PolymerElement {
         type: Boolean,
         value: false,
       },
+      speedEnabled_: {
+        type: Boolean,
+        value: false,
+      },
+      speedCurve_: {
+        type: String,
+        value: "",
+      },
+      normalizeEnabled_: {
+        type: Boolean,
+        value: false,
+      },
+      normalizeInterval_: {
+        type: String,
+        value: "",
+      },
+      normalizeBlend_: {
+        type: Boolean,
+        value: false,
+      },
       stackAll_: {
         type: Boolean,
         value: false,
@@ -669,6 +1532,38 @@ class Setup extends PolymerElement {
       filename_: {
         type: String,
       },
+      outputLocation_: {
+        type: String,
+        value: "",
+      },
+      outputDir_: {
+        type: String,
+        value: "",
+      },
+      overwrite_: {
+        type: String,
+        value: "fail",
+      },
+      audioPath_: {
+        type: String,
+        value: "",
+      },
+      audioStart_: {
+        type: Number,
+        value: 0,
+      },
+      audioFadeIn_: {
+        type: Number,
+        value: 0,
+      },
+      audioFadeOut_: {
+        type: Number,
+        value: 0,
+      },
+      audioLoop_: {
+        type: Boolean,
+        value: true,
+      },
       startFrame_: {
         type: Number,
         observer: 'onFrame_',